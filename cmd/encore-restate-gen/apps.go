@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// appEnv names the app a generator started for a multi-app repository runs for; it prefixes its log.
+const appEnv = "ENCORE_RESTATE_GEN_APP"
+
+// isEncoreApp reports whether dir is the root of an Encore app.
+func isEncoreApp(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, encoreAppFile))
+	return err == nil
+}
+
+// encoreAppDirs returns the Encore apps below root when root is not an app itself, e.g. in a
+// repository holding several apps. Apps nested in another app belong to that app.
+func encoreAppDirs(root string) []string {
+	if isEncoreApp(root) {
+		return nil
+	}
+	var apps []string
+	walkDirs(root, func(dir string) error {
+		if !isEncoreApp(dir) {
+			return nil
+		}
+		for _, app := range apps {
+			if strings.HasPrefix(dir, app+string(filepath.Separator)) {
+				return nil
+			}
+		}
+		apps = append(apps, dir)
+		return nil
+	})
+	return apps
+}
+
+// multiAppMain runs the generator for each of the Encore apps of a repository in its own process,
+// with args, the command and flags it was started with, so each app gets its own restate.gen,
+// dependencies and tsconfig.json. It fails if the generator of any app failed.
+func multiAppMain(root string, apps []string, args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the generator executable: %v", err)
+	}
+	var (
+		mu     sync.Mutex
+		cmds   []*exec.Cmd
+		failed []string
+		wg     sync.WaitGroup
+	)
+	for _, app := range apps {
+		name, err := filepath.Rel(root, app)
+		if err != nil {
+			name = app
+		}
+		log.Printf("Running the generator for the Encore app in %s", app)
+		cmd := exec.Command(exe, append(append([]string{}, args...), app)...)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		cmd.Env = append(os.Environ(), appEnv+"="+filepath.ToSlash(name))
+		if err := cmd.Start(); err != nil {
+			for _, started := range cmds {
+				started.Process.Kill()
+			}
+			return fmt.Errorf("failed to start the generator for %s: %v", app, err)
+		}
+		cmds = append(cmds, cmd)
+		wg.Add(1)
+		go func(cmd *exec.Cmd, name string) {
+			defer wg.Done()
+			if err := cmd.Wait(); err != nil {
+				mu.Lock()
+				failed = append(failed, name)
+				mu.Unlock()
+			}
+		}(cmd, name)
+	}
+	// The generators share the terminal, so they get Ctrl-C themselves; a SIGTERM is passed on.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		for _, cmd := range cmds {
+			cmd.Process.Signal(sig)
+		}
+	}()
+	wg.Wait()
+	if len(failed) > 0 {
+		return fmt.Errorf("the generator failed for %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
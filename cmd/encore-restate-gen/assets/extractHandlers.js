@@ -1,16 +1,30 @@
 #!/usr/bin/env node
 "use strict";
 
-const { Project, SyntaxKind, Node } = require("ts-morph");
+const { Project, SyntaxKind, Node, ts } = require("ts-morph");
 const fs = require("fs");
 const path = require("path");
+const readline = require("readline");
+
+/**
+ * The manifest format version. Must match manifestSchemaVersion in the Go binary.
+ */
+const SCHEMA_VERSION = 1;
+
+/**
+ * The path alias of the generated code. Its target does not exist before the first generation,
+ * so imports through it are not reported as unresolved.
+ */
+const GENERATED_ALIAS = "~restate";
 
 /**
  * Extracts handler definitions from a given TypeScript file.
  *
  * It looks for exported functions or variables (whose initializer is an arrow function,
  * function expression, or a call expression wrapping such a function) and then inspects
- * the first parameter's type annotation (assumed to be the context).
+ * the first parameter's type annotation (assumed to be the context). Exported classes are
+ * searched too: each public method whose context parameter is annotated, or any public method
+ * of a class decorated with @service, @object or @workflow, is a handler.
  *
  * The handler type is determined as follows:
  *   - If the type includes "WorkflowContext" or "WorkflowSharedContext": type is "workflow"
@@ -18,86 +32,565 @@ const path = require("path");
  *   - Otherwise, if the type includes "Context": type is "service"
  *
  * The returned objects have:
- *   - exportName: the variable or function name (e.g. "greetHandler")
+ *   - exportName: the variable or function name (e.g. "greetHandler"), or the method name
+ *   - className, static: for class methods, the exported class name and whether the method is static
+ *   - default: true for the default export; exportName (or className) is then "default"
+ *   - importName: for handlers re-exported under an alias, the name declared in source
  *   - source: the relative path from the service directory to this file (as "./<basename>")
  *   - type: one of "service", "workflow", or "virtualObject"
+ *   - keyType, keyTypeImport: the inferred key type of virtual object handlers, if any
+ *   - doc: the handler's leading JSDoc text, if any
+ *   - inputSchema, outputSchema: JSON schemas of the request and response types, if resolvable
+ *
+ * Exported restate.service/object/workflow({ name, handlers }) definitions are appended to
+ * definitions instead.
  *
  * @param {string} filePath - Full path to the .ts file.
  * @param {string} targetDir - The service directory (where encore.service.ts resides).
+ * @param {Array<object>} diagnostics - Problems found while extracting are appended here.
+ * @param {Array<object>} definitions - Explicit Restate definitions are appended here.
+ * @param {Array<string>} [dependencies] - Files of targetDir imported or re-exported by the file are appended here.
  * @returns {Array<{exportName: string, source: string, type: string}>}
  */
-function extractHandlersFromFile(filePath, targetDir) {
+function extractHandlersFromFile(filePath, targetDir, diagnostics, definitions, dependencies = []) {
   try {
-    const project = new Project({
-      compilerOptions: { allowJs: true, target: 2 }
-    });
+    // Load the project's tsconfig, if any, so imported request/response types resolve through path aliases.
+    const project = createProject(findTsConfig(targetDir), { allowJs: true, target: 2 });
     const sourceFile = project.addSourceFileAtPath(filePath);
     if (!sourceFile) {
-      console.error(`Failed to load file: ${filePath}`);
+      diagnostics.push(diagnostic(filePath, 0, 0, "error", "failed to load file"));
       return [];
     }
+    diagnostics.push(...syntaxDiagnostics(project, sourceFile));
+    diagnostics.push(...unresolvedAliasDiagnostics(project, sourceFile));
     const results = [];
     // Get all exported declarations
     const exportedDeclarations = sourceFile.getExportedDeclarations();
+    const baseName = path.basename(filePath, path.extname(filePath));
+    const relativeSource = "./" + baseName;
     exportedDeclarations.forEach((declarations, exportName) => {
       for (const decl of declarations) {
+        const definition = Node.isVariableDeclaration(decl) ? extractDefinition(decl, exportName, relativeSource) : null;
+        if (definition) {
+          definitions.push(definition);
+          continue;
+        }
+        if (Node.isClassDeclaration(decl)) {
+          results.push(...extractClassHandlers(sourceFile, decl, exportName, relativeSource));
+          continue;
+        }
         let func;
         if (Node.isFunctionDeclaration(decl)) {
           func = decl;
         } else if (Node.isVariableDeclaration(decl)) {
-          const initializer = decl.getInitializer();
-          if (initializer) {
-            if (Node.isArrowFunction(initializer) || Node.isFunctionExpression(initializer)) {
-              func = initializer;
-            } else if (Node.isCallExpression(initializer)) {
-              // If the initializer is a call, check its first argument.
-              const args = initializer.getArguments();
-              if (args.length > 0 && (Node.isArrowFunction(args[0]) || Node.isFunctionExpression(args[0]))) {
-                func = args[0];
-              }
-            }
-          }
+          func = handlerFunction(decl.getInitializer());
+        } else if (exportName === "default" && Node.isExpression(decl)) {
+          // export default <expression>
+          func = handlerFunction(decl);
         }
         if (!func) continue;
-        const params = func.getParameters();
-        if (params.length === 0) continue;
-        const ctxParam = params[0];
-        const typeNode = ctxParam.getTypeNode();
-        if (!typeNode) continue;
-        const typeText = typeNode.getText();
-        let handlerType = null;
-        if (typeText.includes("WorkflowContext") || typeText.includes("WorkflowSharedContext")) {
-          handlerType = "workflow";
-        } else if (typeText.includes("ObjectContext") || typeText.includes("ObjectSharedContext")) {
-          handlerType = "virtualObject";
-        } else if (typeText.includes("Context")) {
-          handlerType = "service";
+        const entry = handlerEntry(sourceFile, func, decl, exportName, relativeSource, null);
+        if (!entry) continue;
+        // Handlers re-exported from another module, possibly under an alias, are imported from
+        // the module declaring them. origin identifies the declaration for deduplication.
+        const declFile = decl.getSourceFile().getFilePath();
+        entry.origin = `${declFile}:${decl.getStart()}`;
+        if (declFile !== sourceFile.getFilePath()) {
+          entry.reexported = true;
+          entry.source = moduleSpecifier(targetDir, declFile);
+          const isDefault = Node.isExpression(decl) || (typeof decl.isDefaultExport === "function" && decl.isDefaultExport());
+          const declName = isDefault ? "default" : decl.getName();
+          if (declName && declName !== exportName) {
+            entry.importName = declName;
+          }
         }
-        if (!handlerType) continue;
-        const baseName = path.basename(filePath, ".ts");
-        const relativeSource = "./" + baseName;
-        results.push({ exportName, source: relativeSource, type: handlerType });
+        results.push(entry);
       }
     });
+    for (const decl of [...sourceFile.getImportDeclarations(), ...sourceFile.getExportDeclarations()]) {
+      const imported = decl.getModuleSpecifierSourceFile();
+      if (imported && path.dirname(imported.getFilePath()) === targetDir) {
+        dependencies.push(imported.getFilePath());
+      }
+    }
     return results;
   } catch (err) {
-    console.error(`Error processing file ${filePath}: ${err}`);
+    diagnostics.push(diagnostic(filePath, 0, 0, "error", `error processing file: ${err}`));
     return [];
   }
 }
 
 /**
- * Extracts the service name from the specified encore.service.ts file.
+ * Returns the extensionless relative module specifier of file as seen from dir, e.g. "./impl".
+ *
+ * @param {string} dir
+ * @param {string} file
+ * @returns {string}
+ */
+function moduleSpecifier(dir, file) {
+  let rel = path.relative(dir, file).split(path.sep).join("/");
+  rel = rel.slice(0, rel.length - path.extname(rel).length);
+  return rel.startsWith(".") ? rel : "./" + rel;
+}
+
+/**
+ * Returns the handler function of an exported expression: an arrow function or function
+ * expression, or the first argument of a call wrapping one. Returns undefined otherwise.
+ *
+ * @param {Node|undefined} expr
+ * @returns {Node|undefined}
+ */
+function handlerFunction(expr) {
+  if (!expr) return undefined;
+  if (Node.isArrowFunction(expr) || Node.isFunctionExpression(expr)) {
+    return expr;
+  }
+  if (Node.isCallExpression(expr)) {
+    // If the expression is a call, check its first argument.
+    const args = expr.getArguments();
+    if (args.length > 0 && (Node.isArrowFunction(args[0]) || Node.isFunctionExpression(args[0]))) {
+      return args[0];
+    }
+  }
+  return undefined;
+}
+
+/**
+ * Maps a context type annotation to a handler type, or null if it is not a Restate context.
+ *
+ * @param {string} typeText
+ * @returns {string|null}
+ */
+function handlerTypeFromContext(typeText) {
+  if (typeText.includes("WorkflowContext") || typeText.includes("WorkflowSharedContext")) {
+    return "workflow";
+  } else if (typeText.includes("ObjectContext") || typeText.includes("ObjectSharedContext")) {
+    return "virtualObject";
+  } else if (typeText.includes("Context")) {
+    return "service";
+  }
+  return null;
+}
+
+/**
+ * Builds the manifest entry of a handler function, or returns null if it is not a handler.
+ *
+ * @param {SourceFile} sourceFile
+ * @param {Node} func - The function, arrow function or method.
+ * @param {Node} decl - The declaration carrying the JSDoc.
+ * @param {string} exportName - The handler name.
+ * @param {string} relativeSource - The module specifier of the file, e.g. "./greeter".
+ * @param {string|null} defaultType - The handler type to use if the context is not annotated.
+ * @returns {object|null}
+ */
+function handlerEntry(sourceFile, func, decl, exportName, relativeSource, defaultType) {
+  const params = func.getParameters();
+  if (params.length === 0) return null;
+  const ctxParam = params[0];
+  // Plain JavaScript handlers carry their context type in a JSDoc @param tag.
+  const typeNode = ctxParam.getTypeNode() || ts.getJSDocType(ctxParam.compilerNode);
+  const handlerType = typeNode ? handlerTypeFromContext(typeNode.getText()) : defaultType;
+  if (!handlerType) return null;
+  const entry = { exportName, source: relativeSource, type: handlerType };
+  if (exportName === "default") {
+    entry.default = true;
+  }
+  if (params.length > 1) {
+    const inputSchema = typeToSchema(params[1].getType(), params[1]);
+    if (inputSchema) {
+      entry.inputSchema = inputSchema;
+    }
+  }
+  const outputSchema = typeToSchema(unwrapPromise(func.getReturnType()), func);
+  if (outputSchema) {
+    entry.outputSchema = outputSchema;
+  }
+  const doc = extractDoc(decl, ctxParam.getName());
+  if (doc) {
+    entry.doc = doc;
+  }
+  if (handlerType === "virtualObject") {
+    const key = extractKeyType(sourceFile, func, ctxParam, relativeSource);
+    if (key) {
+      entry.keyType = key.keyType;
+      if (key.keyTypeImport) {
+        entry.keyTypeImport = key.keyTypeImport;
+      }
+    }
+  }
+  return entry;
+}
+
+/**
+ * Extracts the handlers defined as methods of an exported class.
+ *
+ * A class decorated with @service, @object or @workflow (optionally qualified and called,
+ * e.g. @restate.object()) gives the handler type of methods without a context annotation.
+ * Private, protected and #-prefixed methods are skipped.
+ *
+ * @param {SourceFile} sourceFile
+ * @param {ClassDeclaration} classDecl
+ * @param {string} exportName - The name the class is exported as.
+ * @param {string} relativeSource
+ * @returns {Array<object>}
+ */
+function extractClassHandlers(sourceFile, classDecl, exportName, relativeSource) {
+  const classTypes = { service: "service", object: "virtualObject", workflow: "workflow" };
+  let defaultType = null;
+  for (const decorator of classDecl.getDecorators()) {
+    const name = decorator.getName().split(".").pop().toLowerCase();
+    if (classTypes[name]) {
+      defaultType = classTypes[name];
+    }
+  }
+  const results = [];
+  for (const method of classDecl.getMethods()) {
+    if (method.hasModifier(SyntaxKind.PrivateKeyword) || method.hasModifier(SyntaxKind.ProtectedKeyword)) continue;
+    const name = method.getName();
+    if (name.startsWith("#") || name === "constructor") continue;
+    const entry = handlerEntry(sourceFile, method, method, name, relativeSource, defaultType);
+    if (entry) {
+      entry.className = exportName;
+      entry.static = method.isStatic();
+      if (exportName === "default") {
+        entry.default = true;
+      }
+      results.push(entry);
+    }
+  }
+  return results;
+}
+
+/**
+ * Returns the explicit Restate definition declared by decl, e.g.
+ * export const billing = restate.service({ name: "Billing", handlers: { charge, refund } }),
+ * or null if decl is not one.
+ *
+ * The result has exportName, source, type, name, handlers (the handler names) and references,
+ * the identifiers used as handler values.
+ *
+ * @param {VariableDeclaration} decl
+ * @param {string} exportName
+ * @param {string} relativeSource
+ * @returns {object|null}
+ */
+function extractDefinition(decl, exportName, relativeSource) {
+  const definitionTypes = { service: "service", object: "virtualObject", workflow: "workflow" };
+  const initializer = decl.getInitializer();
+  if (!initializer || !Node.isCallExpression(initializer)) return null;
+  const callee = initializer.getExpression().getText().split(".").pop();
+  const type = definitionTypes[callee];
+  const arg = initializer.getArguments()[0];
+  if (!type || !arg || !Node.isObjectLiteralExpression(arg)) return null;
+  const nameProp = arg.getProperty("name");
+  if (!nameProp || !Node.isPropertyAssignment(nameProp)) return null;
+  const nameInit = nameProp.getInitializer();
+  if (!nameInit || !Node.isStringLiteral(nameInit) && !Node.isNoSubstitutionTemplateLiteral(nameInit)) return null;
+  const definition = { exportName, source: relativeSource, type, name: nameInit.getLiteralValue(), handlers: [], references: [] };
+  const handlersProp = arg.getProperty("handlers");
+  const handlersInit = handlersProp && Node.isPropertyAssignment(handlersProp) ? handlersProp.getInitializer() : null;
+  if (handlersInit && Node.isObjectLiteralExpression(handlersInit)) {
+    for (const prop of handlersInit.getProperties()) {
+      if (Node.isShorthandPropertyAssignment(prop)) {
+        definition.handlers.push(prop.getName());
+        definition.references.push(prop.getName());
+      } else if (Node.isPropertyAssignment(prop)) {
+        definition.handlers.push(prop.getName().replace(/^["'](.*)["']$/, "$1"));
+        const value = prop.getInitializer();
+        if (value && Node.isIdentifier(value)) {
+          definition.references.push(value.getText());
+        }
+      } else if (Node.isMethodDeclaration(prop)) {
+        definition.handlers.push(prop.getName());
+      }
+    }
+  }
+  return definition;
+}
+
+/**
+ * Creates a diagnostic. Line and column are 1-based; 0 means unknown.
+ *
+ * @param {string} file
+ * @param {number} line
+ * @param {number} column
+ * @param {"error"|"warning"} severity
+ * @param {string} message
+ * @returns {{file: string, line: number, column: number, severity: string, message: string}}
+ */
+function diagnostic(file, line, column, severity, message) {
+  return { file, line, column, severity, message };
+}
+
+/**
+ * Returns the syntax errors of a source file as diagnostics.
+ *
+ * @param {Project} project
+ * @param {SourceFile} sourceFile
+ * @returns {Array<object>}
+ */
+function syntaxDiagnostics(project, sourceFile) {
+  return project.getProgram().getSyntacticDiagnostics(sourceFile).map(d => toDiagnostic(sourceFile, d));
+}
+
+/**
+ * Converts a ts-morph diagnostic of sourceFile to a manifest diagnostic.
+ *
+ * @param {SourceFile} sourceFile
+ * @param {import("ts-morph").Diagnostic} d
+ * @returns {object}
+ */
+function toDiagnostic(sourceFile, d) {
+  const start = d.getStart();
+  const pos = start === undefined ? { line: 0, column: 0 } : sourceFile.getLineAndColumnAtPos(start);
+  const text = d.getMessageText();
+  const message = typeof text === "string" ? text : ts.flattenDiagnosticMessageText(text.compilerObject, "\n");
+  const severity = d.getCategory() === ts.DiagnosticCategory.Error ? "error" : "warning";
+  return diagnostic(sourceFile.getFilePath(), pos.line, pos.column, severity, message);
+}
+
+/**
+ * Creates a ts-morph project using the given tsconfig, if any, overridden by compilerOptions.
+ *
+ * Module resolution falls back to "node" when the tsconfig does not set one the bundled
+ * TypeScript understands (e.g. "bundler"), since the classic default resolves neither
+ * packages nor most path aliases.
+ *
+ * @param {string|null} tsConfigFilePath
+ * @param {object} compilerOptions
+ * @returns {Project}
+ */
+function createProject(tsConfigFilePath, compilerOptions) {
+  const config = tsConfigFilePath ? { tsConfigFilePath, skipAddingFilesFromTsConfig: true } : {};
+  const project = new Project({ ...config, compilerOptions });
+  if (project.getCompilerOptions().moduleResolution !== undefined) {
+    return project;
+  }
+  return new Project({
+    ...config,
+    compilerOptions: { ...compilerOptions, moduleResolution: ts.ModuleResolutionKind.NodeJs },
+  });
+}
+
+/**
+ * Returns warnings for imports matching a compilerOptions.paths alias that cannot be resolved,
+ * since types imported through them silently degrade to any. Imports of the generated code are
+ * skipped.
+ *
+ * @param {Project} project
+ * @param {SourceFile} sourceFile
+ * @returns {Array<object>}
+ */
+function unresolvedAliasDiagnostics(project, sourceFile) {
+  const patterns = Object.keys(project.getCompilerOptions().paths || {});
+  const matches = specifier => patterns.some(pattern => {
+    const star = pattern.indexOf("*");
+    if (star < 0) return specifier === pattern;
+    return specifier.startsWith(pattern.slice(0, star)) && specifier.endsWith(pattern.slice(star + 1));
+  });
+  const results = [];
+  for (const importDecl of sourceFile.getImportDeclarations()) {
+    const specifier = importDecl.getModuleSpecifierValue();
+    if (specifier === GENERATED_ALIAS || specifier.startsWith(GENERATED_ALIAS + "/")) continue;
+    if (!matches(specifier) || importDecl.getModuleSpecifierSourceFile()) continue;
+    const pos = sourceFile.getLineAndColumnAtPos(importDecl.getModuleSpecifier().getStart());
+    results.push(diagnostic(sourceFile.getFilePath(), pos.line, pos.column, "warning",
+      `cannot resolve path alias "${specifier}", check compilerOptions.paths in tsconfig.json`));
+  }
+  return results;
+}
+
+/**
+ * Finds the nearest tsconfig.json in dir or one of its parents.
+ *
+ * @param {string} dir
+ * @returns {string|null}
+ */
+function findTsConfig(dir) {
+  let current = path.resolve(dir);
+  for (;;) {
+    const candidate = path.join(current, "tsconfig.json");
+    if (fs.existsSync(candidate)) return candidate;
+    const parent = path.dirname(current);
+    if (parent === current) return null;
+    current = parent;
+  }
+}
+
+/**
+ * Returns T for Promise<T>, or the type itself.
+ */
+function unwrapPromise(type) {
+  const symbol = type.getSymbol();
+  if (symbol && symbol.getName() === "Promise") {
+    const args = type.getTypeArguments();
+    if (args.length === 1) return args[0];
+  }
+  return type;
+}
+
+/**
+ * Converts a TypeScript type into a JSON schema, as far as it can be expressed.
+ *
+ * Primitives, literals, arrays, unions and object types are converted. Types that cannot be
+ * resolved (any, unknown, unresolved imports) yield null at the top level and an empty schema
+ * when nested.
+ *
+ * @param {Type} type - The type to convert.
+ * @param {Node} node - The node the type is used at, for resolving property types.
+ * @param {number} depth - The current nesting depth, limited to avoid recursive types.
+ * @returns {object|null}
+ */
+function typeToSchema(type, node, depth = 0) {
+  if (type.isAny() || type.isUnknown() || depth > 6) {
+    return depth === 0 ? null : {};
+  }
+  if (type.isString()) return { type: "string" };
+  if (type.isNumber()) return { type: "number" };
+  if (type.isBoolean()) return { type: "boolean" };
+  if (type.isNull()) return { type: "null" };
+  if (type.isUndefined() || type.getText() === "void") {
+    return depth === 0 ? null : {};
+  }
+  if (type.isStringLiteral() || type.isNumberLiteral() || type.isBooleanLiteral()) {
+    return { const: JSON.parse(type.getText()) };
+  }
+  if (type.isArray()) {
+    return { type: "array", items: typeToSchema(type.getArrayElementTypeOrThrow(), node, depth + 1) || {} };
+  }
+  if (type.isUnion()) {
+    const members = type.getUnionTypes().filter(t => !t.isUndefined());
+    if (members.length > 0 && members.every(t => t.isStringLiteral())) {
+      return { type: "string", enum: members.map(t => JSON.parse(t.getText())) };
+    }
+    if (members.every(t => t.isBooleanLiteral())) {
+      return { type: "boolean" };
+    }
+    return { anyOf: members.map(t => typeToSchema(t, node, depth + 1) || {}) };
+  }
+  if (type.isObject()) {
+    const symbol = type.getSymbol();
+    if (symbol && symbol.getName() === "Date") {
+      return { type: "string", format: "date-time" };
+    }
+    const properties = {};
+    const required = [];
+    for (const prop of type.getProperties()) {
+      const propType = prop.getTypeAtLocation(node);
+      if (propType.getCallSignatures().length > 0) continue;
+      properties[prop.getName()] = typeToSchema(propType, node, depth + 1) || {};
+      if (!prop.isOptional()) required.push(prop.getName());
+    }
+    const schema = { type: "object", properties };
+    if (required.length > 0) schema.required = required;
+    return schema;
+  }
+  return depth === 0 ? null : {};
+}
+
+/**
+ * Extracts the leading JSDoc text of a handler declaration.
+ *
+ * Tags that only make sense in the handler source are dropped: "@key" and the "@param" tag
+ * of the context parameter, which generated clients do not take.
+ *
+ * @param {Node} decl - The function or variable declaration of the handler.
+ * @param {string} ctxName - The name of the context parameter.
+ * @returns {string} The JSDoc text without delimiters, or "" if there is none.
+ */
+function extractDoc(decl, ctxName) {
+  const host = Node.isVariableDeclaration(decl) ? decl.getVariableStatement() : decl;
+  if (!host || !Node.isJSDocable(host)) return "";
+  const docs = host.getJsDocs();
+  if (docs.length === 0) return "";
+  const blocks = [];
+  for (const line of docs[docs.length - 1].getInnerText().split("\n")) {
+    if (blocks.length === 0 || line.trim().startsWith("@")) {
+      blocks.push([line]);
+    } else {
+      blocks[blocks.length - 1].push(line);
+    }
+  }
+  const ctxParamTag = new RegExp(`^@param\\s+(\\{[^}]*\\}\\s*)?${ctxName}\\b`);
+  return blocks
+    .filter(block => {
+      const first = block[0].trim();
+      return !first.startsWith("@key") && !ctxParamTag.test(first);
+    })
+    .map(block => block.join("\n"))
+    .join("\n")
+    .trim();
+}
+
+/**
+ * Infers the key type of a virtual object handler.
+ *
+ * The key type is taken from a "@key {Type}" JSDoc tag on the handler, or from a type
+ * assertion on the context key in the handler body (e.g. "ctx.key as UserId").
+ * Only "string" and plain type names that are imported or exported by the handler file
+ * are accepted, since the generated code must be able to import them.
+ *
+ * @returns {{keyType: string, keyTypeImport?: {name: string, alias: string, source: string}}|null}
+ */
+function extractKeyType(sourceFile, func, ctxParam, relativeSource) {
+  let keyType = null;
+  for (const tag of ts.getJSDocTags(func.compilerNode)) {
+    if (tag.tagName.getText() !== "key") continue;
+    const comment = typeof tag.comment === "string" ? tag.comment : "";
+    const match = comment.match(/^\s*\{?\s*([A-Za-z_$][\w$]*)\s*\}?/);
+    if (match) {
+      keyType = match[1];
+      break;
+    }
+  }
+  if (!keyType) {
+    const ctxName = ctxParam.getName();
+    for (const node of func.getDescendants()) {
+      if (!Node.isAsExpression(node) && !Node.isTypeAssertion(node)) continue;
+      if (node.getExpression().getText() !== `${ctxName}.key`) continue;
+      const typeNode = node.getTypeNode();
+      if (typeNode) {
+        keyType = typeNode.getText();
+        break;
+      }
+    }
+  }
+  if (!keyType) return null;
+  if (keyType === "string") return { keyType };
+  if (!/^[A-Za-z_$][\w$]*$/.test(keyType)) return null;
+  for (const importDecl of sourceFile.getImportDeclarations()) {
+    for (const named of importDecl.getNamedImports()) {
+      const alias = named.getAliasNode() ? named.getAliasNode().getText() : named.getName();
+      if (alias === keyType) {
+        return {
+          keyType,
+          keyTypeImport: { name: named.getName(), alias, source: importDecl.getModuleSpecifierValue() },
+        };
+      }
+    }
+  }
+  const exported = sourceFile.getExportedDeclarations().get(keyType);
+  if (exported && exported.length > 0) {
+    return { keyType, keyTypeImport: { name: keyType, alias: keyType, source: relativeSource } };
+  }
+  return null;
+}
+
+/**
+ * Extracts the service name from the specified service file, usually encore.service.ts.
  *
  * It looks for the default export and then searches for a new expression whose first argument
- * is a string literal representing the service name.
+ * is a string literal representing the service name. For wrappers around Encore's Service class,
+ * e.g. export default defineService("name"), a call with a string literal first argument is
+ * accepted too.
  *
  * @param {string} serviceFilePath
+ * @param {Array<object>} diagnostics - Problems found while extracting are appended here.
  * @returns {string|null}
  */
-function extractServiceName(serviceFilePath) {
+function extractServiceName(serviceFilePath, diagnostics) {
   if (!fs.existsSync(serviceFilePath)) {
-    console.error(`Service file not found: ${serviceFilePath}`);
+    diagnostics.push(diagnostic(serviceFilePath, 0, 0, "error", "service file not found"));
     return null;
   }
   try {
@@ -106,14 +599,19 @@ function extractServiceName(serviceFilePath) {
     });
     const sourceFile = project.addSourceFileAtPath(serviceFilePath);
     if (!sourceFile) {
-      console.error(`Failed to load service file: ${serviceFilePath}`);
+      diagnostics.push(diagnostic(serviceFilePath, 0, 0, "error", "failed to load service file"));
       return null;
     }
+    diagnostics.push(...syntaxDiagnostics(project, sourceFile));
     const defaultExportSymbol = sourceFile.getDefaultExportSymbol();
     if (defaultExportSymbol) {
       const declarations = defaultExportSymbol.getDeclarations();
       for (const decl of declarations) {
-        const newExpr = decl.getFirstDescendantByKind(SyntaxKind.NewExpression);
+        const newExpr = decl.getFirstDescendantByKind(SyntaxKind.NewExpression) ||
+          decl.getDescendantsOfKind(SyntaxKind.CallExpression).find(call => {
+            const first = call.getArguments()[0];
+            return first && (Node.isStringLiteral(first) || Node.isNoSubstitutionTemplateLiteral(first));
+          });
         if (newExpr) {
           const args = newExpr.getArguments();
           if (args.length > 0) {
@@ -130,48 +628,293 @@ function extractServiceName(serviceFilePath) {
         }
       }
     }
-    console.error(`Service name not found in ${serviceFilePath}`);
+    diagnostics.push(diagnostic(serviceFilePath, 0, 0, "error", "service name not found"));
     return null;
   } catch (err) {
-    console.error(`Error processing service file ${serviceFilePath}: ${err}`);
+    diagnostics.push(diagnostic(serviceFilePath, 0, 0, "error", `error processing service file: ${err}`));
     return null;
   }
 }
 
+/**
+ * Returns true if the file in a service directory may contain handlers.
+ *
+ * TypeScript and plain JavaScript sources are considered, excluding encore.service.ts, the
+ * service file, declaration files and generated files.
+ *
+ * @param {string} file - The file name.
+ * @param {string} serviceFile - The name of the service file.
+ * @returns {boolean}
+ */
+function isHandlerSource(file, serviceFile) {
+  if (file === "encore.service.ts" || file === serviceFile || file.startsWith("restate.") || file.endsWith(".d.ts")) {
+    return false;
+  }
+  if (file.endsWith(".restate.ts") || file.endsWith(".restate.js")) {
+    return false;
+  }
+  return file.endsWith(".ts") || file.endsWith(".js");
+}
+
+/**
+ * Compiles generated TypeScript files to JavaScript plus declaration files.
+ *
+ * Declarations are emitted with the project's tsconfig so that handler types resolve
+ * through node_modules and path aliases. The outputs are written next to each input file.
+ *
+ * @param {string} projectRoot - The Encore project root.
+ * @param {string[]} files - Full paths to the generated .ts files.
+ */
+function emitJavaScript(projectRoot, files) {
+  const tsConfigFilePath = path.join(projectRoot, "tsconfig.json");
+  const project = createProject(fs.existsSync(tsConfigFilePath) ? tsConfigFilePath : null, {
+    allowJs: true,
+    declaration: true,
+    declarationMap: false,
+    sourceMap: false,
+    noEmit: false,
+    emitDeclarationOnly: false,
+    composite: false,
+    incremental: false,
+  });
+  for (const file of files) {
+    const sourceFile = project.addSourceFileAtPath(file);
+    const output = sourceFile.getEmitOutput();
+    if (output.getEmitSkipped()) {
+      const diagnostics = project.getProgram().getDeclarationDiagnostics(sourceFile);
+      const messages = diagnostics.map(d => {
+        const text = d.getMessageText();
+        return typeof text === "string" ? text : text.getMessageText();
+      });
+      throw new Error(`Emit skipped for ${file}: ${messages.join("; ")}`);
+    }
+    for (const outputFile of output.getOutputFiles()) {
+      const target = path.join(path.dirname(file), path.basename(outputFile.getFilePath()));
+      fs.writeFileSync(target, outputFile.getText());
+    }
+  }
+}
+
+/**
+ * Type-checks generated TypeScript files with the project's tsconfig.
+ *
+ * Only problems located in the given files are returned, so errors elsewhere in the project do
+ * not drown out mismatches between the generated code and the handlers it imports.
+ *
+ * @param {string} projectRoot - The Encore project root.
+ * @param {string[]} files - Full paths to the generated .ts files.
+ * @returns {Array<object>} diagnostics
+ */
+function typecheckFiles(projectRoot, files) {
+  const tsConfigFilePath = path.join(projectRoot, "tsconfig.json");
+  const project = createProject(fs.existsSync(tsConfigFilePath) ? tsConfigFilePath : null, {
+    noEmit: true,
+    composite: false,
+    incremental: false,
+  });
+  const sourceFiles = files.map(file => project.addSourceFileAtPath(file));
+  const program = project.getProgram();
+  const diagnostics = [];
+  for (const sourceFile of sourceFiles) {
+    for (const d of [...program.getSyntacticDiagnostics(sourceFile), ...program.getSemanticDiagnostics(sourceFile)]) {
+      diagnostics.push(toDiagnostic(sourceFile, d));
+    }
+  }
+  return diagnostics;
+}
+
+/**
+ * Builds the handler manifest of a service directory.
+ *
+ * Scans the target directory for .ts and .js files (excluding the service file and generated
+ * files) and extracts handlers and explicit Restate definitions from each file. Problems are
+ * reported in the diagnostics array; if the service name cannot be determined, serviceName is
+ * empty and no handlers are extracted.
+ *
+ * @param {string} targetDir - The service directory.
+ * @param {string} [serviceFile] - The name of the file declaring the service, encore.service.ts by default.
+ * @param {Map<string, string>} [reusable] - Results of unchanged files, as JSON keyed by path, used instead of parsing them.
+ * @param {Set<string>} [ignored] - Names of files in the directory not to extract, e.g. editor temporary files.
+ * @returns {{serviceName: string, handlers: Array<object>, definitions: Array<object>, diagnostics: Array<object>}}
+ */
+function buildManifest(targetDir, serviceFile = "encore.service.ts", reusable = new Map(), ignored = new Set()) {
+  if (!fs.existsSync(targetDir) || !fs.statSync(targetDir).isDirectory()) {
+    throw new Error(`Target directory does not exist or is not a directory: ${targetDir}`);
+  }
+  const diagnostics = [];
+  const serviceFilePath = path.join(targetDir, serviceFile);
+  const serviceName = extractServiceName(serviceFilePath, diagnostics);
+  const manifest = { schemaVersion: SCHEMA_VERSION, serviceName: serviceName || "", handlers: [], definitions: [], diagnostics };
+  if (!serviceName) {
+    return manifest;
+  }
+  const files = fs.readdirSync(targetDir);
+  for (const file of files) {
+    if (isHandlerSource(file, serviceFile) && !ignored.has(file)) {
+      const filePath = path.join(targetDir, file);
+      if (fs.statSync(filePath).isFile()) {
+        const result = reusable.has(filePath) ? JSON.parse(reusable.get(filePath)) : extractFile(filePath, targetDir);
+        fileResults.set(filePath, JSON.stringify(result));
+        manifest.handlers.push(...result.handlers);
+        manifest.definitions.push(...result.definitions);
+        diagnostics.push(...result.diagnostics);
+      }
+    }
+  }
+  // A handler both exported where it is declared and re-exported elsewhere is kept once, under
+  // the re-exported, public name.
+  const byOrigin = new Map();
+  for (const entry of manifest.handlers) {
+    if (!entry.origin) continue;
+    const existing = byOrigin.get(entry.origin);
+    if (!existing || (entry.reexported && !existing.reexported)) {
+      byOrigin.set(entry.origin, entry);
+    }
+  }
+  manifest.handlers = manifest.handlers.filter(entry => !entry.origin || byOrigin.get(entry.origin) === entry);
+  for (const entry of manifest.handlers) {
+    delete entry.origin;
+    delete entry.reexported;
+  }
+  // Handlers referenced by an explicit definition belong to it, not to the inferred definitions.
+  const referenced = new Set();
+  for (const definition of manifest.definitions) {
+    definition.references.forEach(name => referenced.add(name));
+    delete definition.references;
+  }
+  manifest.handlers = manifest.handlers.filter(h => h.className || !referenced.has(h.exportName));
+  return manifest;
+}
+
+/**
+ * Extracts the handlers, definitions and diagnostics of one file.
+ *
+ * @param {string} filePath
+ * @param {string} targetDir
+ * @returns {{handlers: Array<object>, definitions: Array<object>, diagnostics: Array<object>}}
+ */
+function extractFile(filePath, targetDir) {
+  const result = { handlers: [], definitions: [], diagnostics: [], dependencies: [] };
+  result.handlers = extractHandlersFromFile(filePath, targetDir, result.diagnostics, result.definitions, result.dependencies);
+  return result;
+}
+
+/**
+ * Per-file results of the last extraction, as JSON keyed by file path, and the input hash of the
+ * last extraction per directory. Used by the worker for incremental extraction.
+ */
+const fileResults = new Map();
+const extractedHashes = new Map();
+
+/**
+ * Extracts the manifest of request.dir. If the last extraction of the directory had the inputs
+ * request.base, only request.files and the files depending on them are parsed again, and the other
+ * files' results are reused.
+ *
+ * @param {{dir: string, serviceFile?: string, files?: string[], base?: string, hash?: string, ignored?: string[]}} request
+ * @returns {object}
+ */
+function extractIncremental(request) {
+  const reusable = new Map();
+  if (request.base && extractedHashes.get(request.dir) === request.base) {
+    const changed = new Set(request.files || []);
+    for (const [filePath, result] of fileResults) {
+      if (path.dirname(filePath) === request.dir && !changed.has(filePath)) {
+        reusable.set(filePath, result);
+      }
+    }
+    // Files importing a changed file, directly or not, are parsed again too.
+    let stale = true;
+    while (stale) {
+      stale = false;
+      for (const [filePath, result] of reusable) {
+        if (JSON.parse(result).dependencies.some(dep => changed.has(dep))) {
+          reusable.delete(filePath);
+          changed.add(filePath);
+          stale = true;
+        }
+      }
+    }
+  }
+  for (const filePath of [...fileResults.keys()]) {
+    if (path.dirname(filePath) === request.dir) {
+      fileResults.delete(filePath);
+    }
+  }
+  extractedHashes.delete(request.dir);
+  const manifest = buildManifest(request.dir, request.serviceFile || undefined, reusable, new Set(request.ignored || []));
+  if (request.hash) {
+    extractedHashes.set(request.dir, request.hash);
+  }
+  return manifest;
+}
+
+/**
+ * Runs as a long-lived worker.
+ *
+ * Requests and responses are line-delimited JSON on stdin and stdout:
+ *   {"id": 1, "op": "extract", "dir": "...", "serviceFile": "...", "files": [...], "base": "...", "hash": "..."}
+ *     -> {"id": 1, "result": <manifest>}, see extractIncremental
+ *   {"id": 2, "op": "emitJs", "projectRoot": "...", "files": [...]} -> {"id": 2, "result": null}
+ *   {"id": 3, "op": "typecheck", "projectRoot": "...", "files": [...]} -> {"id": 3, "result": <diagnostics>}
+ * Failed requests are answered with {"id": n, "error": "..."}. The worker exits when stdin closes.
+ * stdout carries nothing but responses: console output is redirected to stderr.
+ */
+function runWorker() {
+  const respond = response => process.stdout.write(JSON.stringify(response) + "\n");
+  console.log = console.info = console.debug = console.error;
+  const rl = readline.createInterface({ input: process.stdin, terminal: false });
+  rl.on("line", line => {
+    if (!line.trim()) return;
+    let request;
+    try {
+      request = JSON.parse(line);
+    } catch (err) {
+      respond({ id: 0, error: `Invalid request: ${err}` });
+      return;
+    }
+    const response = { id: request.id };
+    try {
+      switch (request.op) {
+        case "extract":
+          response.result = extractIncremental(request);
+          break;
+        case "emitJs":
+          emitJavaScript(request.projectRoot, request.files);
+          response.result = null;
+          break;
+        case "typecheck":
+          response.result = typecheckFiles(request.projectRoot, request.files);
+          break;
+        default:
+          throw new Error(`Unknown operation: ${request.op}`);
+      }
+    } catch (err) {
+      response.error = String(err && err.message ? err.message : err);
+    }
+    respond(response);
+  });
+  rl.on("close", () => process.exit(0));
+}
+
 /**
  * Main entry point.
  *
- * Scans the target directory for .ts files (excluding encore.service.ts and generated files),
- * extracts handlers from each file, and outputs a JSON manifest.
+ * Invoked as "--worker", runs as a long-lived worker (see runWorker). Invoked as
+ * "--emit-js <projectRoot> <files...>", compiles the given generated files. Otherwise
+ * extracts the manifest of the given directory and writes it to stdout.
  */
 function main() {
   try {
-    const targetDir = process.argv[2] || process.cwd();
-    if (!fs.existsSync(targetDir) || !fs.statSync(targetDir).isDirectory()) {
-      console.error(`Target directory does not exist or is not a directory: ${targetDir}`);
-      process.exit(1);
-    }
-    const serviceFilePath = path.join(targetDir, "encore.service.ts");
-    const serviceName = extractServiceName(serviceFilePath);
-    if (!serviceName) {
-      console.error("Error: Service name extraction failed.");
-      process.exit(1);
-    }
-    const manifest = { serviceName, handlers: [] };
-    const files = fs.readdirSync(targetDir);
-    for (const file of files) {
-      if (
-        file.endsWith(".ts") &&
-        file !== "encore.service.ts" &&
-        !file.startsWith("restate.")
-      ) {
-        const filePath = path.join(targetDir, file);
-        if (fs.statSync(filePath).isFile()) {
-          const handlers = extractHandlersFromFile(filePath, targetDir);
-          manifest.handlers.push(...handlers);
-        }
-      }
+    if (process.argv[2] === "--worker") {
+      runWorker();
+      return;
+    }
+    if (process.argv[2] === "--emit-js") {
+      emitJavaScript(process.argv[3], process.argv.slice(4));
+      return;
     }
+    const manifest = buildManifest(process.argv[2] || process.cwd(), process.argv[3]);
     process.stdout.write(JSON.stringify(manifest, null, 2));
   } catch (err) {
     console.error("Unexpected error occurred: " + err);
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// cachedManifest is a manifest persisted together with the state of the inputs it was extracted from.
+type cachedManifest struct {
+	sourceState
+	Manifest *Manifest `json:"manifest"`
+}
+
+// sourceState describes the inputs extraction of a directory depends on.
+type sourceState struct {
+	// Hash covers all inputs; Env all inputs but the service's source files.
+	Hash string `json:"hash"`
+	Env  string `json:"env,omitempty"`
+	// Files maps the service's source files to hashes of their contents.
+	Files map[string]string `json:"files,omitempty"`
+}
+
+// changedFiles returns the source files of dir whose contents differ between prev and s, or
+// false if inputs other than the source files changed.
+func (s sourceState) changedFiles(dir string, prev sourceState) ([]string, bool) {
+	if prev.Env == "" || prev.Env != s.Env {
+		return nil, false
+	}
+	var changed []string
+	for name, hash := range s.Files {
+		if prev.Files[name] != hash {
+			changed = append(changed, filepath.Join(dir, name))
+		}
+	}
+	sort.Strings(changed)
+	return changed, true
+}
+
+var (
+	manifestCache      = make(map[string]cachedManifest)
+	manifestCacheMutex sync.Mutex
+	// goodManifests holds the last manifest without errors per directory.
+	goodManifests = make(map[string]*Manifest)
+
+	assetsChecksumOnce  sync.Once
+	assetsChecksumValue string
+)
+
+// cacheDir returns the tool's cache directory, e.g. ~/.cache/encore-restate-gen on Linux.
+func cacheDir() (string, error) {
+	base, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(base, "encore-restate-gen"), nil
+}
+
+// projectCacheDir is where the watcher state and the manifests of a project are kept, relative to
+// its root, so CI can cache them together with the project.
+const projectCacheDir = ".cache/encore-restate-gen"
+
+// projectCache returns the project cache directory of root.
+func projectCache(root string) string {
+	return filepath.Join(root, filepath.FromSlash(projectCacheDir))
+}
+
+// mkdirProjectCache creates dir below the project cache directory of root. The cache directory
+// gets a .gitignore ignoring it, so it never shows up in version control.
+func mkdirProjectCache(root, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	ignore := filepath.Join(projectCache(root), ".gitignore")
+	if _, err := os.Stat(ignore); os.IsNotExist(err) {
+		return ioutil.WriteFile(ignore, []byte("*\n"), 0644)
+	}
+	return nil
+}
+
+// assetsChecksum returns a checksum of the embedded extraction bundle, so caches are invalidated
+// when the tool is upgraded.
+func assetsChecksum() string {
+	assetsChecksumOnce.Do(func() {
+		h := sha256.New()
+		fs.WalkDir(assets, "assets_dist", func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			data, err := assets.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			h.Write([]byte(path + "\x00"))
+			h.Write(data)
+			return nil
+		})
+		assetsChecksumValue = hex.EncodeToString(h.Sum(nil))
+	})
+	return assetsChecksumValue
+}
+
+// diskChecksum computes the assetsChecksum of the embedded assets as extracted to dir. It returns
+// "" if a file is missing or unreadable.
+func diskChecksum(dir string) string {
+	h := sha256.New()
+	err := fs.WalkDir(assets, "assets_dist", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, strings.TrimPrefix(path, "assets_dist/")))
+		if err != nil {
+			return err
+		}
+		h.Write([]byte(path + "\x00"))
+		h.Write(data)
+		return nil
+	})
+	if err != nil {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// sourceHash hashes everything extraction of dir depends on: the service's source files, the
+// project's tsconfig.json, the extraction backend and the tool's bundle.
+func sourceHash(dir string, goBackend bool) (sourceState, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return sourceState{}, err
+	}
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && isSourceFile(name) && !strings.Contains(name, ".restate.") &&
+			!projectIgnore.ignored(filepath.Join(dir, name), false) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	h.Write([]byte(assetsChecksum() + "\x00" + projectConfig.Output + "\x00"))
+	if goBackend {
+		h.Write([]byte(extractorGo + "\x00"))
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(projectRoot, "tsconfig.json")); err == nil {
+		h.Write(data)
+	}
+	h.Write([]byte("\x00" + serviceMarker(dir) + "\x00"))
+	hashScripts(h)
+	state := sourceState{Env: hex.EncodeToString(h.Sum(nil)), Files: make(map[string]string)}
+	for _, name := range names {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return sourceState{}, err
+		}
+		sum := sha256.Sum256(data)
+		state.Files[name] = hex.EncodeToString(sum[:])
+		h.Write([]byte("\x00" + name + "\x00"))
+		h.Write(data)
+	}
+	state.Hash = hex.EncodeToString(h.Sum(nil))
+	return state, nil
+}
+
+// manifestCachePath returns the file the manifest of dir is persisted to, in the project cache. It
+// is keyed by the path of dir relative to the project root, so it is found in another checkout.
+func manifestCachePath(dir string) string {
+	key := dir
+	if rel, err := filepath.Rel(absRealDir(projectRoot), dir); err == nil {
+		key = filepath.ToSlash(rel)
+	}
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(projectCache(projectRoot), "manifests", hex.EncodeToString(sum[:])+".json")
+}
+
+// lookupManifest returns the cached manifest of dir, the state of the inputs it was extracted
+// from, and whether that state has the given hash. Only manifests without errors are persisted, so
+// one loaded from disk is also the last good one.
+func lookupManifest(dir, hash string) (*Manifest, sourceState, bool) {
+	manifestCacheMutex.Lock()
+	defer manifestCacheMutex.Unlock()
+	if entry, ok := manifestCache[dir]; ok {
+		return entry.Manifest, entry.sourceState, entry.Hash == hash
+	}
+	data, err := ioutil.ReadFile(manifestCachePath(dir))
+	if err != nil {
+		return nil, sourceState{}, false
+	}
+	var entry cachedManifest
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Manifest == nil {
+		return nil, sourceState{}, false
+	}
+	manifestCache[dir] = entry
+	goodManifests[dir] = entry.Manifest
+	return entry.Manifest, entry.sourceState, entry.Hash == hash
+}
+
+// lastGoodManifest returns the last manifest of dir extracted without errors, or nil.
+func lastGoodManifest(dir string) *Manifest {
+	manifestCacheMutex.Lock()
+	defer manifestCacheMutex.Unlock()
+	return goodManifests[dir]
+}
+
+// hasErrors reports whether the last extraction of dir, a real directory, reported errors.
+func hasErrors(dir string) bool {
+	manifestCacheMutex.Lock()
+	defer manifestCacheMutex.Unlock()
+	entry, ok := manifestCache[dir]
+	return ok && entry.Manifest != nil && countErrors(entry.Manifest.Diagnostics) > 0
+}
+
+// storeManifest caches the manifest of dir in memory and, if it has no errors, on disk. Failing to
+// persist it is not fatal.
+func storeManifest(dir string, state sourceState, manifest *Manifest) {
+	manifestCacheMutex.Lock()
+	defer manifestCacheMutex.Unlock()
+	entry := cachedManifest{sourceState: state, Manifest: manifest}
+	manifestCache[dir] = entry
+	if countErrors(manifest.Diagnostics) > 0 {
+		return
+	}
+	goodManifests[dir] = manifest
+	path := manifestCachePath(dir)
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	if err := mkdirProjectCache(projectRoot, filepath.Dir(path)); err != nil {
+		return
+	}
+	ioutil.WriteFile(path, data, 0644)
+}
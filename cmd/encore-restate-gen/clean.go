@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// projectModifications are the configuration entries and dependencies the generator added to a
+// project, so that clean -revert can remove exactly those.
+type projectModifications struct {
+	Entries      []configEntry `json:"entries"`
+	Dependencies []string      `json:"dependencies"`
+}
+
+var modificationsMutex sync.Mutex
+
+// modificationsPath returns the file the modifications of root are recorded in. They belong to the
+// checkout they were made in, so unlike the watcher state they are kept in the user cache
+// directory rather than the project cache, which CI may restore elsewhere or discard.
+func modificationsPath(root string) (string, error) {
+	base, err := cacheDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(absRealDir(root)))
+	return filepath.Join(base, "modifications", hex.EncodeToString(sum[:])+".json"), nil
+}
+
+// loadModifications returns the recorded modifications of root, or none.
+func loadModifications(root string) projectModifications {
+	var mods projectModifications
+	if path, err := modificationsPath(root); err == nil {
+		if data, err := ioutil.ReadFile(path); err == nil {
+			json.Unmarshal(data, &mods)
+		}
+	}
+	return mods
+}
+
+// recordModifications adds entries and dependencies to the recorded modifications of root.
+// Failing to record them is not fatal.
+func recordModifications(root string, entries []configEntry, dependencies []string) {
+	if len(entries) == 0 && len(dependencies) == 0 {
+		return
+	}
+	modificationsMutex.Lock()
+	defer modificationsMutex.Unlock()
+	mods := loadModifications(root)
+	for _, entry := range entries {
+		if abs, err := filepath.Abs(entry.File); err == nil {
+			entry.File = abs
+		}
+		mods.Entries = append(mods.Entries, entry)
+	}
+	mods.Dependencies = append(mods.Dependencies, dependencies...)
+	saveModifications(root, mods)
+}
+
+// saveModifications replaces the recorded modifications of root with mods.
+func saveModifications(root string, mods projectModifications) error {
+	path, err := modificationsPath(root)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(mods)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// cleanMain implements the clean subcommand, which removes the generated code and, with -revert,
+// the modifications made to the project's configuration and dependencies, or with -deps only the
+// dependencies.
+func cleanMain(args []string) {
+	flags := flag.NewFlagSet("clean", flag.ExitOnError)
+	revert := flags.Bool("revert", false, "also remove the tsconfig.json and package.json entries and the dependencies the generator added")
+	deps := flags.Bool("deps", false, "also uninstall the packages the generator installed, keeping its configuration entries")
+	flags.BoolVar(&offline, "offline", false, "do not run the package manager to uninstall dependencies")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s clean [flags] [project root]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	root := "."
+	if flags.NArg() > 0 {
+		root = flags.Arg(0)
+	}
+	projectRoot = root
+	cfg, err := loadConfig(root)
+	if err != nil {
+		log.Fatalf("Failed to load %s: %v", configFileName, err)
+	}
+	projectConfig = cfg
+	offline = offline || cfg.Offline
+	projectIgnore = newGitignore(root, cfg.Ignore)
+	globalPackageManager = detectPackageManager(root)
+	removeGeneratedCode(root)
+	if *revert {
+		if err := revertModifications(root); err != nil {
+			log.Fatalf("%v", err)
+		}
+	} else if *deps {
+		if err := removeDependencies(root); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+}
+
+// removeDependencies uninstalls the recorded dependencies of root and forgets them, keeping the
+// recorded configuration entries for a later clean -revert.
+func removeDependencies(root string) error {
+	modificationsMutex.Lock()
+	defer modificationsMutex.Unlock()
+	mods := loadModifications(root)
+	if len(mods.Dependencies) == 0 {
+		log.Printf("No packages installed by the generator are recorded")
+		return nil
+	}
+	if err := uninstallDependencies(root, mods.Dependencies); err != nil {
+		return err
+	}
+	if offline {
+		// Still to be removed by a later clean -deps.
+		return nil
+	}
+	mods.Dependencies = nil
+	return saveModifications(root, mods)
+}
+
+// generatedHeader starts the generated TypeScript and JavaScript files.
+const generatedHeader = "// This file is automatically generated by encore-restate-gen."
+
+// removeGeneratedCode removes restate.gen and the generated service files below root.
+func removeGeneratedCode(root string) {
+	walkDirs(root, func(dir string) error {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || !(strings.HasSuffix(name, ".restate.ts") || strings.HasSuffix(name, ".restate.js")) {
+				continue
+			}
+			path := filepath.Join(dir, name)
+			if data, err := ioutil.ReadFile(path); err == nil && strings.HasPrefix(string(data), generatedHeader) {
+				removeGenerated(path)
+				log.Printf("Removed generated file: %s", path)
+			}
+		}
+		migration := filepath.Join(dir, resultMigrationsDir, resultMigrationFile)
+		if data, err := ioutil.ReadFile(migration); err == nil && strings.HasPrefix(string(data), generatedSQLHeader) {
+			os.Remove(migration)
+			os.Remove(filepath.Dir(migration))
+			log.Printf("Removed generated file: %s", migration)
+		}
+		return nil
+	})
+	gen := filepath.Join(root, "restate.gen")
+	if _, err := os.Stat(gen); err == nil {
+		if err := os.RemoveAll(gen); err != nil {
+			log.Printf("Error removing %s: %v", gen, err)
+		} else {
+			log.Printf("Removed %s", gen)
+		}
+	}
+}
+
+// revertModifications removes the recorded configuration entries, the last added first, and
+// uninstalls the recorded dependencies that are still declared in package.json.
+func revertModifications(root string) error {
+	modificationsMutex.Lock()
+	defer modificationsMutex.Unlock()
+	mods := loadModifications(root)
+	files := make(map[string]*tsconfigFile)
+	removed := make(map[*tsconfigFile]int)
+	var order []*tsconfigFile
+	for i := len(mods.Entries) - 1; i >= 0; i-- {
+		entry := mods.Entries[i]
+		f, ok := files[entry.File]
+		if !ok {
+			data, err := ioutil.ReadFile(entry.File)
+			if err != nil {
+				continue
+			}
+			f = &tsconfigFile{path: entry.File, editor: newJSONCEditor(string(data))}
+			if f.root, err = parseJSONC(f.editor.src); err != nil {
+				return fmt.Errorf("failed to parse %s: %v", entry.File, err)
+			}
+			files[entry.File] = f
+			order = append(order, f)
+		}
+		ok, err := f.removeEntry(entry)
+		if err != nil {
+			return err
+		}
+		if ok {
+			removed[f]++
+		}
+	}
+	for _, f := range order {
+		if removed[f] == 0 {
+			continue
+		}
+		if err := ioutil.WriteFile(f.path, []byte(f.editor.src), 0644); err != nil {
+			return err
+		}
+		log.Printf("Removed %d entries added by the generator from %s", removed[f], f.path)
+	}
+	if err := uninstallDependencies(root, mods.Dependencies); err != nil {
+		return err
+	}
+	if path, err := modificationsPath(root); err == nil {
+		os.Remove(path)
+	}
+	return nil
+}
+
+// removeEntry removes entry from f and reports whether it was still there. Objects and arrays the
+// generator created are only removed once they are empty again.
+func (f *tsconfigFile) removeEntry(entry configEntry) (bool, error) {
+	var keys []string
+	if entry.Key != "" {
+		keys = strings.Split(entry.Key, ".")
+	}
+	container := f.lookup(keys...)
+	if container == nil {
+		return false, nil
+	}
+	index := -1
+	wrapped := "{" + entry.Entry + "}"
+	if member, err := parseJSONC(wrapped); err == nil && len(member.members) == 1 {
+		m := member.members[0]
+		created := (m.value.kind == '{' || m.value.kind == '[') && len(m.value.members)+len(m.value.elements) == 0
+		for i, current := range container.members {
+			if current.key != m.key {
+				continue
+			}
+			if created && len(current.value.members)+len(current.value.elements) == 0 ||
+				!created && sameJSON(f.editor.src[current.value.start:current.value.end], wrapped[m.value.start:m.value.end]) {
+				index = i
+			}
+		}
+	} else if element, err := parseJSONC(entry.Entry); err == nil && element.kind == '"' {
+		for i, current := range container.elements {
+			if current.kind == '"' && current.str == element.str {
+				index = i
+			}
+		}
+	}
+	if index < 0 {
+		return false, nil
+	}
+	f.editor.remove(container, index)
+	root, err := parseJSONC(f.editor.src)
+	if err != nil {
+		return false, fmt.Errorf("failed to revert %s: %v", f.path, err)
+	}
+	f.root = root
+	return true, nil
+}
+
+// sameJSON reports whether a and b are the same JSON text, apart from whitespace.
+func sameJSON(a, b string) bool {
+	var compactA, compactB bytes.Buffer
+	return json.Compact(&compactA, []byte(a)) == nil && json.Compact(&compactB, []byte(b)) == nil &&
+		compactA.String() == compactB.String()
+}
+
+// uninstallDependencies removes those of dependencies still declared in root's package.json with
+// the project's package manager.
+func uninstallDependencies(root string, dependencies []string) error {
+	data, err := ioutil.ReadFile(filepath.Join(root, "package.json"))
+	if err != nil {
+		return nil
+	}
+	var pkg struct {
+		Dependencies    map[string]string `json:"dependencies"`
+		DevDependencies map[string]string `json:"devDependencies"`
+	}
+	if err := json.Unmarshal(data, &pkg); err != nil {
+		return err
+	}
+	var declared []string
+	seen := make(map[string]bool)
+	for _, dep := range dependencies {
+		_, ok := pkg.Dependencies[dep]
+		_, devOK := pkg.DevDependencies[dep]
+		if (ok || devOK) && !seen[dep] {
+			seen[dep] = true
+			declared = append(declared, dep)
+		}
+	}
+	if len(declared) == 0 {
+		return nil
+	}
+	if offline {
+		log.Printf("Offline: not uninstalling the dependencies added by the generator: %v", declared)
+		return nil
+	}
+	command := "remove"
+	if globalPackageManager == "npm" {
+		command = "uninstall"
+	}
+	cmd := exec.Command(globalPackageManager, append([]string{command}, declared...)...)
+	cmd.Dir = root
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	log.Printf("Uninstalling dependencies added by the generator: %v", declared)
+	return cmd.Run()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Ports of the ingress and admin API of a Restate Cloud environment.
+const (
+	cloudIngressPort = "8080"
+	cloudAdminPort   = "9070"
+)
+
+// defaultTokenEnv is the environment variable holding the Restate API token of admin calls.
+const defaultTokenEnv = "RESTATE_AUTH_TOKEN"
+
+// cloudURL returns the URL of the configured Restate Cloud environment at port, or "" if none is
+// configured. A port given in the environment URL is replaced.
+func cloudURL(port string) string {
+	if projectConfig.Cloud == nil {
+		return ""
+	}
+	u, err := url.Parse(projectConfig.Cloud.Environment)
+	if err != nil {
+		return ""
+	}
+	u.Host = net.JoinHostPort(u.Hostname(), port)
+	u.Path = ""
+	return strings.TrimSuffix(u.String(), "/")
+}
+
+// adminToken returns the API token sent to the Restate admin API, from the tokenEnv of the target
+// environment or of cloud, or RESTATE_AUTH_TOKEN. It is read on every call, so a rotated token is picked up.
+func adminToken() (token, env string) {
+	env = defaultTokenEnv
+	if t := targetEnvironment; t != nil {
+		if t.TokenEnv != "" {
+			env = t.TokenEnv
+		}
+	} else if c := projectConfig.Cloud; c != nil && c.TokenEnv != "" {
+		env = c.TokenEnv
+	}
+	return os.Getenv(env), env
+}
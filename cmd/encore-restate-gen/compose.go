@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// composeFileName is the Docker Compose file written by the compose command.
+const composeFileName = "restate.compose.yml"
+
+// Ports the Restate server listens on in its container.
+const (
+	restateIngressPort = "8080"
+	restateAdminPort   = "9070"
+)
+
+// composeTemplate is the Docker Compose file running the Restate server. The host ports are those
+// of the ingress and admin URLs the generated code and the generator use.
+const composeTemplate = `# This file is automatically generated by encore-restate-gen from encore-restate-gen.json.
+# Do not edit this file directly; it is rewritten when the configured Restate URLs change.
+# Start the Restate server with: docker compose -f %s up
+services:
+  restate:
+    image: %s
+    ports:
+      - "%s:%s"
+      - "%s:%s"
+    # Restate reaches the Encore app on the host at host.docker.internal.
+    extra_hosts:
+      - "host.docker.internal:host-gateway"
+    volumes:
+      - restate-data:/restate-data
+volumes:
+  restate-data:
+`
+
+// localPort returns the port of rawURL, which must be on this machine, for naming it.
+func localPort(name, rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	switch u.Hostname() {
+	case "localhost", "127.0.0.1", "::1":
+	default:
+		return "", fmt.Errorf("the %s %s is not on this machine", name, rawURL)
+	}
+	if port := u.Port(); port != "" {
+		return port, nil
+	}
+	if u.Scheme == "https" {
+		return "443", nil
+	}
+	return "80", nil
+}
+
+// restateHostPorts returns the host ports a local Restate server must publish its ingress and admin
+// API at for the configured URLs.
+func restateHostPorts() (ingress, admin string, err error) {
+	if ingress, err = localPort("ingress", defaultIngress()); err != nil {
+		return "", "", err
+	}
+	if admin, err = localPort("admin API", adminURL()); err != nil {
+		return "", "", err
+	}
+	return ingress, admin, nil
+}
+
+// writeComposeFile writes the Docker Compose file of root.
+func writeComposeFile(root string) error {
+	ingress, admin, err := restateHostPorts()
+	if err != nil {
+		return err
+	}
+	content := fmt.Sprintf(composeTemplate, composeFileName, restateImage, ingress, restateIngressPort, admin, restateAdminPort)
+	return ioutil.WriteFile(filepath.Join(root, composeFileName), []byte(content), 0644)
+}
+
+// updateComposeFile rewrites the Docker Compose file of root, if the compose command created one,
+// so its ports follow the configuration.
+func updateComposeFile(root string) {
+	if _, err := os.Stat(filepath.Join(root, composeFileName)); err != nil {
+		return
+	}
+	if err := writeComposeFile(root); err != nil {
+		log.Printf("Error updating %s: %v", composeFileName, err)
+	}
+}
+
+// composeMain implements the compose subcommand, which writes a Docker Compose file running a
+// Restate server at the configured ingress and admin URLs.
+func composeMain(args []string) {
+	flags := flag.NewFlagSet("compose", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s compose [project root]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	root := "."
+	if flags.NArg() > 0 {
+		root = flags.Arg(0)
+	}
+	projectRoot = root
+	cfg, err := loadConfig(root)
+	if err != nil {
+		log.Fatalf("Failed to load %s: %v", configFileName, err)
+	}
+	projectConfig = cfg
+	if cfg.Cloud != nil {
+		log.Fatalf("The Restate server is the Restate Cloud environment %s; remove the cloud section to run one locally", cfg.Cloud.Environment)
+	}
+	if err := writeComposeFile(root); err != nil {
+		log.Fatalf("Error writing %s: %v", composeFileName, err)
+	}
+	log.Printf("Wrote %s", filepath.Join(root, composeFileName))
+	if r := cfg.Register; r == nil || r.DeploymentURL == "" {
+		u, _ := url.Parse(encoreURL())
+		log.Printf("To register endpoints with this server, set register.deploymentUrl to %s", strings.Replace(u.String(), u.Hostname(), "host.docker.internal", 1))
+	}
+}
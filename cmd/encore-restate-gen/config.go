@@ -0,0 +1,457 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// configFileName is the optional per-project configuration file, read from the project root.
+const configFileName = "encore-restate-gen.json"
+
+// RetryConfig controls how the generated ingress client retries failed calls.
+type RetryConfig struct {
+	MaxAttempts    int `json:"maxAttempts,omitempty"`
+	InitialDelayMs int `json:"initialDelayMs,omitempty"`
+	MaxDelayMs     int `json:"maxDelayMs,omitempty"`
+}
+
+// ClientConfig holds the defaults baked into the generated ingress client.
+type ClientConfig struct {
+	TimeoutMs int               `json:"timeoutMs,omitempty"`
+	Retry     *RetryConfig      `json:"retry,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	// AuthTokenSecret names the Encore secret holding the ingress bearer token.
+	AuthTokenSecret string `json:"authTokenSecret,omitempty"`
+	// AdminTokenSecret names the Encore secret holding the bearer token of the admin API calls of
+	// generated code. Defaults to the ingress token.
+	AdminTokenSecret string `json:"adminTokenSecret,omitempty"`
+	// ForwardAuth forwards the Encore auth data of the request a call is made in to the invoked
+	// handler, as headers it can read back with authData and authUserId.
+	ForwardAuth bool `json:"forwardAuth,omitempty"`
+	// PropagateTrace sends the trace of the Encore request a call is made in as a W3C traceparent
+	// header, so Restate's invocation traces join Encore's.
+	PropagateTrace bool `json:"propagateTrace,omitempty"`
+}
+
+// InstallConfig controls how the Restate modules are added to package.json.
+type InstallConfig struct {
+	// Dev adds them to devDependencies if true, or dependencies if false. By default they are
+	// added next to the Restate modules already declared, or else to dependencies.
+	Dev *bool `json:"dev,omitempty"`
+	// Prefix is the semver range prefix of the added versions, "^" or "~". By default the package
+	// manager's is used.
+	Prefix string `json:"prefix,omitempty"`
+	// Exact pins the added modules to exact versions.
+	Exact bool `json:"exact,omitempty"`
+	// Peers installs typescript and @types/node as dev dependencies if the generated TypeScript
+	// needs them and they are missing. Defaults to true; if false, they are only reported.
+	Peers *bool `json:"peers,omitempty"`
+	// TimeoutMs bounds a single run of the package manager; it is killed when it runs longer.
+	TimeoutMs int `json:"timeoutMs,omitempty"`
+	// Retries is how often an install failing with a transient network or registry error is
+	// retried. Defaults to 2.
+	Retries *int `json:"retries,omitempty"`
+}
+
+// RegisterConfig configures the registration of the generated endpoints with Restate.
+type RegisterConfig struct {
+	// AdminURL is the Restate admin API. Defaults to RESTATE_ADMIN_URL or http://localhost:9070.
+	AdminURL string `json:"adminUrl,omitempty"`
+	// EncoreURL is the base URL the generator reaches the Encore app at. Defaults to
+	// http://localhost:4000.
+	EncoreURL string `json:"encoreUrl,omitempty"`
+	// DeploymentURL is the base URL Restate reaches the Encore app at, e.g.
+	// http://host.docker.internal:4000 for a Restate server in Docker. Defaults to EncoreURL.
+	DeploymentURL string `json:"deploymentUrl,omitempty"`
+	// ReadyTimeoutMs bounds the wait for the Encore app to serve a generated endpoint before it is
+	// registered. Defaults to 2 minutes.
+	ReadyTimeoutMs int `json:"readyTimeoutMs,omitempty"`
+}
+
+// EnvironmentConfig is a named target the generated endpoints are deployed to and registered in.
+// In encore-restate-gen.json it is an object, or a string setting only DeploymentURL.
+type EnvironmentConfig struct {
+	// DeploymentURL is the base URL Restate reaches the Encore app at there, e.g.
+	// https://staging-myapp.encr.app.
+	DeploymentURL string `json:"deploymentUrl"`
+	// AdminURL is the environment's Restate admin API, needed to register there. The generated code
+	// calls it there too.
+	AdminURL string `json:"adminUrl,omitempty"`
+	// IngressURL is the environment's Restate ingress, which the generated client calls when the
+	// Encore environment of that name runs it.
+	IngressURL string `json:"ingressUrl,omitempty"`
+	// TokenEnv names the environment variable holding the API token of the admin API there.
+	// Defaults to RESTATE_AUTH_TOKEN.
+	TokenEnv string `json:"tokenEnv,omitempty"`
+}
+
+// UnmarshalJSON accepts a deployment URL in place of the object.
+func (e *EnvironmentConfig) UnmarshalJSON(data []byte) error {
+	var deploymentURL string
+	if err := json.Unmarshal(data, &deploymentURL); err == nil {
+		*e = EnvironmentConfig{DeploymentURL: deploymentURL}
+		return nil
+	}
+	type plain EnvironmentConfig
+	return json.Unmarshal(data, (*plain)(e))
+}
+
+// CloudConfig points the generator and the generated client at a Restate Cloud environment.
+type CloudConfig struct {
+	// Environment is the URL of the environment, e.g. https://201hy10cd3h6426jy8ma.env.us.restate.cloud.
+	// Its ingress is reached on port 8080 and its admin API on port 9070.
+	Environment string `json:"environment"`
+	// TokenEnv names the environment variable holding the API token of admin calls. Defaults to
+	// RESTATE_AUTH_TOKEN.
+	TokenEnv string `json:"tokenEnv,omitempty"`
+	// TokenSecret names the Encore secret holding the API token of the generated ingress client,
+	// like client.authTokenSecret.
+	TokenSecret string `json:"tokenSecret,omitempty"`
+}
+
+// IdentityConfig restricts the generated endpoints to requests signed by the given Restate servers.
+type IdentityConfig struct {
+	// Keys are the identity public keys of the Restate servers, starting with publickeyv1_.
+	Keys []string `json:"keys,omitempty"`
+	// KeysSecret names an Encore secret holding more keys, separated by commas or whitespace.
+	KeysSecret string `json:"keysSecret,omitempty"`
+	// KeysURL is fetched for more keys, e.g. from the description of a Restate Cloud environment.
+	// Every key in its answer is accepted; the admin API token is sent along.
+	KeysURL string `json:"keysUrl,omitempty"`
+}
+
+// SharedSecretConfig makes the generated endpoints require a secret header on every request, a
+// simpler alternative to identity verification.
+type SharedSecretConfig struct {
+	// Secret names the Encore secret holding the value the endpoints require.
+	Secret string `json:"secret"`
+	// Header is the request header carrying it. Defaults to x-restate-shared-secret.
+	Header string `json:"header,omitempty"`
+	// Env names the environment variable the generator reads the value from when registering the
+	// endpoints, so Restate sends it along. Defaults to RESTATE_SHARED_SECRET.
+	Env string `json:"env,omitempty"`
+}
+
+// HookConfig is an action run after generation. Exactly one of Command, URL and Touch is set.
+type HookConfig struct {
+	// On is "service" to run the hook after a service's file is generated, or "index", the
+	// default, after the central index is.
+	On string `json:"on,omitempty"`
+	// Command is run with the shell in the project root.
+	Command string `json:"command,omitempty"`
+	// URL receives a POST describing the generated file.
+	URL string `json:"url,omitempty"`
+	// Touch, relative to the project root, is created or has its modification time updated.
+	Touch string `json:"touch,omitempty"`
+}
+
+// Config is the content of encore-restate-gen.json.
+type Config struct {
+	Client ClientConfig `json:"client"`
+	// Output selects the generated language: "ts" (default) or "js" for .js plus .d.ts files.
+	Output string `json:"output"`
+	// Extractor selects the extraction backend: "node" or "go". By default node is used if a
+	// JavaScript runtime is on PATH.
+	Extractor string `json:"extractor"`
+	// EndpointHandler selects the Restate SDK handler the generated endpoints wrap: "fetch"
+	// (default), which also suits edge runtimes, or "lambda" for services deployed to AWS Lambda.
+	EndpointHandler string `json:"endpointHandler,omitempty"`
+	// Runtime forces the JavaScript runtime running the extractor: "node", "bun" or "deno".
+	Runtime string `json:"runtime,omitempty"`
+	// ServiceDiscovery selects how service directories are found: "files" (default) by the service
+	// markers, or "encore" as Encore does, by the new Service("name") declaration of the
+	// encore.service.ts files of the app in encore.app.
+	ServiceDiscovery string `json:"serviceDiscovery,omitempty"`
+	// ServiceMarkers are the file names or globs marking a service directory, tried in order.
+	// Defaults to encore.service.ts.
+	ServiceMarkers []string `json:"serviceMarkers,omitempty"`
+	// DefaultHandlerName names default-exported handlers; "{file}" is replaced by the camel-cased
+	// file name. Defaults to "{file}".
+	DefaultHandlerName string `json:"defaultHandlerName,omitempty"`
+	// ExtractTimeoutMs bounds a single extraction; a worker that does not answer in time is killed.
+	ExtractTimeoutMs int `json:"extractTimeoutMs,omitempty"`
+	// ExtractScript, relative to the project root, replaces the embedded extraction bundle.
+	ExtractScript string `json:"extractScript,omitempty"`
+	// PostProcessScript, relative to the project root, rewrites every extracted manifest.
+	PostProcessScript string `json:"postProcessScript,omitempty"`
+	// Ignore lists gitignore-style patterns, relative to the project root, of paths not to scan
+	// or watch, in addition to the project's .gitignore files.
+	Ignore []string `json:"ignore,omitempty"`
+	// Aliases selects how generated code is imported: "paths" (default) for ~restate aliases in
+	// tsconfig.json, or "imports" for #restate subpath imports in package.json.
+	Aliases string `json:"aliases,omitempty"`
+	// NoTsconfig stops the tool from patching tsconfig.json, like -no-tsconfig; the ~restate
+	// aliases must then be configured by hand.
+	NoTsconfig bool `json:"noTsconfig,omitempty"`
+	// SaveExact is the deprecated name of install.exact, still read into it.
+	SaveExact bool `json:"saveExact,omitempty"`
+	// Install controls where and with what range prefix the Restate modules are installed.
+	Install InstallConfig `json:"install,omitempty"`
+	// Offline stops the tool from running the package manager and calling webhooks, like -offline.
+	Offline bool `json:"offline,omitempty"`
+	// Cloud configures a Restate Cloud environment for admin calls and the generated client.
+	Cloud *CloudConfig `json:"cloud,omitempty"`
+	// Identity makes the generated endpoints verify that requests are signed by Restate.
+	Identity *IdentityConfig `json:"identity,omitempty"`
+	// SharedSecret makes the generated endpoints require a secret header sent by Restate.
+	SharedSecret *SharedSecretConfig `json:"sharedSecret,omitempty"`
+	// Register registers the generated endpoints with the Restate admin API, like -register.
+	Register *RegisterConfig `json:"register,omitempty"`
+	// WorkflowEndpoints lists the Encore services whose workflow gets exposed endpoints starting,
+	// querying and cancelling runs at /workflows/<service>.
+	WorkflowEndpoints []string `json:"workflowEndpoints,omitempty"`
+	// PublicWorkflowEndpoints lists the services of workflowEndpoints whose endpoints are exposed
+	// without authentication. The endpoints of the others require the app's auth handler.
+	PublicWorkflowEndpoints []string `json:"publicWorkflowEndpoints,omitempty"`
+	// WorkflowProgress names, per service of workflowEndpoints, the shared workflow handler taking no
+	// input whose result the stream endpoint sends as the progress of a run.
+	WorkflowProgress map[string]string `json:"workflowProgress,omitempty"`
+	// ResultCache lists the Encore services whose workflow results are cached in an Encore SQL
+	// database of the service, for fast reads next to the state in Restate.
+	ResultCache []string `json:"resultCache,omitempty"`
+	// Environments are the targets besides local, the one of the register section, by name. They
+	// are described in restate.gen/restate.deploy.json and can be registered in with register -env.
+	Environments map[string]EnvironmentConfig `json:"environments,omitempty"`
+	// Hooks run after generation, e.g. to lint the generated files or notify a dev server.
+	Hooks []HookConfig `json:"hooks,omitempty"`
+}
+
+// Extraction backends selected by the "extractor" config key.
+const (
+	extractorNode = "node"
+	extractorGo   = "go"
+)
+
+// loadConfig reads the project configuration from root. A missing file yields the default Config.
+func loadConfig(root string) (Config, error) {
+	cfg := Config{Output: outputTypeScript, Aliases: aliasesPaths, EndpointHandler: endpointFetch}
+	data, err := ioutil.ReadFile(filepath.Join(root, configFileName))
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse %s: %v", configFileName, err)
+	}
+	switch cfg.Output {
+	case "":
+		cfg.Output = outputTypeScript
+	case outputTypeScript, outputJavaScript:
+	default:
+		return cfg, fmt.Errorf("output must be %q or %q, got %q", outputTypeScript, outputJavaScript, cfg.Output)
+	}
+	switch cfg.EndpointHandler {
+	case "":
+		cfg.EndpointHandler = endpointFetch
+	case endpointFetch, endpointLambda:
+	default:
+		return cfg, fmt.Errorf("endpointHandler must be %q or %q, got %q", endpointFetch, endpointLambda, cfg.EndpointHandler)
+	}
+	switch cfg.Aliases {
+	case "":
+		cfg.Aliases = aliasesPaths
+	case aliasesPaths, aliasesImports:
+	default:
+		return cfg, fmt.Errorf("aliases must be %q or %q, got %q", aliasesPaths, aliasesImports, cfg.Aliases)
+	}
+	switch cfg.Extractor {
+	case "", extractorNode, extractorGo:
+	default:
+		return cfg, fmt.Errorf("extractor must be %q or %q, got %q", extractorNode, extractorGo, cfg.Extractor)
+	}
+	switch cfg.Runtime {
+	case "", runtimeNode, runtimeBun, runtimeDeno:
+	default:
+		return cfg, fmt.Errorf("runtime must be %q, %q or %q, got %q", runtimeNode, runtimeBun, runtimeDeno, cfg.Runtime)
+	}
+	switch cfg.ServiceDiscovery {
+	case "", discoveryFiles:
+	case discoveryEncore:
+		if len(cfg.ServiceMarkers) > 0 {
+			return cfg, fmt.Errorf("serviceMarkers cannot be set with serviceDiscovery %q, which uses %s", discoveryEncore, defaultServiceMarker)
+		}
+		app, err := readEncoreApp(root)
+		if err != nil {
+			return cfg, fmt.Errorf("serviceDiscovery %q needs the Encore app: %v", discoveryEncore, err)
+		}
+		if app.Lang != "" && app.Lang != "typescript" {
+			return cfg, fmt.Errorf("serviceDiscovery %q only supports TypeScript Encore apps, %s has lang %q", discoveryEncore, encoreAppFile, app.Lang)
+		}
+	default:
+		return cfg, fmt.Errorf("serviceDiscovery must be %q or %q, got %q", discoveryFiles, discoveryEncore, cfg.ServiceDiscovery)
+	}
+	for service := range cfg.WorkflowProgress {
+		if !stringIn(cfg.WorkflowEndpoints, service) {
+			return cfg, fmt.Errorf("workflowProgress names the service %s, which workflowEndpoints does not list", service)
+		}
+	}
+	for _, service := range cfg.PublicWorkflowEndpoints {
+		if !stringIn(cfg.WorkflowEndpoints, service) {
+			return cfg, fmt.Errorf("publicWorkflowEndpoints names the service %s, which workflowEndpoints does not list", service)
+		}
+	}
+	for _, marker := range cfg.ServiceMarkers {
+		if _, err := filepath.Match(marker, ""); err != nil || marker == "" || strings.ContainsAny(marker, `/\`) {
+			return cfg, fmt.Errorf("serviceMarkers entry %q must be a file name or glob", marker)
+		}
+	}
+	for _, script := range []string{cfg.ExtractScript, cfg.PostProcessScript} {
+		if script == "" {
+			continue
+		}
+		path := script
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(root, path)
+		}
+		if _, err := os.Stat(path); err != nil {
+			return cfg, fmt.Errorf("script %s not found", script)
+		}
+	}
+	for _, pattern := range cfg.Ignore {
+		if strings.TrimSpace(pattern) == "" || strings.Contains(pattern, "\n") {
+			return cfg, fmt.Errorf("ignore entry %q must be a single non-empty pattern", pattern)
+		}
+	}
+	for i, hook := range cfg.Hooks {
+		switch hook.On {
+		case "", hookService, hookIndex:
+		default:
+			return cfg, fmt.Errorf("hooks[%d].on must be %q or %q, got %q", i, hookService, hookIndex, hook.On)
+		}
+		actions := 0
+		for _, action := range []string{hook.Command, hook.URL, hook.Touch} {
+			if action != "" {
+				actions++
+			}
+		}
+		if actions != 1 {
+			return cfg, fmt.Errorf("hooks[%d] must set exactly one of command, url and touch", i)
+		}
+		if hook.URL != "" {
+			if u, err := url.Parse(hook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return cfg, fmt.Errorf("hooks[%d].url %q must be an http or https URL", i, hook.URL)
+			}
+		}
+	}
+	for name, env := range cfg.Environments {
+		if name == localEnvironment {
+			return cfg, fmt.Errorf("environments.%s is configured by the register section", name)
+		}
+		if env.DeploymentURL == "" {
+			return cfg, fmt.Errorf("environments.%s.deploymentUrl is required", name)
+		}
+		for key, value := range map[string]string{"deploymentUrl": env.DeploymentURL, "adminUrl": env.AdminURL, "ingressUrl": env.IngressURL} {
+			if u, err := url.Parse(value); value != "" && (err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "") {
+				return cfg, fmt.Errorf("environments.%s.%s %q must be an http or https URL", name, key, value)
+			}
+		}
+	}
+	if r := cfg.Register; r != nil {
+		for key, value := range map[string]string{"adminUrl": r.AdminURL, "encoreUrl": r.EncoreURL, "deploymentUrl": r.DeploymentURL} {
+			if u, err := url.Parse(value); value != "" && (err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "") {
+				return cfg, fmt.Errorf("register.%s %q must be an http or https URL", key, value)
+			}
+		}
+	}
+	if cfg.Register != nil && cfg.Register.ReadyTimeoutMs < 0 {
+		return cfg, fmt.Errorf("register.readyTimeoutMs must not be negative")
+	}
+	if cfg.ExtractTimeoutMs < 0 {
+		return cfg, fmt.Errorf("extractTimeoutMs must not be negative")
+	}
+	switch cfg.Install.Prefix {
+	case "", "^", "~":
+	default:
+		return cfg, fmt.Errorf("install.prefix must be %q or %q, got %q", "^", "~", cfg.Install.Prefix)
+	}
+	if cfg.SaveExact {
+		log.Printf("Warning: saveExact in %s is deprecated; use \"install\": { \"exact\": true }", configFileName)
+		cfg.Install.Exact = true
+	}
+	if cfg.Install.Prefix != "" && cfg.Install.Exact {
+		return cfg, fmt.Errorf("install.prefix and install.exact cannot be combined")
+	}
+	if cfg.Install.TimeoutMs < 0 {
+		return cfg, fmt.Errorf("install.timeoutMs must not be negative")
+	}
+	if cfg.Install.Retries != nil && *cfg.Install.Retries < 0 {
+		return cfg, fmt.Errorf("install.retries must not be negative")
+	}
+	if cfg.Client.TimeoutMs < 0 {
+		return cfg, fmt.Errorf("client.timeoutMs must not be negative")
+	}
+	if c := cfg.Cloud; c != nil {
+		if u, err := url.Parse(c.Environment); err != nil || u.Scheme != "https" || u.Host == "" {
+			return cfg, fmt.Errorf("cloud.environment %q must be an https URL", c.Environment)
+		}
+		if !validSecretName(c.TokenSecret) {
+			return cfg, fmt.Errorf("cloud.tokenSecret %q is not a valid Encore secret name", c.TokenSecret)
+		}
+		if c.TokenSecret != "" && cfg.Client.AuthTokenSecret != "" && c.TokenSecret != cfg.Client.AuthTokenSecret {
+			return cfg, fmt.Errorf("cloud.tokenSecret and client.authTokenSecret name different secrets")
+		}
+		if cfg.Client.AuthTokenSecret == "" {
+			cfg.Client.AuthTokenSecret = c.TokenSecret
+		}
+	}
+	if id := cfg.Identity; id != nil {
+		if len(id.Keys) == 0 && id.KeysSecret == "" && id.KeysURL == "" {
+			return cfg, fmt.Errorf("identity must set keys, keysSecret or keysUrl")
+		}
+		if u, err := url.Parse(id.KeysURL); id.KeysURL != "" && (err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "") {
+			return cfg, fmt.Errorf("identity.keysUrl %q must be an http or https URL", id.KeysURL)
+		}
+		for _, key := range id.Keys {
+			if !strings.HasPrefix(key, "publickeyv1_") {
+				return cfg, fmt.Errorf("identity key %q must start with publickeyv1_", key)
+			}
+		}
+		if !validSecretName(id.KeysSecret) {
+			return cfg, fmt.Errorf("identity.keysSecret %q is not a valid Encore secret name", id.KeysSecret)
+		}
+	}
+	if ss := cfg.SharedSecret; ss != nil {
+		if ss.Secret == "" || !validSecretName(ss.Secret) {
+			return cfg, fmt.Errorf("sharedSecret.secret %q must name an Encore secret", ss.Secret)
+		}
+		if ss.Header == "" {
+			ss.Header = defaultSharedSecretHeader
+		}
+		if !headerNameRe.MatchString(ss.Header) {
+			return cfg, fmt.Errorf("sharedSecret.header %q is not a valid header name", ss.Header)
+		}
+		// Node lowercases the names of incoming headers.
+		ss.Header = strings.ToLower(ss.Header)
+		if ss.Env == "" {
+			ss.Env = defaultSharedSecretEnv
+		}
+	}
+	if !validSecretName(cfg.Client.AuthTokenSecret) {
+		return cfg, fmt.Errorf("client.authTokenSecret %q is not a valid Encore secret name", cfg.Client.AuthTokenSecret)
+	}
+	if !validSecretName(cfg.Client.AdminTokenSecret) {
+		return cfg, fmt.Errorf("client.adminTokenSecret %q is not a valid Encore secret name", cfg.Client.AdminTokenSecret)
+	}
+	if r := cfg.Client.Retry; r != nil && (r.MaxAttempts < 0 || r.InitialDelayMs < 0 || r.MaxDelayMs < 0) {
+		return cfg, fmt.Errorf("client.retry values must not be negative")
+	}
+	return cfg, nil
+}
+
+var secretNameRe = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+var headerNameRe = regexp.MustCompile("^[A-Za-z0-9!#$%&'*+.^_`|~-]+$")
+
+// validSecretName reports whether name can be used as an Encore secret name. Empty is allowed.
+func validSecretName(name string) bool {
+	return name == "" || secretNameRe.MatchString(name)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// deployFileName is the deployment descriptor written to restate.gen.
+const deployFileName = "restate.deploy.json"
+
+// restateTypes maps handler types to the component types of Restate's endpoint manifest.
+var restateTypes = map[string]string{
+	"service":       "SERVICE",
+	"workflow":      "WORKFLOW",
+	"virtualObject": "VIRTUAL_OBJECT",
+}
+
+// deployComponent is a Restate service, workflow or virtual object served by a generated endpoint.
+type deployComponent struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Handlers []string `json:"handlers"`
+}
+
+// deployment describes the registration of one generated endpoint in an environment.
+type deployment struct {
+	Service    string            `json:"service"`
+	URI        string            `json:"uri"`
+	Components []deployComponent `json:"components"`
+	// Registration is the request body registering the deployment with the Restate admin API.
+	Registration map[string]interface{} `json:"registration"`
+}
+
+// restateComponentsOf returns the Restate components the endpoint generated for data serves,
+// sorted by name.
+func restateComponentsOf(data TemplateData) []deployComponent {
+	byName := make(map[string]*deployComponent)
+	var components []*deployComponent
+	add := func(name, handlerType string, handlers ...string) {
+		c, ok := byName[name]
+		if !ok {
+			c = &deployComponent{Name: name, Type: restateTypes[handlerType]}
+			byName[name] = c
+			components = append(components, c)
+		}
+		c.Handlers = append(c.Handlers, handlers...)
+	}
+	for _, group := range []struct {
+		handlerType string
+		groups      []GroupedHandler
+	}{{"service", data.ServiceGroup}, {"workflow", data.WorkflowGroup}, {"virtualObject", data.VirtualObjectGroup}} {
+		for _, g := range group.groups {
+			for _, h := range g.Handlers {
+				add(data.ServiceNameTrimmed+restateComponents[group.handlerType].Suffix, group.handlerType, h.ExportName)
+			}
+		}
+	}
+	for _, def := range data.Definitions {
+		add(def.Name, def.Type, def.Handlers...)
+	}
+	result := make([]deployComponent, len(components))
+	for i, c := range components {
+		result[i] = *c
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// deployEnvironments returns the base URLs Restate reaches the Encore app at, per environment:
+// local, from the register settings, and the configured environments.
+func deployEnvironments() map[string]string {
+	envs := map[string]string{localEnvironment: strings.TrimSuffix(localDeploymentURL(""), "/")}
+	for name, env := range projectConfig.Environments {
+		envs[name] = strings.TrimSuffix(env.DeploymentURL, "/")
+	}
+	return envs
+}
+
+// generateDeployDescriptor writes restate.gen/restate.deploy.json, describing per environment the
+// deployment of each generated endpoint, so infrastructure pipelines can register them.
+func generateDeployDescriptor(root string, datas []TemplateData) error {
+	sort.Slice(datas, func(i, j int) bool { return datas[i].ServiceName < datas[j].ServiceName })
+	// The shared secret is not written to the file; pipelines substitute its environment variable.
+	secretPlaceholder := ""
+	if ss := projectConfig.SharedSecret; ss != nil {
+		secretPlaceholder = "${" + ss.Env + "}"
+	}
+	environments := make(map[string]interface{})
+	for name, base := range deployEnvironments() {
+		deployments := []deployment{}
+		for _, data := range datas {
+			uri := base + "/" + data.ServiceName
+			deployments = append(deployments, deployment{
+				Service:      data.ServiceName,
+				URI:          uri,
+				Components:   restateComponentsOf(data),
+				Registration: registrationBody(uri, secretPlaceholder, false),
+			})
+		}
+		environments[name] = map[string]interface{}{"deployments": deployments}
+	}
+	doc := map[string]interface{}{
+		"description": "This file is automatically generated by encore-restate-gen. Do not edit this file directly.",
+		// Restate calls the generated raw endpoints over HTTP/1.1, one request per invocation.
+		"protocol": map[string]string{
+			"mode":       "REQUEST_RESPONSE",
+			"http":       "1.1",
+			"sdkVersion": installedPackageVersion(root, "@restatedev/restate-sdk"),
+		},
+		"environments": environments,
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(root, "restate.gen", deployFileName), append(data, '\n'), 0644)
+}
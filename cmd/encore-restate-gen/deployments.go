@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+// restateDeployment is a deployment as listed by the Restate admin API.
+type restateDeployment struct {
+	ID       string `json:"id"`
+	URI      string `json:"uri"`
+	Services []struct {
+		Name string `json:"name"`
+	} `json:"services"`
+}
+
+// deploymentsMain implements the deployments subcommand. prune removes the deployments of this app
+// from the Restate server whose services no longer exist locally; drift reports the differences
+// between the registered services and the local ones.
+func deploymentsMain(args []string) {
+	flags := flag.NewFlagSet("deployments", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "with prune, only list the deployments that would be removed")
+	env := flags.String("env", localEnvironment, "the environment whose Restate server to compare with")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s deployments prune [flags] [project root]\n       %s deployments drift [-env name] [project root]\n", os.Args[0], os.Args[0])
+		flags.PrintDefaults()
+	}
+	if len(args) == 0 || (args[0] != "prune" && args[0] != "drift") {
+		flags.Usage()
+		os.Exit(2)
+	}
+	flags.Parse(args[1:])
+	root := "."
+	if flags.NArg() > 0 {
+		root = flags.Arg(0)
+	}
+	projectRoot = root
+	cfg, err := loadConfig(root)
+	if err != nil {
+		log.Fatalf("Failed to load %s: %v", configFileName, err)
+	}
+	projectConfig = cfg
+	if err := selectEnvironment(*env); err != nil {
+		log.Fatalf("%v", err)
+	}
+	projectIgnore = newGitignore(root, cfg.Ignore)
+	nodeWorkers = newWorkerPool(scanConcurrency)
+	drifts := 0
+	if args[0] == "prune" {
+		err = pruneDeployments(root, *dryRun)
+	} else {
+		drifts, err = reportDrift(root)
+	}
+	nodeWorkers.stop()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if drifts > 0 {
+		log.Fatalf("%d differences between Restate and the local services found", drifts)
+	}
+}
+
+// localComponent is a Restate component defined by a local service.
+type localComponent struct {
+	Service  string
+	Handlers []string
+}
+
+// localServices returns the template data of the services of root.
+func localServices(root string) ([]TemplateData, error) {
+	dirs := serviceDirs(root)
+	var datas []TemplateData
+	for i, result := range extractAll(dirs) {
+		if result.err != nil {
+			// A service that cannot be read might still define any deployment's components.
+			return nil, fmt.Errorf("cannot tell which services exist, extracting %s failed: %v", dirs[i], result.err)
+		}
+		if result.manifest.ServiceName == "" {
+			continue
+		}
+		if data, ok := templateData(dirs[i], result.manifest); ok {
+			datas = append(datas, data)
+		}
+	}
+	return datas, nil
+}
+
+// localComponents returns the Restate components the services of root define, by name.
+func localComponents(root string) (map[string]localComponent, error) {
+	datas, err := localServices(root)
+	if err != nil {
+		return nil, err
+	}
+	components := make(map[string]localComponent)
+	for _, data := range datas {
+		for name, handlers := range expectedComponents(data) {
+			components[name] = localComponent{Service: data.ServiceName, Handlers: handlers}
+		}
+	}
+	return components, nil
+}
+
+// appDeployments returns the deployments registered at this app's deployment URL.
+func appDeployments() ([]restateDeployment, error) {
+	answer, err := adminRequest("GET", "/deployments", nil)
+	if err != nil {
+		return nil, err
+	}
+	var list struct {
+		Deployments []restateDeployment `json:"deployments"`
+	}
+	if err := json.Unmarshal(answer, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse the deployments of %s: %v", adminURL(), err)
+	}
+	var deployments []restateDeployment
+	for _, d := range list.Deployments {
+		// Others are deployed elsewhere, not by this app.
+		if strings.HasPrefix(d.URI, deploymentURL("")) {
+			deployments = append(deployments, d)
+		}
+	}
+	return deployments, nil
+}
+
+// pruneDeployments removes the deployments registered at this app's deployment URL that serve
+// none of the Restate components defined locally, or with dryRun only logs them.
+func pruneDeployments(root string, dryRun bool) error {
+	local, err := localComponents(root)
+	if err != nil {
+		return err
+	}
+	deployments, err := appDeployments()
+	if err != nil {
+		return err
+	}
+	base := deploymentURL("")
+	pruned := 0
+	for _, d := range deployments {
+		var names []string
+		live := false
+		for _, s := range d.Services {
+			names = append(names, s.Name)
+			_, ok := local[s.Name]
+			live = live || ok
+		}
+		if live {
+			continue
+		}
+		pruned++
+		if dryRun {
+			log.Printf("Would remove deployment %s at %s, serving %v", d.ID, d.URI, names)
+			continue
+		}
+		if _, err := adminRequest("DELETE", "/deployments/"+url.PathEscape(d.ID)+"?force=true", nil); err != nil {
+			return err
+		}
+		log.Printf("Removed deployment %s at %s, serving %v", d.ID, d.URI, names)
+	}
+	if pruned == 0 {
+		log.Printf("No deployments at %s serve services that no longer exist", base)
+	}
+	return nil
+}
+
+// registeredService is a service as listed by the Restate admin API.
+type registeredService struct {
+	Name         string `json:"name"`
+	DeploymentID string `json:"deployment_id"`
+	Handlers     []struct {
+		Name string `json:"name"`
+	} `json:"handlers"`
+}
+
+// reportDrift logs the Restate components and handlers defined locally but not registered with
+// Restate at this app's deployment URL, and those registered but no longer defined, with the
+// action fixing each. It returns the number of differences.
+func reportDrift(root string) (int, error) {
+	local, err := localComponents(root)
+	if err != nil {
+		return 0, err
+	}
+	deployments, err := appDeployments()
+	if err != nil {
+		return 0, err
+	}
+	uris := make(map[string]string)
+	for _, d := range deployments {
+		uris[d.ID] = d.URI
+	}
+	answer, err := adminRequest("GET", "/services", nil)
+	if err != nil {
+		return 0, err
+	}
+	var list struct {
+		Services []registeredService `json:"services"`
+	}
+	if err := json.Unmarshal(answer, &list); err != nil {
+		return 0, fmt.Errorf("failed to parse the services of %s: %v", adminURL(), err)
+	}
+	registered := make(map[string]registeredService)
+	for _, s := range list.Services {
+		if _, ok := uris[s.DeploymentID]; ok {
+			registered[s.Name] = s
+		}
+	}
+	// localURIs are the deployment URLs of the local services.
+	localURIs := make(map[string]bool)
+	for _, c := range local {
+		localURIs[deploymentURL(c.Service)] = true
+	}
+	var drifts []string
+	for name, c := range local {
+		uri := deploymentURL(c.Service)
+		s, ok := registered[name]
+		if !ok {
+			drifts = append(drifts, fmt.Sprintf("%s is defined locally but not registered; register it with restate deployments register --use-http1.1 %s, or run encore-restate-gen with -register", name, uri))
+			continue
+		}
+		have := make(map[string]bool)
+		for _, h := range s.Handlers {
+			have[h.Name] = true
+		}
+		want := make(map[string]bool)
+		var added, removed []string
+		for _, h := range c.Handlers {
+			want[h] = true
+			if !have[h] {
+				added = append(added, h)
+			}
+		}
+		for _, h := range s.Handlers {
+			if !want[h.Name] {
+				removed = append(removed, h.Name)
+			}
+		}
+		if len(added) > 0 {
+			drifts = append(drifts, fmt.Sprintf("%s has handlers %v locally that are not registered; register %s again with restate deployments register --use-http1.1 --force %s", name, added, c.Service, uri))
+		}
+		if len(removed) > 0 {
+			drifts = append(drifts, fmt.Sprintf("%s has registered handlers %v that no longer exist locally; register %s again with restate deployments register --use-http1.1 --force %s", name, removed, c.Service, uri))
+		}
+	}
+	for name, s := range registered {
+		if _, ok := local[name]; ok {
+			continue
+		}
+		uri := uris[s.DeploymentID]
+		if localURIs[uri] {
+			drifts = append(drifts, fmt.Sprintf("%s is registered at %s but no longer defined locally; register the deployment again with restate deployments register --use-http1.1 --force %s", name, uri, uri))
+		} else {
+			drifts = append(drifts, fmt.Sprintf("%s is registered at %s but its service no longer exists; remove it with encore-restate-gen deployments prune", name, uri))
+		}
+	}
+	sort.Strings(drifts)
+	for _, d := range drifts {
+		log.Printf("Drift: %s", d)
+	}
+	if len(drifts) == 0 {
+		log.Printf("The services registered at %s match the local services", deploymentURL(""))
+	}
+	return len(drifts), nil
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+const (
+	// restateImage is the image the dev command runs the Restate server from with Docker.
+	restateImage = "docker.restate.dev/restatedev/restate:latest"
+	// restateReleaseURL is where the dev command downloads the Restate server binary from.
+	restateReleaseURL = "https://github.com/restatedev/restate/releases/latest/download/"
+	// restateStartTimeout bounds the wait for a started Restate server to become healthy.
+	restateStartTimeout = time.Minute
+)
+
+// restateRuntime is the value of -restate-runtime: how the dev command runs the Restate server.
+var restateRuntime = "auto"
+
+// devProcesses are the processes started by the dev command, stopped by stopDevProcesses.
+var devProcesses []*exec.Cmd
+
+// devContainer is the name of the Docker container started by the dev command, or "".
+var devContainer string
+
+// startDev prepares the dev command: it runs a local Restate server and prepares supervising the
+// Encore app unless they already answer, and enables registering the generated endpoints with the
+// server.
+func startDev(root string) error {
+	if projectConfig.Cloud != nil {
+		log.Printf("dev uses a local Restate server, not the Restate Cloud environment %s", projectConfig.Cloud.Environment)
+		projectConfig.Cloud = nil
+	}
+	registerDeployments = true
+	if restateUnreachable(probeRestate()) == "" {
+		log.Printf("Using the Restate server already running at %s", adminURL())
+	} else if err := startRestate(root); err != nil {
+		return err
+	}
+	if resp, err := http.Get(encoreURL()); err == nil {
+		resp.Body.Close()
+		log.Printf("Using the Encore app already running at %s", encoreURL())
+	} else if _, err := exec.LookPath("encore"); err == nil {
+		// Started once the code is generated, so the first build finds it.
+		encoreApp = newEncoreSupervisor(root)
+	} else {
+		log.Printf("The Encore CLI is not installed; start the Encore app with encore run for the endpoints to be registered")
+	}
+	return nil
+}
+
+// startRestate starts a Restate server with the runtime of -restate-runtime and waits until it is
+// healthy. With "auto", an installed restate-server binary is preferred over Docker, and Docker over
+// downloading the binary.
+func startRestate(root string) error {
+	runtimeName := restateRuntime
+	if runtimeName == "auto" {
+		runtimeName = "binary"
+		if _, err := exec.LookPath("restate-server"); err != nil {
+			if _, err := exec.LookPath("docker"); err == nil {
+				runtimeName = "docker"
+			}
+		}
+	}
+	sum := sha256.Sum256([]byte(absRealDir(root)))
+	id := hex.EncodeToString(sum[:])[:12]
+	switch runtimeName {
+	case "docker":
+		devContainer = "encore-restate-gen-" + id
+		// The container reaches the Encore app on the host through host.docker.internal.
+		if r := projectConfig.Register; r == nil || r.DeploymentURL == "" {
+			u, err := url.Parse(encoreURL())
+			if err != nil {
+				return err
+			}
+			u.Host = "host.docker.internal"
+			if port := u.Port(); port != "" {
+				u.Host += ":" + port
+			}
+			if projectConfig.Register == nil {
+				projectConfig.Register = &RegisterConfig{}
+			}
+			projectConfig.Register.DeploymentURL = u.String()
+		}
+		ingress, admin, err := restateHostPorts()
+		if err != nil {
+			return err
+		}
+		startDevProcess(exec.Command("docker", "run", "--rm", "--name", devContainer,
+			"-p", ingress+":"+restateIngressPort, "-p", admin+":"+restateAdminPort,
+			"--add-host=host.docker.internal:host-gateway", restateImage), "restate: ")
+	case "binary":
+		server, err := restateServerBinary()
+		if err != nil {
+			return err
+		}
+		base, err := cacheDir()
+		if err != nil {
+			return err
+		}
+		// Each project keeps its own registrations and invocations.
+		dataDir := filepath.Join(base, "restate", id)
+		if err := os.MkdirAll(dataDir, 0755); err != nil {
+			return err
+		}
+		cmd := exec.Command(server)
+		cmd.Dir = dataDir
+		startDevProcess(cmd, "restate: ")
+	default:
+		return fmt.Errorf("-restate-runtime must be auto, docker or binary, not %q", restateRuntime)
+	}
+	deadline := time.Now().Add(restateStartTimeout)
+	for {
+		problem := restateUnreachable(probeRestate())
+		if problem == "" {
+			log.Printf("Restate server is running at %s", ingressURL())
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("the Restate server did not become healthy within %v: %s", restateStartTimeout, problem)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// restateServerBinary returns the restate-server binary on PATH, or else one downloaded to the cache.
+func restateServerBinary() (string, error) {
+	if path, err := exec.LookPath("restate-server"); err == nil {
+		return path, nil
+	}
+	targets := map[string]string{
+		"linux/amd64":  "x86_64-unknown-linux-musl",
+		"linux/arm64":  "aarch64-unknown-linux-musl",
+		"darwin/amd64": "x86_64-apple-darwin",
+		"darwin/arm64": "aarch64-apple-darwin",
+	}
+	target, ok := targets[runtime.GOOS+"/"+runtime.GOARCH]
+	if !ok {
+		return "", fmt.Errorf("no Restate server binary is released for %s/%s; use -restate-runtime=docker", runtime.GOOS, runtime.GOARCH)
+	}
+	base, err := cacheDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(base, "restate-server", target)
+	if path := findFile(dir, "restate-server"); path != "" {
+		return path, nil
+	}
+	if offline {
+		return "", fmt.Errorf("offline: the Restate server binary is not downloaded; install restate-server or use -restate-runtime=docker")
+	}
+	archive := "restate-server-" + target + ".tar.xz"
+	log.Printf("Downloading %s%s", restateReleaseURL, archive)
+	resp, err := http.Get(restateReleaseURL + archive)
+	if err != nil {
+		return "", fmt.Errorf("failed to download the Restate server: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download the Restate server: %s answered %s", restateReleaseURL+archive, resp.Status)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	tmp, err := ioutil.TempFile(dir, "download-*.tar.xz")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, resp.Body)
+	tmp.Close()
+	if err != nil {
+		return "", fmt.Errorf("failed to download the Restate server: %v", err)
+	}
+	if output, err := exec.Command("tar", "-xJf", tmp.Name(), "-C", dir).CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to unpack %s: %v: %s", archive, err, output)
+	}
+	path := findFile(dir, "restate-server")
+	if path == "" {
+		return "", fmt.Errorf("%s does not contain restate-server", archive)
+	}
+	return path, nil
+}
+
+// findFile returns the path of the first file called name below dir, or "".
+func findFile(dir, name string) string {
+	var found string
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && found == "" && !info.IsDir() && info.Name() == name {
+			found = path
+		}
+		return nil
+	})
+	return found
+}
+
+// startDevProcess starts cmd in its own process group, logging its output with prefix. A process
+// that exits is reported but not restarted.
+func startDevProcess(cmd *exec.Cmd, prefix string) {
+	output := &lineLogger{prefix: prefix}
+	cmd.Stdout, cmd.Stderr = output, output
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		log.Printf("Error starting %s: %v", cmd.Path, err)
+		return
+	}
+	devProcesses = append(devProcesses, cmd)
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			log.Printf("%s exited: %v", filepath.Base(cmd.Path), err)
+		} else {
+			log.Printf("%s exited", filepath.Base(cmd.Path))
+		}
+	}()
+}
+
+// stopDevProcesses stops the Restate server and Encore app started by the dev command.
+func stopDevProcesses() {
+	if encoreApp != nil {
+		encoreApp.stop()
+	}
+	if devContainer != "" {
+		exec.Command("docker", "stop", devContainer).Run()
+	}
+	for _, cmd := range devProcesses {
+		killProcessGroup(cmd)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// Diagnostic is a problem reported by the extractor for a source location.
+type Diagnostic struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`   // 1-based, 0 if unknown
+	Column   int    `json:"column"` // 1-based, 0 if unknown
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// String formats d compiler-style, e.g. "email/email.ts:8:24: error: Expression expected.", with
+// the file relative to the project root.
+func (d Diagnostic) String() string {
+	file := d.File
+	if rel, err := filepath.Rel(projectRoot, file); err == nil && !strings.HasPrefix(rel, "..") {
+		file = rel
+	}
+	loc := file
+	if d.Line > 0 {
+		loc = fmt.Sprintf("%s:%d", loc, d.Line)
+		if d.Column > 0 {
+			loc = fmt.Sprintf("%s:%d", loc, d.Column)
+		}
+	}
+	return fmt.Sprintf("%s: %s: %s", loc, d.Severity, d.Message)
+}
+
+// printDiagnostics logs each diagnostic on its own line.
+func printDiagnostics(diags []Diagnostic) {
+	for _, d := range diags {
+		log.Print(d)
+	}
+}
+
+// countErrors returns the number of error diagnostics.
+func countErrors(diags []Diagnostic) int {
+	n := 0
+	for _, d := range diags {
+		if d.Severity == "error" {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Service discovery modes selected by the "serviceDiscovery" config key.
+const (
+	discoveryFiles  = "files"
+	discoveryEncore = "encore"
+)
+
+// encoreAppFile is the file marking the root of an Encore app.
+const encoreAppFile = "encore.app"
+
+// EncoreApp is the content of encore.app.
+type EncoreApp struct {
+	ID   string
+	Lang string
+}
+
+// readEncoreApp reads the encore.app of root, which like tsconfig.json allows comments and trailing
+// commas.
+func readEncoreApp(root string) (EncoreApp, error) {
+	var app EncoreApp
+	data, err := ioutil.ReadFile(filepath.Join(root, encoreAppFile))
+	if err != nil {
+		return app, err
+	}
+	v, err := parseJSONC(string(data))
+	if err != nil {
+		return app, fmt.Errorf("failed to parse %s: %v", encoreAppFile, err)
+	}
+	if id := v.member("id"); id != nil {
+		app.ID = id.str
+	}
+	if lang := v.member("lang"); lang != nil {
+		app.Lang = lang.str
+	}
+	return app, nil
+}
+
+// encoreDiscovery reports whether services are discovered as Encore does rather than by the
+// configured service markers.
+func encoreDiscovery() bool {
+	return projectConfig.ServiceDiscovery == discoveryEncore
+}
+
+// encoreServiceDeclaration returns the name of the service dir declares like Encore requires it,
+// with new Service("name") in its encore.service.ts, and the line of the declaration, or "" if it
+// declares none.
+func encoreServiceDeclaration(dir string) (name string, line int) {
+	src, err := ioutil.ReadFile(filepath.Join(dir, defaultServiceMarker))
+	if err != nil {
+		return "", 0
+	}
+	toks := tokenize(string(src))
+	for i := 0; i+3 < len(toks); i++ {
+		if toks[i].text == "new" && toks[i+1].text == "Service" && toks[i+2].text == "(" && toks[i+3].kind == tokString {
+			s := toks[i+3].text
+			return s[1 : len(s)-1], toks[i].line
+		}
+	}
+	return "", 0
+}
+
+// encoreServiceName returns the name of the service dir declares like Encore requires it, or "".
+func encoreServiceName(dir string) string {
+	name, _ := encoreServiceDeclaration(dir)
+	return name
+}
+
+// checkServiceName adds an error to the diagnostics of manifest if the service name it was
+// extracted with, e.g. by a custom script or from another marker file, is not the name Encore gives
+// the service in dir. The generated invoke paths and registrations would use a service Encore does
+// not know.
+func checkServiceName(dir string, manifest *Manifest) {
+	name, line := encoreServiceDeclaration(dir)
+	if name == "" || manifest.ServiceName == "" || manifest.ServiceName == name {
+		return
+	}
+	manifest.Diagnostics = append(manifest.Diagnostics, Diagnostic{
+		File:     filepath.Join(dir, defaultServiceMarker),
+		Line:     line,
+		Severity: "error",
+		Message: fmt.Sprintf("the service was extracted as %q, but Encore names it %q here; generated names and invoke paths must use the Encore name, so fix the extraction or the declaration",
+			manifest.ServiceName, name),
+	})
+}
+
+var (
+	// undeclaredServices are the directories with an encore.service.ts declaring no service that
+	// were reported, so they are reported once.
+	undeclaredServices      = make(map[string]bool)
+	undeclaredServicesMutex sync.Mutex
+)
+
+// encoreServiceMarker returns the service marker of dir in encore discovery: encore.service.ts if it
+// declares a service, "" otherwise.
+func encoreServiceMarker(dir string) string {
+	if encoreServiceName(dir) != "" {
+		undeclaredServicesMutex.Lock()
+		delete(undeclaredServices, dir)
+		undeclaredServicesMutex.Unlock()
+		return defaultServiceMarker
+	}
+	if _, err := os.Stat(filepath.Join(dir, defaultServiceMarker)); err != nil {
+		return ""
+	}
+	undeclaredServicesMutex.Lock()
+	defer undeclaredServicesMutex.Unlock()
+	if !undeclaredServices[dir] {
+		undeclaredServices[dir] = true
+		log.Printf("Skipping %s: its %s declares no new Service(\"name\"), so it is not an Encore service", dir, defaultServiceMarker)
+	}
+	return ""
+}
+
+// encoreServiceDirs returns the directories of the services of the Encore app at root. A service
+// declared in several directories, which Encore rejects, is only generated for the first one.
+func encoreServiceDirs(root string) []string {
+	var dirs []string
+	declared := make(map[string]string)
+	walkDirs(root, func(dir string) error {
+		if encoreServiceMarker(dir) == "" {
+			return nil
+		}
+		name := encoreServiceName(dir)
+		if first, ok := declared[name]; ok {
+			log.Printf("Skipping %s: the Encore service %s is already declared in %s", dir, name, first)
+			return nil
+		}
+		declared[name] = dir
+		dirs = append(dirs, dir)
+		return nil
+	})
+	return dirs
+}
+
+// declaredElsewhere returns the other service directory generated for the Encore service name, or
+// "" if there is none.
+func declaredElsewhere(dir, name string) string {
+	generatedDataMapMutex.Lock()
+	defer generatedDataMapMutex.Unlock()
+	for other, data := range generatedDataMap {
+		if other != dir && data.ServiceName == name {
+			return other
+		}
+	}
+	return ""
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// serviceDump describes one service in the output of -print-manifests.
+type serviceDump struct {
+	// Dir is the service directory relative to the project root.
+	Dir         string         `json:"dir"`
+	ServiceName string         `json:"serviceName,omitempty"`
+	Handlers    []handlerDump  `json:"handlers"`
+	Endpoints   []endpointDump `json:"endpoints"`
+	Diagnostics []Diagnostic   `json:"diagnostics,omitempty"`
+	// Error is set if the service could not be extracted.
+	Error string `json:"error,omitempty"`
+}
+
+// handlerDump is a Restate handler and the Encore endpoint generated for it.
+type handlerDump struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Component is the Restate service, workflow or object the handler belongs to.
+	Component    string          `json:"component"`
+	Source       string          `json:"source,omitempty"`
+	KeyType      string          `json:"keyType,omitempty"`
+	Doc          string          `json:"doc,omitempty"`
+	InputSchema  json.RawMessage `json:"inputSchema,omitempty"`
+	OutputSchema json.RawMessage `json:"outputSchema,omitempty"`
+	// Path is the path of the generated Encore invoke endpoint.
+	Path string `json:"path"`
+	// IngressPath is the path of the handler on the Restate ingress.
+	IngressPath string `json:"ingressPath"`
+}
+
+// endpointDump is a generated Encore endpoint not tied to a single handler.
+type endpointDump struct {
+	Name   string `json:"name"`
+	Method string `json:"method"`
+	Path   string `json:"path"`
+}
+
+// printManifests extracts every service below root and prints their handlers and generated
+// endpoints to stdout as one JSON document. No files are written. It fails if any service could
+// not be extracted, after printing the document.
+func printManifests(root string) error {
+	dirs := serviceDirs(root)
+	results := extractAll(dirs)
+	services := []serviceDump{}
+	failed := 0
+	for i, dir := range dirs {
+		rel, err := filepath.Rel(root, dir)
+		if err != nil {
+			rel = dir
+		}
+		dump := serviceDump{Dir: filepath.ToSlash(rel), Handlers: []handlerDump{}, Endpoints: []endpointDump{}}
+		if results[i].err != nil {
+			dump.Error = results[i].err.Error()
+			failed++
+			services = append(services, dump)
+			continue
+		}
+		manifest := results[i].manifest
+		dump.ServiceName = manifest.ServiceName
+		dump.Diagnostics = manifest.Diagnostics
+		if manifest.ServiceName != "" {
+			if data, ok := templateData(dir, manifest); ok {
+				dump.Handlers = handlerDumps(data)
+				dump.Endpoints = []endpointDump{
+					{Name: "discover", Method: "GET", Path: "/" + data.ServiceName + "/discover"},
+					{Name: "restateHealth", Method: "GET", Path: "/" + data.ServiceName + "/restate/health"},
+				}
+			}
+		}
+		services = append(services, dump)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Dir < services[j].Dir })
+	out, err := json.MarshalIndent(map[string]interface{}{
+		"schemaVersion": manifestSchemaVersion,
+		"services":      services,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	os.Stdout.Write(append(out, '\n'))
+	if failed > 0 {
+		return fmt.Errorf("failed to extract %d of %d services", failed, len(dirs))
+	}
+	return nil
+}
+
+// handlerDumps lists the handlers of data, including those of explicit definitions, ordered by
+// type and source file.
+func handlerDumps(data TemplateData) []handlerDump {
+	var handlers []handlerDump
+	newDump := func(name, handlerType, component string) handlerDump {
+		ingressPath := "/" + component + "/" + name
+		if restateComponents[handlerType].Keyed {
+			ingressPath = "/" + component + "/{key}/" + name
+		}
+		return handlerDump{
+			Name:        name,
+			Type:        handlerType,
+			Component:   component,
+			Path:        "/" + data.ServiceName + "/invoke/" + component + "/" + name,
+			IngressPath: ingressPath,
+		}
+	}
+	groups := map[string][]GroupedHandler{
+		"service":       data.ServiceGroup,
+		"workflow":      data.WorkflowGroup,
+		"virtualObject": data.VirtualObjectGroup,
+	}
+	for _, handlerType := range []string{"service", "workflow", "virtualObject"} {
+		component := data.ServiceNameTrimmed + restateComponents[handlerType].Suffix
+		sorted := append([]GroupedHandler{}, groups[handlerType]...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i].Source < sorted[j].Source })
+		for _, g := range sorted {
+			for _, h := range g.Handlers {
+				d := newDump(h.ExportName, handlerType, component)
+				d.Source = h.Source
+				d.KeyType = h.KeyType
+				d.Doc = h.Doc
+				d.InputSchema = h.InputSchema
+				d.OutputSchema = h.OutputSchema
+				handlers = append(handlers, d)
+			}
+		}
+	}
+	for _, def := range data.Definitions {
+		for _, name := range def.Handlers {
+			d := newDump(name, def.Type, def.Name)
+			d.Source = def.Source
+			handlers = append(handlers, d)
+		}
+	}
+	if handlers == nil {
+		handlers = []handlerDump{}
+	}
+	return handlers
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"log"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const (
+	// encoreRestartDelay is the first wait before restarting an encore run that exited; it doubles
+	// up to encoreMaxRestartDelay while the app keeps exiting.
+	encoreRestartDelay    = time.Second
+	encoreMaxRestartDelay = 30 * time.Second
+	// encoreStableRun is how long encore run must keep running for its restart delay to reset.
+	encoreStableRun = time.Minute
+)
+
+// encoreSupervisor runs encore run for the dev command: it restarts it when it exits, or when the
+// generator changes what Encore only reads on startup, and stops it when the generator exits.
+type encoreSupervisor struct {
+	root string
+
+	mu        sync.Mutex
+	cmd       *exec.Cmd
+	run       int // incremented for every start, so an exit can be told from a replaced process
+	stopped   bool
+	delay     time.Duration
+	startedAt time.Time
+}
+
+// encoreApp is the encore run supervised by the dev command, or nil.
+var encoreApp *encoreSupervisor
+
+// newEncoreSupervisor returns the supervisor of encore run in root, which launch starts.
+func newEncoreSupervisor(root string) *encoreSupervisor {
+	return &encoreSupervisor{root: root, delay: encoreRestartDelay}
+}
+
+// launch starts encore run, once the code it imports is generated.
+func (s *encoreSupervisor) launch() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.stopped {
+		s.start()
+	}
+}
+
+// start starts encore run; s.mu must be held.
+func (s *encoreSupervisor) start() {
+	cmd := exec.Command("encore", "run")
+	cmd.Dir = s.root
+	output := &lineLogger{prefix: "encore: "}
+	cmd.Stdout, cmd.Stderr = output, output
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		log.Printf("Error starting encore run: %v", err)
+		return
+	}
+	s.run++
+	s.cmd = cmd
+	s.startedAt = time.Now()
+	go s.wait(cmd, s.run)
+}
+
+// wait waits for the encore run of run to exit, and restarts it unless it was replaced or stopped.
+func (s *encoreSupervisor) wait(cmd *exec.Cmd, run int) {
+	err := cmd.Wait()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped || run != s.run {
+		return
+	}
+	if time.Since(s.startedAt) > encoreStableRun {
+		s.delay = encoreRestartDelay
+	}
+	if err == nil {
+		log.Printf("encore run exited; restarting it in %v", s.delay)
+	} else {
+		log.Printf("encore run exited: %v; restarting it in %v", err, s.delay)
+	}
+	delay := s.delay
+	if s.delay *= 2; s.delay > encoreMaxRestartDelay {
+		s.delay = encoreMaxRestartDelay
+	}
+	time.AfterFunc(delay, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if !s.stopped && run == s.run {
+			s.start()
+		}
+	})
+}
+
+// restart restarts encore run, e.g. after the generator installed packages, which its own watcher
+// does not pick up.
+func (s *encoreSupervisor) restart(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return
+	}
+	log.Printf("Restarting encore run: %s", reason)
+	if s.cmd != nil {
+		killProcessGroup(s.cmd)
+	}
+	s.start()
+}
+
+// stop stops encore run for good.
+func (s *encoreSupervisor) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped = true
+	if s.cmd != nil {
+		killProcessGroup(s.cmd)
+	}
+}
+
+// launched reports whether launch started encore run.
+func (s *encoreSupervisor) launched() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.run > 0
+}
+
+// restartEncore restarts the encore run supervised by the dev command, if any, once the generator
+// stops changing its configuration. Before the launch, encore run starts with the change anyway.
+func restartEncore(reason string) {
+	if encoreApp == nil || !encoreApp.launched() {
+		return
+	}
+	debounce("encore restart", time.Second, func() {
+		encoreApp.restart(reason)
+	})
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// generateMain implements the generate command: a single generation pass without watching, meant to
+// run from build hooks and scripts before encore run or encore build. It finds the app from any
+// working directory, logs only on failure unless -v is given, never installs packages unless
+// -install is given, and exits with 1 if any service or file failed to generate or the Restate
+// packages are missing.
+func generateMain(args []string) {
+	fs := flag.NewFlagSet("generate", flag.ExitOnError)
+	verbose := fs.Bool("v", false, "log progress, not only failures")
+	install := fs.Bool("install", false, "install missing Restate packages instead of failing")
+	fs.BoolVar(&noTsconfig, "no-tsconfig", false, "never rewrite tsconfig.json; fail unless it maps the ~restate aliases")
+	fs.IntVar(&scanConcurrency, "concurrency", scanConcurrency, "number of service directories to extract in parallel")
+	fs.Parse(args)
+	if scanConcurrency < 1 {
+		fmt.Fprintln(os.Stderr, "-concurrency must be at least 1")
+		os.Exit(2)
+	}
+
+	// Quiet by default: the log is only shown if the generation fails.
+	var logs bytes.Buffer
+	if !*verbose {
+		log.SetOutput(&logs)
+	}
+	fail := func(format string, v ...interface{}) {
+		log.Printf(format, v...)
+		if !*verbose {
+			os.Stderr.Write(logs.Bytes())
+		}
+		nodeWorkers.stop()
+		os.Exit(1)
+	}
+	nodeWorkers = newWorkerPool(scanConcurrency)
+
+	root, err := appRoot(fs.Arg(0))
+	if err != nil {
+		fail("%v", err)
+	}
+	projectRoot = root
+	cfg, err := loadConfig(root)
+	if err != nil {
+		fail("Failed to load %s: %v", configFileName, err)
+	}
+	projectConfig = cfg
+	noTsconfig = noTsconfig || cfg.NoTsconfig
+	offline = !*install || cfg.Offline
+	projectIgnore = newGitignore(root, cfg.Ignore)
+	if apps := encoreAppDirs(root); len(apps) > 1 {
+		nodeWorkers.stop()
+		if err := multiAppMain(root, apps, append([]string{"generate"}, args[:len(args)-fs.NArg()]...)); err != nil {
+			fail("%v", err)
+		}
+		return
+	}
+	if app, err := readEncoreApp(root); err == nil && app.Lang == encoreLangGo {
+		nodeWorkers.stop()
+		if err := checkGoAppConfig(cfg); err != nil {
+			fail("%v", err)
+		}
+		refreshIdentityKeys(root)
+		if failed := generateGoApp(root); failed > 0 {
+			fail("Generation failed for %d Go service packages", failed)
+		}
+		return
+	}
+
+	var identity sync.WaitGroup
+	identity.Add(1)
+	go func() {
+		defer identity.Done()
+		defer startup.phase("identity keys")()
+		refreshIdentityKeys(root)
+	}()
+	globalPackageManager = detectPackageManager(root)
+	stop := startup.phase("dependencies")
+	installed, err := checkRestateModules(root)
+	if err != nil {
+		fail("Error checking the Restate modules: %v", err)
+	}
+	restatedModulesInstalled = installed
+	if err := checkSdkCompatibility(root); err != nil {
+		log.Printf("Error checking the Restate SDK version: %v", err)
+	}
+	stop()
+	if noTsconfig {
+		if err := checkTsConfig(root); err != nil {
+			fail("%v", err)
+		}
+	}
+	identity.Wait()
+
+	initialScan(root)
+	stop = startup.phase("cleanup")
+	cleanDanglingGeneratedFiles(root, ".restate"+outputExt())
+	stop()
+	failures := 0
+	stop = startup.phase("index")
+	if err := generateCentralIndex(root); err != nil {
+		log.Printf("Error generating central index: %v", err)
+		failures++
+	}
+	stop()
+	if !noTsconfig {
+		if err := updateTsConfig(root); err != nil {
+			log.Printf("Error updating tsconfig.json: %v", err)
+			failures++
+		}
+		if err := updateTestRunnerConfig(root); err != nil {
+			log.Printf("Error updating the test runner configuration: %v", err)
+		}
+	}
+	if projectConfig.Aliases == aliasesImports {
+		if err := updatePackageImports(root); err != nil {
+			log.Printf("Error updating package.json: %v", err)
+			failures++
+		}
+	}
+	nodeWorkers.stop()
+	waitHooks()
+
+	// A service failing to extract can be among the generation failures too, so it is counted once.
+	failed := make(map[string]bool)
+	erroredDirsMutex.Lock()
+	for dir := range erroredDirs {
+		failed[dir] = true
+	}
+	erroredDirsMutex.Unlock()
+	generationFailuresMutex.Lock()
+	for path := range generationFailures {
+		failed[path] = true
+	}
+	generationFailuresMutex.Unlock()
+	failures += len(failed)
+	startup.report()
+	if installed, err := checkRestateModules(root); err == nil && !installed {
+		log.Printf("The Restate packages are not installed; install them, or run generate with -install")
+		failures++
+	}
+	if failures > 0 {
+		fail("Generation failed with %d error(s)", failures)
+	}
+	log.Printf("Generated the Restate code of %s", root)
+}
+
+// appRoot returns the absolute path of dir, or if it is empty of the Encore app containing the
+// working directory, so a hook finds the app wherever it runs. Outside an app, the working directory
+// is used.
+func appRoot(dir string) (string, error) {
+	if dir != "" {
+		return filepath.Abs(dir)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("failed to get current directory: %v", err)
+	}
+	for d := wd; ; d = filepath.Dir(d) {
+		if isEncoreApp(d) {
+			return d, nil
+		}
+		if filepath.Dir(d) == d {
+			return wd, nil
+		}
+	}
+}
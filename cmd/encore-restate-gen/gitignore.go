@@ -0,0 +1,235 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ignoreRule is one pattern of a .gitignore file.
+type ignoreRule struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// gitignore matches paths below root against the ignore engine's rules: defaultIgnore, the
+// configured patterns, and the .gitignore files of root and its subdirectories, in that order.
+// Files are read on first use and cached until forget is called for their directory.
+type gitignore struct {
+	root  string
+	app   bool         // root is an Encore app, so the apps nested in it are other projects
+	base  []ignoreRule // defaultIgnore and configured patterns, relative to root
+	mu    sync.Mutex
+	rules map[string][]ignoreRule // keyed by directory relative to root, "." for root
+}
+
+// defaultIgnore lists what is never scanned, watched or extracted: dependencies, build output,
+// generated code, the project cache and the temporary files of editors. A .gitignore or
+// configured pattern can re-include them with "!".
+const defaultIgnore = `
+node_modules/
+dist/
+.build/
+*.gen/
+.git/
+.cache/encore-restate-gen/
+
+# Vim swap, backup and write test files
+*.sw[a-p]
+*~
+4913
+# Emacs lock and auto-save files
+.#*
+\#*#
+# JetBrains safe writes
+*___jb_tmp___
+*___jb_old___
+# Atomic writes of other editors, e.g. user.ts.tmp or user.ts.tmp.1234
+*.tmp
+*.tmp.[0-9]*
+`
+
+// projectIgnore is the ignore engine of the project, used for scanning and watching; set in main.
+var projectIgnore = &gitignore{}
+
+// newGitignore creates the ignore engine of root with additional gitignore-style patterns.
+func newGitignore(root string, patterns []string) *gitignore {
+	base := parseGitignore(defaultIgnore + strings.Join(patterns, "\n"))
+	return &gitignore{root: root, app: isEncoreApp(root), base: base, rules: make(map[string][]ignoreRule)}
+}
+
+// ignored reports whether path, or one of its parent directories, is ignored.
+func (g *gitignore) ignored(path string, isDir bool) bool {
+	if g.root == "" {
+		return false
+	}
+	rel, err := filepath.Rel(g.root, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	if isDir && g.app && isEncoreApp(path) {
+		return true
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for i := 1; i < len(parts); i++ {
+		if g.match(parts[:i], true) {
+			return true
+		}
+	}
+	return g.match(parts, isDir)
+}
+
+// match applies the rules of the directories containing the path given by parts, outermost first;
+// the last matching rule decides.
+func (g *gitignore) match(parts []string, isDir bool) bool {
+	ignored := false
+	for i := 0; i < len(parts); i++ {
+		dir := "."
+		if i > 0 {
+			dir = strings.Join(parts[:i], "/")
+		}
+		rel := strings.Join(parts[i:], "/")
+		rules := g.rulesOf(dir)
+		if i == 0 {
+			rules = append(append([]ignoreRule{}, g.base...), rules...)
+		}
+		for _, rule := range rules {
+			if rule.dirOnly && !isDir {
+				continue
+			}
+			if rule.re.MatchString(rel) {
+				ignored = !rule.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// rulesOf returns the rules of the .gitignore file in dir, relative to root.
+func (g *gitignore) rulesOf(dir string) []ignoreRule {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if rules, ok := g.rules[dir]; ok {
+		return rules
+	}
+	var rules []ignoreRule
+	if data, err := ioutil.ReadFile(filepath.Join(g.root, filepath.FromSlash(dir), ".gitignore")); err == nil {
+		rules = parseGitignore(string(data))
+	}
+	g.rules[dir] = rules
+	return rules
+}
+
+// forget drops the cached rules of dir, e.g. after its .gitignore changed.
+func (g *gitignore) forget(dir string) {
+	rel, err := filepath.Rel(g.root, dir)
+	if err != nil {
+		return
+	}
+	g.mu.Lock()
+	delete(g.rules, filepath.ToSlash(rel))
+	g.mu.Unlock()
+}
+
+// ignoredFiles returns the names of the ignored files in dir, e.g. editor temporary files.
+func ignoredFiles(dir string) []string {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && projectIgnore.ignored(filepath.Join(dir, entry.Name()), false) {
+			names = append(names, entry.Name())
+		}
+	}
+	return names
+}
+
+// forgetAll drops the cached rules of all directories.
+func (g *gitignore) forgetAll() {
+	g.mu.Lock()
+	g.rules = make(map[string][]ignoreRule)
+	g.mu.Unlock()
+}
+
+// parseGitignore parses the content of a .gitignore file. Invalid patterns are skipped.
+func parseGitignore(content string) []ignoreRule {
+	var rules []ignoreRule
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var rule ignoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\#`) || strings.HasPrefix(line, `\!`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		// Patterns with a slash are relative to the .gitignore's directory; others match at any depth.
+		anchored := strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		prefix := "^(?:.*/)?"
+		if anchored {
+			prefix = "^"
+		}
+		re, err := regexp.Compile(prefix + globToRegexp(line) + "$")
+		if err != nil {
+			continue
+		}
+		rule.re = re
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// globToRegexp translates a gitignore glob to a regular expression: "*" and "?" do not match "/",
+// "**" matches across directories.
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
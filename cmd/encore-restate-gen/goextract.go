@@ -0,0 +1,787 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// The native Go extraction backend, producing manifests without Node installed. Files are parsed
+// with esbuild, whose syntax errors are reported as diagnostics and whose list of the names a file
+// exports is authoritative. esbuild's Go API does not expose its syntax tree, so the declarations
+// behind these names are read from a token stream. An export whose declaration cannot be analyzed,
+// such as a handler class or a re-export from another module, is reported as an error instead of
+// being left out. Compared to the Node backend it only recognizes context types written as type
+// annotations, and does not extract key types or request/response schemas.
+
+type tokenKind int
+
+const (
+	tokIdent tokenKind = iota
+	tokPunct
+	tokString
+	tokTemplate
+	tokNumber
+	tokRegexp
+	tokDoc // a /** ... */ comment
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	line int
+}
+
+// tokenize splits TypeScript source into tokens. Whitespace and ordinary comments are dropped;
+// JSDoc comments are kept so they can be attached to the following declaration.
+func tokenize(src string) []token {
+	var toks []token
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				end = len(src) - i - 2
+			}
+			text := src[i : i+2+end]
+			if strings.HasPrefix(text, "/**") {
+				toks = append(toks, token{tokDoc, text, line})
+			}
+			line += strings.Count(text, "\n")
+			i += 2 + end + 2
+		case c == '"' || c == '\'':
+			start, startLine := i, line
+			i = scanString(src, i, &line)
+			toks = append(toks, token{tokString, src[start:i], startLine})
+		case c == '`':
+			start, startLine := i, line
+			i = scanTemplate(src, i, &line)
+			toks = append(toks, token{tokTemplate, src[start:i], startLine})
+		case c == '/' && regexpAllowed(toks):
+			start := i
+			i = scanRegexp(src, i)
+			toks = append(toks, token{tokRegexp, src[start:i], line})
+		case isIdentStart(c):
+			start := i
+			for i < len(src) && isIdentPart(src[i]) {
+				i++
+			}
+			toks = append(toks, token{tokIdent, src[start:i], line})
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(src) && (isIdentPart(src[i]) || src[i] == '.') {
+				i++
+			}
+			toks = append(toks, token{tokNumber, src[start:i], line})
+		case strings.HasPrefix(src[i:], "=>"):
+			toks = append(toks, token{tokPunct, "=>", line})
+			i += 2
+		default:
+			toks = append(toks, token{tokPunct, string(c), line})
+			i++
+		}
+	}
+	return toks
+}
+
+// scanString returns the end of the string literal starting at src[i], counting its lines.
+func scanString(src string, i int, line *int) int {
+	quote := src[i]
+	for i++; i < len(src) && src[i] != quote; i++ {
+		if src[i] == '\\' {
+			i++
+		} else if src[i] == '\n' {
+			*line++
+		}
+	}
+	if i < len(src) {
+		i++
+	}
+	return i
+}
+
+// scanTemplate returns the end of the template literal starting at src[i], including the strings,
+// templates and braces nested in its substitutions, counting its lines.
+func scanTemplate(src string, i int, line *int) int {
+	for i++; i < len(src) && src[i] != '`'; i++ {
+		switch {
+		case src[i] == '\\':
+			i++
+		case src[i] == '\n':
+			*line++
+		case strings.HasPrefix(src[i:], "${"):
+			depth := 0
+			for i += 2; i < len(src) && (src[i] != '}' || depth > 0); i++ {
+				switch src[i] {
+				case '{':
+					depth++
+				case '}':
+					depth--
+				case '\n':
+					*line++
+				case '"', '\'':
+					i = scanString(src, i, line) - 1
+				case '`':
+					i = scanTemplate(src, i, line) - 1
+				}
+			}
+		}
+	}
+	if i < len(src) {
+		i++
+	}
+	return i
+}
+
+// regexpAllowed reports whether a "/" following toks starts a regular expression rather than a
+// division, as after an operator or a keyword like return.
+func regexpAllowed(toks []token) bool {
+	i := len(toks) - 1
+	for i >= 0 && toks[i].kind == tokDoc {
+		i--
+	}
+	if i < 0 {
+		return true
+	}
+	switch prev := toks[i]; prev.kind {
+	case tokPunct:
+		return prev.text != ")" && prev.text != "]" && prev.text != "}"
+	case tokIdent:
+		switch prev.text {
+		case "return", "typeof", "case", "do", "else", "in", "of", "new", "delete", "void", "throw", "instanceof", "yield", "await":
+			return true
+		}
+	}
+	return false
+}
+
+// scanRegexp returns the end of the regular expression literal starting at src[i], with its flags.
+func scanRegexp(src string, i int) int {
+	inClass := false
+	for i++; i < len(src) && src[i] != '\n'; i++ {
+		switch c := src[i]; {
+		case c == '\\':
+			i++
+		case c == '[':
+			inClass = true
+		case c == ']':
+			inClass = false
+		case c == '/' && !inClass:
+			for i++; i < len(src) && isIdentPart(src[i]); i++ {
+			}
+			return i
+		}
+	}
+	return i
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9')
+}
+
+// tsParser walks a token stream.
+type tsParser struct {
+	toks []token
+	pos  int
+}
+
+func (p *tsParser) peek(offset int) token {
+	if p.pos+offset < len(p.toks) {
+		return p.toks[p.pos+offset]
+	}
+	return token{kind: tokPunct, text: ""}
+}
+
+func (p *tsParser) is(offset int, text string) bool {
+	t := p.peek(offset)
+	return (t.kind == tokIdent || t.kind == tokPunct) && t.text == text
+}
+
+// skipBalanced advances past a bracketed group starting at the current token.
+func (p *tsParser) skipBalanced() {
+	depth := 0
+	for ; p.pos < len(p.toks); p.pos++ {
+		t := p.toks[p.pos]
+		if t.kind != tokPunct {
+			continue
+		}
+		switch t.text {
+		case "(", "[", "{":
+			depth++
+		case ")", "]", "}":
+			depth--
+			if depth == 0 {
+				p.pos++
+				return
+			}
+		}
+	}
+}
+
+// firstParamType parses a parameter list at the current "(" and returns the first parameter's
+// type annotation, or "" if it has none.
+func (p *tsParser) firstParamType() string {
+	if !p.is(0, "(") {
+		return ""
+	}
+	start := p.pos
+	defer func() { p.pos = start; p.skipBalanced() }()
+	p.pos++
+	// Skip the parameter name or destructuring pattern.
+	if p.is(0, "{") || p.is(0, "[") {
+		p.skipBalanced()
+	} else {
+		p.pos++
+	}
+	if p.is(0, "?") {
+		p.pos++
+	}
+	if !p.is(0, ":") {
+		return ""
+	}
+	p.pos++
+	var parts []string
+	depth := 0
+	for ; p.pos < len(p.toks); p.pos++ {
+		t := p.toks[p.pos]
+		if t.kind == tokPunct {
+			switch t.text {
+			case "(", "[", "{", "<":
+				depth++
+			case ")", "]", "}", ">":
+				if depth == 0 {
+					return strings.Join(parts, "")
+				}
+				depth--
+			case ",", "=":
+				if depth == 0 {
+					return strings.Join(parts, "")
+				}
+			}
+		}
+		parts = append(parts, t.text)
+	}
+	return strings.Join(parts, "")
+}
+
+// functionParamType returns the first parameter type of a function or arrow function starting at
+// the current token, or of the first argument of a call wrapping one. ok is false if the current
+// expression is not a function.
+func (p *tsParser) functionParamType() (typeText string, ok bool) {
+	if p.is(0, "async") {
+		p.pos++
+	}
+	if p.is(0, "function") {
+		p.pos++
+		if p.peek(0).kind == tokIdent {
+			p.pos++
+		}
+		if p.is(0, "<") {
+			p.skipAngles()
+		}
+		return p.firstParamType(), true
+	}
+	if p.is(0, "<") {
+		p.skipAngles()
+	}
+	if p.is(0, "(") {
+		save := p.pos
+		typeText := p.firstParamType()
+		if p.is(0, ":") || p.is(0, "=>") {
+			return typeText, true
+		}
+		p.pos = save
+		return "", false
+	}
+	// A call such as handlers.object.shared(async (ctx: ...) => ...).
+	if p.peek(0).kind == tokIdent {
+		for p.peek(0).kind == tokIdent && p.is(1, ".") {
+			p.pos += 2
+		}
+		if p.peek(0).kind != tokIdent {
+			return "", false
+		}
+		p.pos++
+		if p.is(0, "<") {
+			p.skipAngles()
+		}
+		if !p.is(0, "(") {
+			return "", false
+		}
+		p.pos++
+		return p.functionParamType()
+	}
+	return "", false
+}
+
+// skipAngles advances past a generic parameter or argument list.
+func (p *tsParser) skipAngles() {
+	depth := 0
+	for ; p.pos < len(p.toks); p.pos++ {
+		switch p.toks[p.pos].text {
+		case "<":
+			depth++
+		case ">":
+			depth--
+			if depth == 0 {
+				p.pos++
+				return
+			}
+		}
+	}
+}
+
+// handlerTypeFromContext maps a context type annotation to a handler type, mirroring the Node backend.
+func handlerTypeFromContext(typeText string) string {
+	switch {
+	case strings.Contains(typeText, "WorkflowContext") || strings.Contains(typeText, "WorkflowSharedContext"):
+		return "workflow"
+	case strings.Contains(typeText, "ObjectContext") || strings.Contains(typeText, "ObjectSharedContext"):
+		return "virtualObject"
+	case strings.Contains(typeText, "Context"):
+		return "service"
+	}
+	return ""
+}
+
+// docText strips the delimiters and leading asterisks from a JSDoc comment.
+func docText(comment string) string {
+	comment = strings.TrimSuffix(strings.TrimPrefix(comment, "/**"), "*/")
+	var lines []string
+	for _, line := range strings.Split(comment, "\n") {
+		line = strings.TrimSpace(line)
+		line = strings.TrimPrefix(line, "*")
+		lines = append(lines, strings.TrimPrefix(line, " "))
+	}
+	var kept []string
+	for _, line := range lines {
+		if strings.HasPrefix(line, "@key") {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return strings.TrimSpace(strings.Join(kept, "\n"))
+}
+
+// tsDecl is a top-level declaration of a file.
+type tsDecl struct {
+	// handlerType is the handler type of a function whose first parameter is annotated with a
+	// Restate context type, or "". For a class, it is set if its body mentions a context type.
+	handlerType string
+	class       bool
+	doc         string
+}
+
+// tsExport is a name a file exports, bound to the top-level declaration local, or to none if the
+// export cannot be analyzed, as unsupported explains.
+type tsExport struct {
+	name        string
+	local       string
+	line        int
+	unsupported string
+}
+
+// tsModule is what goScanModule finds in a file.
+type tsModule struct {
+	decls   map[string]tsDecl
+	exports []tsExport
+	// decorated is set if the file uses the @service, @object or @workflow class decorators.
+	decorated bool
+}
+
+// defaultDecl is the key of an anonymous default export in tsModule.decls.
+const defaultDecl = "*default"
+
+// goScanModule finds the top-level declarations and the exports of a TypeScript source file.
+func goScanModule(src string) *tsModule {
+	p := &tsParser{toks: tokenize(src)}
+	m := &tsModule{decls: make(map[string]tsDecl)}
+	depth := 0
+	for p.pos < len(p.toks) {
+		t := p.peek(0)
+		if t.kind == tokPunct {
+			switch t.text {
+			case "{", "(", "[":
+				depth++
+			case "}", ")", "]":
+				depth--
+			case "@":
+				if next := p.peek(1).text; next == "service" || next == "object" || next == "workflow" {
+					m.decorated = true
+				}
+			}
+			p.pos++
+			continue
+		}
+		if depth != 0 || t.kind != tokIdent {
+			p.pos++
+			continue
+		}
+		// Parse the statement, then resume scanning right after its first token so that bracket
+		// depth keeps being tracked over the statement's tokens.
+		start := p.pos
+		if t.text == "export" {
+			p.pos++
+			m.exportStatement(p, t.line)
+		} else if p.startsDeclaration() {
+			doc := p.docBefore()
+			if name, decl := p.declaration(); name != "" {
+				decl.doc = doc
+				m.decls[name] = decl
+			}
+		}
+		p.pos = start + 1
+	}
+	return m
+}
+
+// exportStatement parses the export statement following the export keyword.
+func (m *tsModule) exportStatement(p *tsParser, line int) {
+	switch {
+	case p.is(0, "default"):
+		doc := p.docBefore()
+		p.pos++
+		if p.startsDeclaration() {
+			name, decl := p.declaration()
+			if name == "" {
+				name = defaultDecl
+			}
+			decl.doc = doc
+			m.decls[name] = decl
+			m.exports = append(m.exports, tsExport{name: "default", local: name, line: line})
+			return
+		}
+		if ident := p.peek(0); ident.kind == tokIdent && (p.is(1, ";") || p.peek(1).text == "" || p.peek(1).line > ident.line) {
+			// export default of a declaration made elsewhere in the file.
+			m.exports = append(m.exports, tsExport{name: "default", local: ident.text, line: line})
+			return
+		}
+		typeText, _ := p.functionParamType()
+		m.decls[defaultDecl] = tsDecl{handlerType: handlerTypeFromContext(typeText), doc: doc}
+		m.exports = append(m.exports, tsExport{name: "default", local: defaultDecl, line: line})
+	case p.is(0, "{"):
+		m.exportList(p, line)
+	case p.is(0, "*"):
+		m.exports = append(m.exports, tsExport{name: "*", line: line, unsupported: "export * re-exports another module"})
+	case p.is(0, "type") || p.is(0, "interface") || p.is(0, "declare"):
+		// Types and ambient declarations are erased.
+	case p.startsDeclaration():
+		doc := p.docBefore()
+		name, decl := p.declaration()
+		if name == "" {
+			return
+		}
+		decl.doc = doc
+		m.decls[name] = decl
+		m.exports = append(m.exports, tsExport{name: name, local: name, line: line})
+	}
+}
+
+// exportList parses an export { a, b as c } list, which re-exports another module if followed
+// by from.
+func (m *tsModule) exportList(p *tsParser, line int) {
+	var exports []tsExport
+	for p.pos++; p.pos < len(p.toks) && !p.is(0, "}"); p.pos++ {
+		if p.is(0, ",") {
+			continue
+		}
+		if p.is(0, "type") && p.peek(1).kind == tokIdent && !p.is(1, "as") {
+			// A type-only specifier, erased.
+			p.pos += 2
+			if p.is(0, "as") {
+				p.pos += 2
+			}
+			continue
+		}
+		local := p.peek(0).text
+		name := local
+		if p.is(1, "as") {
+			p.pos += 2
+			name = p.peek(0).text
+		}
+		exports = append(exports, tsExport{name: name, local: local, line: line})
+	}
+	if p.is(1, "from") {
+		for i := range exports {
+			exports[i].local = ""
+			exports[i].unsupported = "it is re-exported from another module"
+		}
+	}
+	m.exports = append(m.exports, exports...)
+}
+
+// startsDeclaration reports whether the current token starts a function, variable, class, enum
+// or namespace declaration.
+func (p *tsParser) startsDeclaration() bool {
+	switch p.peek(0).text {
+	case "function", "const", "let", "var", "class", "enum", "namespace":
+		return p.peek(0).kind == tokIdent
+	case "async":
+		return p.is(1, "function")
+	case "abstract":
+		return p.is(1, "class")
+	}
+	return false
+}
+
+// docBefore returns the text of the JSDoc comment before the declaration starting at the current
+// token, or before the export and declare keywords preceding it.
+func (p *tsParser) docBefore() string {
+	i := p.pos - 1
+	for i >= 0 && p.toks[i].kind == tokIdent && (p.toks[i].text == "export" || p.toks[i].text == "default" || p.toks[i].text == "declare") {
+		i--
+	}
+	if i >= 0 && p.toks[i].kind == tokDoc {
+		return docText(p.toks[i].text)
+	}
+	return ""
+}
+
+// declaration parses the declaration at the current token, see startsDeclaration, and returns the
+// name it declares, "" for an anonymous function or class, and what it declares.
+func (p *tsParser) declaration() (string, tsDecl) {
+	var name, typeText string
+	switch {
+	case p.is(0, "async") || p.is(0, "function"):
+		if p.is(0, "async") {
+			p.pos++
+		}
+		p.pos++
+		if p.is(0, "*") {
+			p.pos++
+		}
+		if p.peek(0).kind == tokIdent {
+			name = p.peek(0).text
+			p.pos++
+		}
+		if p.is(0, "<") {
+			p.skipAngles()
+		}
+		typeText = p.firstParamType()
+	case p.is(0, "abstract") || p.is(0, "class"):
+		if p.is(0, "abstract") {
+			p.pos++
+		}
+		p.pos++
+		if p.peek(0).kind == tokIdent && !p.is(0, "extends") && !p.is(0, "implements") {
+			name = p.peek(0).text
+		}
+		// A class declares handlers if one of its methods takes a context.
+		decl := tsDecl{class: true}
+		for ; p.pos < len(p.toks) && !p.is(0, "{"); p.pos++ {
+		}
+		start := p.pos
+		p.skipBalanced()
+		for _, t := range p.toks[start:p.pos] {
+			if t.kind == tokIdent && handlerTypeFromContext(t.text) != "" {
+				decl.handlerType = handlerTypeFromContext(t.text)
+				break
+			}
+		}
+		return name, decl
+	case p.is(0, "enum") || p.is(0, "namespace"):
+		return p.peek(1).text, tsDecl{}
+	default: // const, let or var
+		p.pos++
+		if p.is(0, "enum") {
+			return p.peek(1).text, tsDecl{}
+		}
+		if p.peek(0).kind != tokIdent {
+			return "", tsDecl{}
+		}
+		name = p.peek(0).text
+		p.pos++
+		if p.is(0, ":") {
+			// Skip an explicit variable type annotation.
+			for p.pos < len(p.toks) && !p.is(0, "=") {
+				if p.is(0, "(") || p.is(0, "{") || p.is(0, "[") {
+					p.skipBalanced()
+				} else {
+					p.pos++
+				}
+			}
+		}
+		if !p.is(0, "=") {
+			return name, tsDecl{}
+		}
+		p.pos++
+		typeText, _ = p.functionParamType()
+	}
+	return name, tsDecl{handlerType: handlerTypeFromContext(typeText)}
+}
+
+// goExtractFile extracts the handlers of the TypeScript file at path, imported from the service
+// as source. Syntax errors, and exports whose declaration cannot be analyzed, are returned as
+// error diagnostics.
+func goExtractFile(path, src, source string) ([]HandlerEntry, []Diagnostic) {
+	names, diagnostics := esbuildExports(path, src)
+	if len(diagnostics) > 0 {
+		return nil, diagnostics
+	}
+	exported := make(map[string]bool)
+	for _, name := range names {
+		exported[name] = true
+	}
+	unsupported := func(e tsExport, reason string) {
+		diagnostics = append(diagnostics, Diagnostic{File: path, Line: e.line, Severity: "error",
+			Message: fmt.Sprintf("the Go extractor cannot extract the export %q: %s; set \"extractor\": \"node\"", e.name, reason)})
+	}
+	m := goScanModule(src)
+	var handlers []HandlerEntry
+	for _, e := range m.exports {
+		if e.name == "*" {
+			unsupported(e, e.unsupported)
+			continue
+		}
+		if !exported[e.name] {
+			// Not a value, e.g. a type.
+			continue
+		}
+		delete(exported, e.name)
+		decl, ok := m.decls[e.local]
+		switch {
+		case e.unsupported != "":
+			unsupported(e, e.unsupported)
+		case !ok:
+			unsupported(e, "its declaration was not found in the file")
+		case decl.class && (decl.handlerType != "" || m.decorated):
+			unsupported(e, "handler classes are not supported")
+		case decl.handlerType != "" && !decl.class:
+			handlers = append(handlers, HandlerEntry{ExportName: e.name, Source: source, Type: decl.handlerType, Doc: decl.doc, Default: e.name == "default"})
+		}
+	}
+	// Names exported in a form the token stream does not show.
+	var rest []string
+	for name := range exported {
+		rest = append(rest, name)
+	}
+	sort.Strings(rest)
+	for _, name := range rest {
+		unsupported(tsExport{name: name}, "its export statement could not be analyzed")
+	}
+	return handlers, diagnostics
+}
+
+// esbuildExports parses the TypeScript file at path with esbuild and returns the names it exports,
+// or its syntax errors as diagnostics.
+func esbuildExports(path, src string) ([]string, []Diagnostic) {
+	result := api.Build(api.BuildOptions{
+		Stdin:    &api.StdinOptions{Contents: src, Sourcefile: path, Loader: api.LoaderTS},
+		Format:   api.FormatESModule,
+		Outfile:  "out.js",
+		Metafile: true,
+		LogLevel: api.LogLevelSilent,
+	})
+	var diagnostics []Diagnostic
+	for _, msg := range result.Errors {
+		d := Diagnostic{File: path, Severity: "error", Message: msg.Text}
+		if msg.Location != nil {
+			d.Line, d.Column = msg.Location.Line, msg.Location.Column+1
+		}
+		diagnostics = append(diagnostics, d)
+	}
+	if len(diagnostics) > 0 {
+		return nil, diagnostics
+	}
+	var meta struct {
+		Outputs map[string]struct {
+			Exports []string `json:"exports"`
+		} `json:"outputs"`
+	}
+	if err := json.Unmarshal([]byte(result.Metafile), &meta); err != nil {
+		return nil, []Diagnostic{{File: path, Severity: "error", Message: fmt.Sprintf("failed to read the exports found by esbuild: %v", err)}}
+	}
+	var names []string
+	for _, output := range meta.Outputs {
+		names = append(names, output.Exports...)
+	}
+	return names, nil
+}
+
+// goExtractServiceName returns the name passed to new Service(...) in the service file, or else
+// the string literal passed to a call in the default export, as in defineService("name").
+func goExtractServiceName(src string) string {
+	toks := tokenize(src)
+	for i := 0; i+3 < len(toks); i++ {
+		if toks[i].text == "new" && toks[i+1].text == "Service" && toks[i+2].text == "(" && toks[i+3].kind == tokString {
+			s := toks[i+3].text
+			return s[1 : len(s)-1]
+		}
+	}
+	start := len(toks)
+	for i := 0; i+1 < len(toks); i++ {
+		if toks[i].text == "export" && toks[i+1].text == "default" {
+			start = i + 2
+			break
+		}
+	}
+	for i := start; i+2 < len(toks); i++ {
+		if toks[i].kind == tokIdent && toks[i+1].text == "(" && toks[i+2].kind == tokString {
+			s := toks[i+2].text
+			return s[1 : len(s)-1]
+		}
+	}
+	return ""
+}
+
+// goExtractManifest builds the manifest of a service directory with the native Go backend.
+func goExtractManifest(dir string) (*Manifest, error) {
+	serviceFile := serviceMarker(dir)
+	if serviceFile == "" {
+		return nil, fmt.Errorf("service file not found in %s", dir)
+	}
+	serviceSrc, err := ioutil.ReadFile(filepath.Join(dir, serviceFile))
+	if err != nil {
+		return nil, fmt.Errorf("service file not found: %v", err)
+	}
+	serviceName := goExtractServiceName(string(serviceSrc))
+	if serviceName == "" {
+		return nil, fmt.Errorf("service name not found in %s", filepath.Join(dir, serviceFile))
+	}
+	manifest := &Manifest{SchemaVersion: manifestSchemaVersion, ServiceName: serviceName, Handlers: []HandlerEntry{}}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".ts") || strings.HasSuffix(name, ".d.ts") ||
+			name == defaultServiceMarker || name == serviceFile || strings.HasPrefix(name, "restate.") || strings.HasSuffix(name, ".restate.ts") ||
+			projectIgnore.ignored(filepath.Join(dir, name), false) {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		handlers, diagnostics := goExtractFile(path, string(src), "./"+strings.TrimSuffix(name, ".ts"))
+		manifest.Handlers = append(manifest.Handlers, handlers...)
+		manifest.Diagnostics = append(manifest.Diagnostics, diagnostics...)
+	}
+	return manifest, nil
+}
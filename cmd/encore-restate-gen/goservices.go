@@ -0,0 +1,445 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	gotoken "go/token"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/template"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// encoreLangGo is the lang of encore.app of Encore apps written in Go.
+const encoreLangGo = "go"
+
+// goBindingsFile is the file generated in every Go service package with Restate components.
+const goBindingsFile = "restate.gen.go"
+
+// restateGoSDK is the import path of the Restate Go SDK.
+const restateGoSDK = "github.com/restatedev/sdk-go"
+
+// Kinds of Restate components, by the context type their handlers take.
+var goContextKinds = map[string]string{
+	"Context":               "service",
+	"ObjectContext":         "object",
+	"ObjectSharedContext":   "object",
+	"WorkflowContext":       "workflow",
+	"WorkflowSharedContext": "workflow",
+}
+
+// GoHandler is a handler method of a Restate component of a Go service.
+type GoHandler struct {
+	Name   string
+	Input  string // the input type, restate.Void if the handler takes none
+	Output string // the output type, restate.Void if the handler returns none
+}
+
+// GoComponent is a type whose methods are the handlers of a Restate component, bound with
+// restate.Reflect, which names the component after the type.
+type GoComponent struct {
+	Name     string
+	Kind     string // "service", "object" or "workflow"
+	Pointer  bool   // whether the handlers have pointer receivers
+	Handlers []GoHandler
+}
+
+// GoBindingsData is the data of goBindingsTemplate.
+type GoBindingsData struct {
+	Package      string
+	Service      string // the Encore service, which Encore names after the package
+	Components   []GoComponent
+	Imports      []string // the import specs of the packages the handler types refer to
+	IdentityKeys []string
+	IngressURL   string
+}
+
+// goBindingsTemplate is written to restate.gen.go. It serves the components with the SDK's HTTP
+// handler behind a raw Encore endpoint, and calls them through the Restate ingress.
+const goBindingsTemplate = `// Code generated by encore-restate-gen. DO NOT EDIT.
+
+package {{ .Package }}
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	restate "github.com/restatedev/sdk-go"
+	"github.com/restatedev/sdk-go/ingress"
+	"github.com/restatedev/sdk-go/server"
+	{{- range .Imports }}
+	{{ . }}
+	{{- end }}
+)
+
+// restateHandler is the Restate SDK handler serving the Restate components of this service.
+var restateHandler = sync.OnceValues(func() (http.HandlerFunc, error) {
+	return server.NewRestate().
+		{{- range .IdentityKeys }}
+		WithIdentityV1({{ printf "%q" . }}).
+		{{- end }}
+		{{- range .Components }}
+		Bind(restate.Reflect({{ if .Pointer }}&{{ end }}{{ .Name }}{})).
+		{{- end }}
+		Bidirectional(false).
+		Handler()
+})
+
+// RestateEndpoint serves the Restate components of this service. Like the invoke endpoints of
+// TypeScript services, it is private to the app. Register <app URL>/{{ .Service }}/restate with
+// Restate.
+//
+//encore:api private raw path=/{{ .Service }}/restate/*path
+func RestateEndpoint(w http.ResponseWriter, req *http.Request) {
+	handler, err := restateHandler()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.StripPrefix("/{{ .Service }}/restate", handler).ServeHTTP(w, req)
+}
+
+// restateIngress returns the client of the Restate ingress at RESTATE_SERVER_URL, or the
+// configured one.
+var restateIngress = sync.OnceValue(func() *ingress.Client {
+	url := os.Getenv("RESTATE_SERVER_URL")
+	if url == "" {
+		url = {{ printf "%q" .IngressURL }}
+	}
+	return ingress.NewClient(strings.TrimSuffix(url, "/"))
+})
+{{- range $c := .Components }}
+{{- range .Handlers }}
+
+// Restate{{ $c.Name }}{{ .Name }} calls the handler {{ .Name }} of the Restate {{ $c.Kind }} {{ $c.Name }}.
+{{- if eq $c.Kind "service" }}
+func Restate{{ $c.Name }}{{ .Name }}(ctx context.Context, input {{ .Input }}) ({{ .Output }}, error) {
+	return ingress.Service[{{ .Input }}, {{ .Output }}](restateIngress(), "{{ $c.Name }}", "{{ .Name }}").Request(ctx, input)
+}
+{{- else if eq $c.Kind "object" }}
+func Restate{{ $c.Name }}{{ .Name }}(ctx context.Context, key string, input {{ .Input }}) ({{ .Output }}, error) {
+	return ingress.Object[{{ .Input }}, {{ .Output }}](restateIngress(), "{{ $c.Name }}", key, "{{ .Name }}").Request(ctx, input)
+}
+{{- else }}
+func Restate{{ $c.Name }}{{ .Name }}(ctx context.Context, workflowID string, input {{ .Input }}) ({{ .Output }}, error) {
+	return ingress.Workflow[{{ .Input }}, {{ .Output }}](restateIngress(), "{{ $c.Name }}", workflowID, "{{ .Name }}").Request(ctx, input)
+}
+{{- end }}
+{{- end }}
+{{- end }}
+`
+
+var goBindingsTmpl = template.Must(template.New("goBindings").Parse(goBindingsTemplate))
+
+// checkGoAppConfig returns an error if cfg has settings the Go bindings do not implement. They read
+// Encore secrets, which a Go service package declares in a single secrets struct of its own.
+func checkGoAppConfig(cfg Config) error {
+	var unsupported []string
+	if cfg.SharedSecret != nil {
+		unsupported = append(unsupported, "sharedSecret")
+	}
+	if cfg.Identity != nil && cfg.Identity.KeysSecret != "" {
+		unsupported = append(unsupported, "identity.keysSecret")
+	}
+	if cfg.Client.AuthTokenSecret != "" {
+		unsupported = append(unsupported, "client.authTokenSecret")
+	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf("%s cannot be used with Encore apps written in Go; remove it from %s", strings.Join(unsupported, ", "), configFileName)
+	}
+	return nil
+}
+
+// isGoSourceFile reports whether path is a Go file of a service package that should trigger
+// regeneration.
+func isGoSourceFile(path string) bool {
+	name := filepath.Base(path)
+	return strings.HasSuffix(name, ".go") && !strings.HasSuffix(name, "_test.go") && name != goBindingsFile
+}
+
+// goServiceFiles parses the Go files of dir and returns them if dir is an Encore service, a package
+// with //encore:api endpoints or an //encore:service struct.
+func goServiceFiles(fset *gotoken.FileSet, dir string) ([]*ast.File, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []*ast.File
+	service := false
+	for _, entry := range entries {
+		if entry.IsDir() || !isGoSourceFile(entry.Name()) {
+			continue
+		}
+		file, err := parser.ParseFile(fset, filepath.Join(dir, entry.Name()), nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+		for _, group := range file.Comments {
+			for _, c := range group.List {
+				if strings.HasPrefix(c.Text, "//encore:api") || strings.HasPrefix(c.Text, "//encore:service") {
+					service = true
+				}
+			}
+		}
+	}
+	if !service {
+		return nil, nil
+	}
+	return files, nil
+}
+
+// restateImportName returns the name file imports the Restate Go SDK as, or "".
+func restateImportName(file *ast.File) string {
+	for _, spec := range file.Imports {
+		if path, _ := strconv.Unquote(spec.Path.Value); path == restateGoSDK {
+			if spec.Name != nil {
+				return spec.Name.Name
+			}
+			return "restate"
+		}
+	}
+	return ""
+}
+
+// goComponents returns the Restate components among the methods of files: the exported methods
+// taking a Restate context, grouped by receiver type. The import specs the handler types need are
+// added to imports.
+func goComponents(fset *gotoken.FileSet, files []*ast.File, imports map[string]bool) ([]GoComponent, error) {
+	byName := make(map[string]*GoComponent)
+	for _, file := range files {
+		sdk := restateImportName(file)
+		if sdk == "" {
+			continue
+		}
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Recv == nil || len(fn.Recv.List) != 1 || !fn.Name.IsExported() {
+				continue
+			}
+			params := fn.Type.Params.List
+			if len(params) == 0 {
+				continue
+			}
+			sel, ok := params[0].Type.(*ast.SelectorExpr)
+			if !ok {
+				continue
+			}
+			if x, ok := sel.X.(*ast.Ident); !ok || x.Name != sdk {
+				continue
+			}
+			kind, ok := goContextKinds[sel.Sel.Name]
+			if !ok {
+				continue
+			}
+			recv := fn.Recv.List[0].Type
+			pointer := false
+			if star, ok := recv.(*ast.StarExpr); ok {
+				recv, pointer = star.X, true
+			}
+			ident, ok := recv.(*ast.Ident)
+			if !ok {
+				continue
+			}
+			handler := GoHandler{Name: fn.Name.Name, Input: "restate.Void", Output: "restate.Void"}
+			// The SDK accepts a single input after the context.
+			switch {
+			case len(params[0].Names) > 1 || len(params) > 2 || (len(params) == 2 && len(params[1].Names) > 1):
+				return nil, fmt.Errorf("%s: handler %s.%s must take a context and at most one input", fset.Position(fn.Pos()), ident.Name, fn.Name.Name)
+			case len(params) == 2:
+				input, err := goTypeString(fset, file, sdk, params[1].Type, imports)
+				if err != nil {
+					return nil, err
+				}
+				handler.Input = input
+			}
+			if results := fn.Type.Results; results != nil && len(results.List) == 2 {
+				output, err := goTypeString(fset, file, sdk, results.List[0].Type, imports)
+				if err != nil {
+					return nil, err
+				}
+				handler.Output = output
+			}
+			c := byName[ident.Name]
+			if c == nil {
+				c = &GoComponent{Name: ident.Name, Kind: kind}
+				byName[ident.Name] = c
+			}
+			if c.Kind != kind {
+				return nil, fmt.Errorf("%s: handler %s.%s takes a %s context, but %s is a %s", fset.Position(fn.Pos()), ident.Name, fn.Name.Name, kind, ident.Name, c.Kind)
+			}
+			c.Pointer = c.Pointer || pointer
+			c.Handlers = append(c.Handlers, handler)
+		}
+	}
+	var components []GoComponent
+	for _, c := range byName {
+		sort.Slice(c.Handlers, func(i, j int) bool { return c.Handlers[i].Name < c.Handlers[j].Name })
+		components = append(components, *c)
+	}
+	sort.Slice(components, func(i, j int) bool { return components[i].Name < components[j].Name })
+	return components, nil
+}
+
+// goTypeString returns the source of the type expression expr of file, with the Restate SDK as
+// restate, and adds the import specs of the other packages it refers to to imports.
+func goTypeString(fset *gotoken.FileSet, file *ast.File, sdk string, expr ast.Expr, imports map[string]bool) (string, error) {
+	ast.Inspect(expr, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		if x, ok := sel.X.(*ast.Ident); ok && x.Name != sdk {
+			for _, spec := range file.Imports {
+				path, _ := strconv.Unquote(spec.Path.Value)
+				switch {
+				case spec.Name != nil && spec.Name.Name == x.Name:
+					imports[x.Name+" "+spec.Path.Value] = true
+				case spec.Name == nil && filepath.Base(path) == x.Name:
+					imports[spec.Path.Value] = true
+				}
+			}
+		}
+		return false
+	})
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, fset, expr); err != nil {
+		return "", err
+	}
+	return strings.Replace(buf.String(), sdk+".", "restate.", -1), nil
+}
+
+// generateGoBindings writes restate.gen.go to the Go service package in dir, or removes it if the
+// package has no Restate components.
+func generateGoBindings(dir string) error {
+	path := filepath.Join(dir, goBindingsFile)
+	fset := gotoken.NewFileSet()
+	files, err := goServiceFiles(fset, dir)
+	if err != nil {
+		return err
+	}
+	var components []GoComponent
+	imports := make(map[string]bool)
+	if len(files) > 0 {
+		if components, err = goComponents(fset, files, imports); err != nil {
+			return err
+		}
+	}
+	if len(components) == 0 {
+		if _, err := os.Stat(path); err == nil {
+			log.Printf("Removed generated file: %s", path)
+			return os.Remove(path)
+		}
+		return nil
+	}
+	data := GoBindingsData{
+		Package:      files[0].Name.Name,
+		Service:      files[0].Name.Name,
+		Components:   components,
+		IdentityKeys: identityKeys(),
+		IngressURL:   defaultIngress(),
+	}
+	for spec := range imports {
+		// Imports the generated file makes itself are not repeated.
+		switch spec {
+		case `"context"`, `"net/http"`, `"os"`, `"strings"`, `"sync"`:
+			continue
+		}
+		data.Imports = append(data.Imports, spec)
+	}
+	sort.Strings(data.Imports)
+	var buf bytes.Buffer
+	if err := goBindingsTmpl.Execute(&buf, data); err != nil {
+		return err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("generated invalid Go code: %v", err)
+	}
+	if old, err := ioutil.ReadFile(path); err == nil && bytes.Equal(old, src) {
+		return nil
+	}
+	if err := ioutil.WriteFile(path, src, 0644); err != nil {
+		return err
+	}
+	log.Printf("Generated file: %s", path)
+	return nil
+}
+
+// generateGoApp generates the Go bindings of every service package of the app at root, and returns
+// the number of packages whose bindings failed.
+func generateGoApp(root string) int {
+	failed := 0
+	walkDirs(root, func(dir string) error {
+		if err := generateGoBindings(dir); err != nil {
+			failed++
+			log.Printf("Error generating Go bindings in %s: %v", dir, err)
+		}
+		return nil
+	})
+	return failed
+}
+
+// goAppMain generates the Go bindings of an Encore app written in Go and regenerates them as its
+// Go files change.
+func goAppMain(root string) {
+	log.Printf("Monitoring Encore Go app at: %s", root)
+	refreshIdentityKeys(root)
+	generateGoApp(root)
+	watcher, err := newFallbackWatcher()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer watcher.Close()
+	if err := walkDirs(root, watcher.Add); err != nil {
+		log.Fatal(err)
+	}
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op&fsnotify.Create != 0 {
+					if info, err := os.Stat(event.Name); err == nil && info.IsDir() && !projectIgnore.ignored(event.Name, true) {
+						walkDirs(event.Name, watcher.Add)
+					}
+				}
+				if !isGoSourceFile(event.Name) || projectIgnore.ignored(event.Name, false) {
+					continue
+				}
+				dir := filepath.Dir(event.Name)
+				debounce("go "+dir, 100*time.Millisecond, func() {
+					if err := generateGoBindings(dir); err != nil {
+						log.Printf("Error generating Go bindings in %s: %v", dir, err)
+					}
+				})
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Println("Watcher error:", err)
+			}
+		}
+	}()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// healthInterval is the interval of -health-interval; 0 disables probing Restate while watching.
+var healthInterval = 30 * time.Second
+
+// ingressURL returns the Restate ingress URL: RESTATE_SERVER_URL, or else defaultIngress.
+func ingressURL() string {
+	if url := os.Getenv("RESTATE_SERVER_URL"); url != "" {
+		return strings.TrimSuffix(url, "/")
+	}
+	return defaultIngress()
+}
+
+// defaultIngress returns the ingress URL the generated client uses unless RESTATE_SERVER_URL is
+// set: the Restate Cloud environment's, or http://localhost:8080.
+func defaultIngress() string {
+	if url := cloudURL(cloudIngressPort); url != "" {
+		return url
+	}
+	return defaultIngressURL
+}
+
+// restateProbe is a Restate endpoint checked by probeRestate.
+type restateProbe struct {
+	Name string
+	URL  string
+	Err  error
+}
+
+// probeRestate checks whether the Restate ingress and admin API answer their health endpoints.
+func probeRestate() []restateProbe {
+	probes := []restateProbe{
+		{Name: "ingress", URL: ingressURL() + "/restate/health"},
+		{Name: "admin API", URL: adminURL() + "/health"},
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	var wg sync.WaitGroup
+	for i := range probes {
+		wg.Add(1)
+		go func(p *restateProbe) {
+			defer wg.Done()
+			req, err := http.NewRequest("GET", p.URL, nil)
+			if err != nil {
+				p.Err = err
+				return
+			}
+			if token, _ := adminToken(); token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				p.Err = err
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode/100 != 2 {
+				p.Err = fmt.Errorf("answered %s", resp.Status)
+			}
+		}(&probes[i])
+	}
+	wg.Wait()
+	return probes
+}
+
+// restateUnreachable summarizes the failed probes, or returns "" if all succeeded.
+func restateUnreachable(probes []restateProbe) string {
+	var failed []string
+	for _, p := range probes {
+		if p.Err != nil {
+			failed = append(failed, fmt.Sprintf("%s at %s: %v", p.Name, p.URL, p.Err))
+		}
+	}
+	return strings.Join(failed, "; ")
+}
+
+// watchRestateHealth probes Restate every interval and logs when it becomes unreachable or
+// reachable again, so failing invocations are not mistaken for generation problems.
+func watchRestateHealth(interval time.Duration) {
+	reachable := true
+	for {
+		if problem := restateUnreachable(probeRestate()); problem != "" {
+			if reachable {
+				log.Printf("RESTATE UNREACHABLE: %s. Invocations fail until the Restate server is running; the generated code is not affected.", problem)
+			}
+			reachable = false
+		} else if !reachable {
+			log.Printf("Restate is reachable again")
+			reachable = true
+		}
+		time.Sleep(interval)
+	}
+}
+
+// doctorMain implements the doctor subcommand, which reports the configuration entries and
+// dependencies generated code needs but that are missing, and whether Restate is reachable.
+func doctorMain(args []string) {
+	flags := flag.NewFlagSet("doctor", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s doctor [project root]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	root := "."
+	if flags.NArg() > 0 {
+		root = flags.Arg(0)
+	}
+	projectRoot = root
+	cfg, err := loadConfig(root)
+	if err != nil {
+		log.Fatalf("Failed to load %s: %v", configFileName, err)
+	}
+	projectConfig = cfg
+	globalPackageManager = detectPackageManager(root)
+	problems := 0
+	findings, err := checkConfiguration(root)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	dependencies, err := dependencyFindings(root)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	logFindings(findings, dependencies)
+	problems += len(findings) + len(dependencies)
+	for _, p := range probeRestate() {
+		if p.Err != nil {
+			log.Printf("Restate unreachable: %s at %s: %v", p.Name, p.URL, p.Err)
+			problems++
+		} else {
+			log.Printf("Restate %s at %s is healthy", p.Name, p.URL)
+		}
+	}
+	if problems > 0 {
+		log.Fatalf("%d problems found", problems)
+	}
+	log.Printf("No problems found")
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Hook events, the values of HookConfig.On.
+const (
+	hookService = "service"
+	hookIndex   = "index"
+)
+
+// hookTimeout bounds a single hook command or webhook request.
+const hookTimeout = time.Minute
+
+// hookRun is a generated file to run the hooks of an event for.
+type hookRun struct {
+	Event string `json:"event"`
+	File  string `json:"file"`
+	// Service is the Encore service the file was generated for; empty for the central index.
+	Service string `json:"service,omitempty"`
+}
+
+var (
+	hookMutex sync.Mutex
+	// hookPending are the queued runs in order, and hookQueued the same runs as a set.
+	hookPending []hookRun
+	hookQueued  = make(map[hookRun]bool)
+	hookWake    = make(chan struct{}, 1)
+	hookRunning sync.WaitGroup
+	hookOnce    sync.Once
+)
+
+// runHooks queues the configured hooks of event for file and returns without waiting for them, so
+// generation is never held up. Hooks run one at a time in the order they were queued; a file queued
+// again before its hooks started runs them once.
+func runHooks(event, file, service string) {
+	if !hasHooks(event) {
+		return
+	}
+	hookOnce.Do(func() { go hookWorker() })
+	run := hookRun{Event: event, File: file, Service: service}
+	hookMutex.Lock()
+	if !hookQueued[run] {
+		hookQueued[run] = true
+		hookPending = append(hookPending, run)
+		hookRunning.Add(1)
+	}
+	hookMutex.Unlock()
+	select {
+	case hookWake <- struct{}{}:
+	default:
+	}
+}
+
+// hookWorker runs the queued hooks whenever it is woken.
+func hookWorker() {
+	for range hookWake {
+		for {
+			hookMutex.Lock()
+			if len(hookPending) == 0 {
+				hookMutex.Unlock()
+				break
+			}
+			run := hookPending[0]
+			hookPending = hookPending[1:]
+			delete(hookQueued, run)
+			hookMutex.Unlock()
+			for _, hook := range projectConfig.Hooks {
+				if hookEvent(hook) != run.Event {
+					continue
+				}
+				if err := runHook(hook, run); err != nil {
+					log.Printf("Error running %s hook for %s: %v", run.Event, run.File, err)
+				}
+			}
+			hookRunning.Done()
+		}
+	}
+}
+
+// waitHooks waits until the queued hooks have run.
+func waitHooks() {
+	hookRunning.Wait()
+}
+
+// hookEvent returns the event hook runs on.
+func hookEvent(hook HookConfig) string {
+	if hook.On == "" {
+		return hookIndex
+	}
+	return hook.On
+}
+
+// hasHooks reports whether hooks are configured for event.
+func hasHooks(event string) bool {
+	for _, hook := range projectConfig.Hooks {
+		if hookEvent(hook) == event {
+			return true
+		}
+	}
+	return false
+}
+
+// runHook runs one hook for run.
+func runHook(hook HookConfig, run hookRun) error {
+	switch {
+	case hook.Command != "":
+		return runHookCommand(hook.Command, run)
+	case hook.URL != "":
+		if offline {
+			return nil
+		}
+		return postHook(hook.URL, run)
+	default:
+		path := scriptPath(hook.Touch)
+		now := time.Now()
+		err := os.Chtimes(path, now, now)
+		if !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(path, nil, 0644)
+	}
+}
+
+// runHookCommand runs command with the shell in the project root. The generated file is passed in
+// RESTATE_GEN_EVENT, RESTATE_GEN_FILE and RESTATE_GEN_SERVICE; output is logged.
+func runHookCommand(command string, run hookRun) error {
+	cmd := shellCommand(command)
+	cmd.Dir = projectRoot
+	cmd.Env = append(os.Environ(),
+		"RESTATE_GEN_EVENT="+run.Event,
+		"RESTATE_GEN_FILE="+run.File,
+		"RESTATE_GEN_SERVICE="+run.Service,
+	)
+	output := &lineLogger{prefix: "hook: "}
+	cmd.Stdout, cmd.Stderr = output, output
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("%s: %v", command, err)
+		}
+		return nil
+	case <-time.After(hookTimeout):
+		killProcessGroup(cmd)
+		<-done
+		return fmt.Errorf("%s timed out after %v", command, hookTimeout)
+	}
+}
+
+// postHook posts run as JSON to url.
+func postHook(url string, run hookRun) error {
+	body, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: hookTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s answered %s", url, resp.Status)
+	}
+	return nil
+}
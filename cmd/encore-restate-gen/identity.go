@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// identityRefreshInterval is how often the watcher fetches the identity keys again, so a rotated
+// key is accepted without restarting it.
+const identityRefreshInterval = 10 * time.Minute
+
+// identityKeyRe matches a Restate identity public key.
+var identityKeyRe = regexp.MustCompile(`publickeyv1_[A-Za-z0-9]+`)
+
+// fetchedIdentityKeys are the keys last fetched from identity.keysUrl, or loaded from the cache.
+var fetchedIdentityKeys []string
+
+// identityKeysPath returns the file the keys fetched for root are cached in.
+func identityKeysPath(root string) (string, error) {
+	base, err := cacheDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(absRealDir(root)))
+	return filepath.Join(base, "identity", hex.EncodeToString(sum[:])+".json"), nil
+}
+
+// fetchIdentityKeys fetches identity.keysUrl and returns the identity keys in its answer, whatever
+// its format, sorted. The adminToken is sent as a bearer token.
+func fetchIdentityKeys() ([]string, error) {
+	keysURL := projectConfig.Identity.KeysURL
+	req, err := http.NewRequest("GET", keysURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token, _ := adminToken(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	answer, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s answered %s", keysURL, resp.Status)
+	}
+	seen := make(map[string]bool)
+	var keys []string
+	for _, key := range identityKeyRe.FindAllString(string(answer), -1) {
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s answered without an identity key", keysURL)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// cachedIdentityKeys returns the identity keys cached for root.
+func cachedIdentityKeys(root string) []string {
+	path, err := identityKeysPath(root)
+	if err != nil {
+		return nil
+	}
+	var keys []string
+	if data, err := ioutil.ReadFile(path); err == nil {
+		json.Unmarshal(data, &keys)
+	}
+	return keys
+}
+
+// saveIdentityKeys caches the identity keys fetched for root.
+func saveIdentityKeys(root string, keys []string) error {
+	path, err := identityKeysPath(root)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// refreshIdentityKeys fetches the identity keys of identity.keysUrl and caches them, and reports
+// whether they changed. Offline, or if fetching fails, the cached keys are used.
+func refreshIdentityKeys(root string) bool {
+	if projectConfig.Identity == nil || projectConfig.Identity.KeysURL == "" {
+		return false
+	}
+	previous := fetchedIdentityKeys
+	if previous == nil {
+		previous = cachedIdentityKeys(root)
+	}
+	var keys []string
+	err := fmt.Errorf("offline")
+	if !offline {
+		keys, err = fetchIdentityKeys()
+	}
+	if err != nil {
+		if len(previous) == 0 {
+			log.Printf("Error fetching the identity keys: %v; the generated endpoints reject every request until they are fetched", err)
+		} else if fetchedIdentityKeys == nil {
+			log.Printf("Error fetching the identity keys: %v; using the %d cached ones", err, len(previous))
+		}
+		keys = previous
+	} else {
+		if len(previous) > 0 && !reflect.DeepEqual(previous, keys) {
+			log.Printf("The identity keys at %s changed: now accepting %v", projectConfig.Identity.KeysURL, keys)
+		}
+		if err := saveIdentityKeys(root, keys); err != nil {
+			log.Printf("Error caching the identity keys: %v", err)
+		}
+	}
+	changed := !reflect.DeepEqual(fetchedIdentityKeys, keys)
+	fetchedIdentityKeys = keys
+	return changed
+}
+
+// watchIdentityKeys fetches the identity keys every identityRefreshInterval and regenerates
+// restate.config when they change.
+func watchIdentityKeys(root string) {
+	for {
+		time.Sleep(identityRefreshInterval)
+		if refreshIdentityKeys(root) {
+			if err := generateRestateConfig(root); err != nil {
+				log.Printf("Error writing %s: %v", restateConfigName, err)
+			}
+		}
+	}
+}
+
+// identityKeys returns the keys the generated endpoints accept, besides those of identity.keysSecret:
+// the configured and the fetched ones. Several keys are accepted at once, so a key can be rotated
+// by adding the new one before removing the old.
+func identityKeys() []string {
+	keys := []string{}
+	seen := make(map[string]bool)
+	var configured []string
+	if projectConfig.Identity != nil {
+		configured = projectConfig.Identity.Keys
+	}
+	for _, key := range append(append([]string{}, configured...), fetchedIdentityKeys...) {
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// identityMain implements the identity subcommand. Its only command, fetch, fetches the identity
+// keys of identity.keysUrl, caches them for the watcher and prints them.
+func identityMain(args []string) {
+	flags := flag.NewFlagSet("identity", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s identity fetch [project root]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	if len(args) == 0 || args[0] != "fetch" {
+		flags.Usage()
+		os.Exit(2)
+	}
+	flags.Parse(args[1:])
+	root := "."
+	if flags.NArg() > 0 {
+		root = flags.Arg(0)
+	}
+	projectRoot = root
+	cfg, err := loadConfig(root)
+	if err != nil {
+		log.Fatalf("Failed to load %s: %v", configFileName, err)
+	}
+	projectConfig = cfg
+	if cfg.Identity == nil || cfg.Identity.KeysURL == "" {
+		log.Fatalf("identity.keysUrl is not set in %s", configFileName)
+	}
+	keys, err := fetchIdentityKeys()
+	if err != nil {
+		log.Fatalf("Error fetching the identity keys: %v", err)
+	}
+	if err := saveIdentityKeys(root, keys); err != nil {
+		log.Fatalf("Error caching the identity keys: %v", err)
+	}
+	for _, key := range keys {
+		fmt.Println(key)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// packageImports returns the subpath imports generated code is imported through in the "imports"
+// aliasing mode. Node does not resolve directories, so #restate/* maps to their index files.
+func packageImports() [][2]string {
+	return [][2]string{
+		{"#restate", "./restate.gen/index" + outputExt()},
+		{"#restate/*", "./restate.gen/*/index" + outputExt()},
+	}
+}
+
+// updatePackageImports adds the #restate subpath imports to the "imports" of the project's
+// package.json. Like updateTsConfig, it only inserts missing entries and keeps the formatting.
+func updatePackageImports(root string) error {
+	f, err := planPackageImports(root)
+	if err != nil || f == nil {
+		return err
+	}
+	log.Printf("Added the #restate subpath imports to %s", f.path)
+	if err := ioutil.WriteFile(f.path, []byte(f.editor.src), 0644); err != nil {
+		return err
+	}
+	recordModifications(root, f.added, nil)
+	return nil
+}
+
+// planPackageImports patches package.json like updatePackageImports in memory, and returns it, or
+// nil if nothing is missing.
+func planPackageImports(root string) (*tsconfigFile, error) {
+	path := filepath.Join(root, "package.json")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &tsconfigFile{path: path, editor: newJSONCEditor(string(data))}
+	if f.root, err = parseJSONC(f.editor.src); err != nil {
+		return nil, err
+	}
+	if f.root.kind != '{' {
+		return nil, nil
+	}
+	if f.lookup("imports") == nil {
+		if err := f.insert([]string{`"imports": {}`}); err != nil {
+			return nil, err
+		}
+	}
+	imports := f.lookup("imports")
+	if imports.kind != '{' {
+		return nil, nil
+	}
+	var missing []string
+	for _, entry := range packageImports() {
+		if imports.member(entry[0]) == nil {
+			missing = append(missing, quoteJSON(entry[0])+": "+quoteJSON(entry[1]))
+		}
+	}
+	if len(missing) == 0 {
+		return nil, nil
+	}
+	return f, f.insert(missing, "imports")
+}
+
+// checkConfiguration returns the entries updateTsConfig, updatePackageImports and the Jest part of
+// updateTestRunnerConfig would add, without modifying any file.
+func checkConfiguration(root string) ([]configEntry, error) {
+	findings := []configEntry{}
+	files, err := planTsConfig(root)
+	if err != nil {
+		return nil, err
+	}
+	if projectConfig.Aliases == aliasesPaths {
+		f, err := planJestConfig(root)
+		if err != nil {
+			return nil, err
+		}
+		if f != nil {
+			files = append(files, f)
+		}
+	}
+	if projectConfig.Aliases == aliasesImports {
+		f, err := planPackageImports(root)
+		if err != nil {
+			return nil, err
+		}
+		if f != nil {
+			files = append(files, f)
+		}
+	}
+	for _, f := range files {
+		for _, finding := range f.added {
+			if rel, err := filepath.Rel(root, finding.File); err == nil {
+				finding.File = filepath.ToSlash(rel)
+			}
+			findings = append(findings, finding)
+		}
+	}
+	return findings, nil
+}
+
+// logFindings logs the findings of checkConfiguration and dependencyFindings.
+func logFindings(findings []configEntry, dependencies []dependencyFinding) {
+	for _, finding := range findings {
+		if finding.Key == "" {
+			log.Printf("%s: %s is missing", finding.File, finding.Entry)
+		} else {
+			log.Printf("%s: %s is missing in %s", finding.File, finding.Entry, finding.Key)
+		}
+	}
+	for _, finding := range dependencies {
+		log.Printf("%s: %s; install with: %s", finding.Package, finding.Problem, finding.Install)
+	}
+}
+
+// printCheck prints the findings of checkConfiguration and dependencyFindings as JSON to stdout,
+// logs them, and returns an error if there are any, so that CI fails with the entries to add.
+func printCheck(root string) error {
+	findings, err := checkConfiguration(root)
+	if err != nil {
+		return err
+	}
+	dependencies, err := dependencyFindings(root)
+	if err != nil {
+		return err
+	}
+	out, err := json.MarshalIndent(map[string]interface{}{"missing": findings, "dependencies": dependencies}, "", "  ")
+	if err != nil {
+		return err
+	}
+	os.Stdout.Write(append(out, '\n'))
+	logFindings(findings, dependencies)
+	if len(findings) > 0 || len(dependencies) > 0 {
+		return fmt.Errorf("%d configuration entries and %d dependencies are missing or do not fit; run encore-restate-gen without -check to fix them", len(findings), len(dependencies))
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultInstallTimeout bounds a single install attempt unless install.timeoutMs is configured.
+const defaultInstallTimeout = 5 * time.Minute
+
+// defaultInstallRetries is how often an install failing with a transient network error is
+// retried unless install.retries is configured.
+const defaultInstallRetries = 2
+
+// installContext is cancelled on shutdown, which kills running installs.
+var installContext, cancelInstalls = context.WithCancel(context.Background())
+
+// runningInstalls tracks the package manager processes, so shutdown can wait for them to be killed.
+var runningInstalls sync.WaitGroup
+
+var errInstallCancelled = errors.New("install cancelled")
+
+// installTimeout returns the configured timeout of a single install attempt.
+func installTimeout() time.Duration {
+	if projectConfig.Install.TimeoutMs > 0 {
+		return time.Duration(projectConfig.Install.TimeoutMs) * time.Millisecond
+	}
+	return defaultInstallTimeout
+}
+
+// installRetries returns the configured number of retries of a transient install failure.
+func installRetries() int {
+	if projectConfig.Install.Retries != nil {
+		return *projectConfig.Install.Retries
+	}
+	return defaultInstallRetries
+}
+
+// runInstall adds packages in dir with the project's package manager, logging its output. Each
+// attempt is killed after installTimeout, and failures caused by the network or the registry's
+// servers are retried with a growing delay.
+func runInstall(dir string, packages []string, dev bool) error {
+	registry := registryFor(dir, "@restatedev")
+	log.Printf("Installing from %s", registry)
+	retries := installRetries()
+	for attempt := 0; ; attempt++ {
+		output, err := installOnce(dir, packages, dev)
+		if err == nil || err == errInstallCancelled {
+			return err
+		}
+		if _, transient := installFailure(output); !transient || attempt >= retries {
+			return diagnoseInstall(err, output, registry)
+		}
+		delay := time.Duration(2<<attempt) * time.Second
+		log.Printf("Installing %v failed with a transient error, retrying in %v (%d of %d)", packages, delay, attempt+1, retries)
+		select {
+		case <-time.After(delay):
+		case <-installContext.Done():
+			return errInstallCancelled
+		}
+	}
+}
+
+// installOnce runs the add command for packages in dir once and returns its output.
+func installOnce(dir string, packages []string, dev bool) (string, error) {
+	cmd, err := addCommand(packages, dev)
+	if err != nil {
+		return "", err
+	}
+	output := &tailBuffer{max: 64 << 10}
+	progress := io.MultiWriter(&lineLogger{prefix: globalPackageManager + ": "}, output)
+	cmd.Dir = dir
+	cmd.Stdout, cmd.Stderr = progress, progress
+	setProcessGroup(cmd)
+	ctx, cancel := context.WithTimeout(installContext, installTimeout())
+	defer cancel()
+	runningInstalls.Add(1)
+	defer runningInstalls.Done()
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		return output.String(), err
+	case <-ctx.Done():
+		killProcessGroup(cmd)
+		<-done
+		if installContext.Err() != nil {
+			return output.String(), errInstallCancelled
+		}
+		return output.String(), fmt.Errorf("%s timed out after %v", strings.Join(cmd.Args, " "), installTimeout())
+	}
+}
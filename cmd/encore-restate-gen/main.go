@@ -3,16 +3,21 @@ package main
 import (
 	"embed"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io/fs"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"sort"
 	"strings"
 	"sync"
+	"syscall"
 	"text/template"
 	"time"
 
@@ -25,6 +30,11 @@ var (
 	restatedModulesInstalled bool
 	restatedDepsMutex        sync.Mutex
 	projectRoot              string
+	projectConfig            Config
+
+	// Service directories whose last extraction failed, with the failure.
+	erroredDirs      = make(map[string]*dirFailure)
+	erroredDirsMutex sync.Mutex
 
 	// Store generated TemplateData per service directory.
 	generatedDataMap      = make(map[string]TemplateData)
@@ -43,12 +53,20 @@ func detectPackageManager(dir string) string {
 	if _, err := os.Stat(filepath.Join(dir, "pnpm-lock.yaml")); err == nil {
 		return "pnpm"
 	}
+	for _, lockfile := range []string{"bun.lock", "bun.lockb"} {
+		if _, err := os.Stat(filepath.Join(dir, lockfile)); err == nil {
+			return "bun"
+		}
+	}
 	// Default to npm.
 	return "npm"
 }
 
 // checkRestateModules reads the project's package.json (in dir) and returns true if all
-// three required ReState packages are present (either in dependencies or devDependencies).
+// three required ReState packages are present: declared in dependencies or devDependencies, or
+// resolvable from dir like Node resolves them, e.g. hoisted to the node_modules of a monorepo root
+// while declared by another workspace package. Declared packages that cannot be resolved are
+// logged, as the project's dependencies need to be installed rather than added again.
 func checkRestateModules(dir string) (bool, error) {
 	pkgPath := filepath.Join(dir, "package.json")
 	data, err := ioutil.ReadFile(pkgPath)
@@ -62,71 +80,228 @@ func checkRestateModules(dir string) (bool, error) {
 	if err := json.Unmarshal(data, &pkg); err != nil {
 		return false, err
 	}
-	required := []string{
-		"@restatedev/restate-sdk",
-		"@restatedev/restate-sdk-clients",
-		"@restatedev/restate-sdk-core",
+	var unresolved []string
+	for _, dep := range restateModules {
+		_, ok := pkg.Dependencies[dep]
+		_, devOK := pkg.DevDependencies[dep]
+		resolved := installedPackageVersion(dir, dep) != ""
+		if !ok && !devOK && !resolved {
+			return false, nil
+		}
+		if !resolved {
+			unresolved = append(unresolved, dep)
+		}
+	}
+	if len(unresolved) > 0 && !plugAndPlay(dir) {
+		log.Printf("Warning: %v are declared in package.json but not installed; run %s install", unresolved, globalPackageManager)
+	}
+	return true, nil
+}
+
+// installedPackageVersion returns the version of the named package installed in the node_modules
+// of dir or of one of its parents, the first one Node would resolve it from, or an empty string
+// if it is not installed.
+func installedPackageVersion(dir, name string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
 	}
-	for _, dep := range required {
-		if _, ok := pkg.Dependencies[dep]; !ok {
-			if _, ok2 := pkg.DevDependencies[dep]; !ok2 {
-				return false, nil
+	for {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "node_modules", filepath.FromSlash(name), "package.json"))
+		if err == nil {
+			var pkg struct {
+				Version string `json:"version"`
+			}
+			if err := json.Unmarshal(data, &pkg); err != nil {
+				return ""
 			}
+			return pkg.Version
 		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
 	}
-	return true, nil
 }
 
-// installRestateModules installs any missing ReState modules using the detected package manager.
-func installRestateModules(dir string) error {
-	pkgPath := filepath.Join(dir, "package.json")
-	data, err := ioutil.ReadFile(pkgPath)
+// plugAndPlay reports whether dir belongs to a Yarn Plug'n'Play install, which has no node_modules
+// to resolve packages from.
+func plugAndPlay(dir string) bool {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	for {
+		for _, name := range []string{".pnp.cjs", ".pnp.js"} {
+			if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+				return true
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return false
+		}
+		dir = parent
+	}
+}
+
+// addCommand returns the command adding packages to the project's dependencies, or its
+// devDependencies if dev is set, with the detected package manager. At the root of a pnpm or Yarn
+// workspace, it adds them to the root package, and with install.exact, it pins them, or
+// with install.prefix, saves them with that range prefix.
+func addCommand(packages []string, dev bool) (*exec.Cmd, error) {
+	var args []string
+	switch globalPackageManager {
+	case "yarn", "bun":
+		args = []string{"add"}
+		if dev {
+			args = append(args, "--dev")
+		}
+		if projectConfig.Install.Exact {
+			args = append(args, "--exact")
+		}
+		if projectConfig.Install.Prefix == "~" {
+			if globalPackageManager == "bun" {
+				log.Printf("Warning: bun cannot add packages with a ~ prefix; install.prefix is ignored")
+			} else {
+				args = append(args, "--tilde")
+			}
+		}
+		if globalPackageManager == "yarn" && isYarnClassicWorkspace(projectRoot) {
+			args = append(args, "--ignore-workspace-root-check")
+		}
+	case "pnpm":
+		args = []string{"add"}
+		if dev {
+			args = append(args, "--save-dev")
+		}
+		if projectConfig.Install.Exact {
+			args = append(args, "--save-exact")
+		}
+		if projectConfig.Install.Prefix != "" {
+			args = append(args, "--save-prefix="+projectConfig.Install.Prefix)
+		}
+		if _, err := os.Stat(filepath.Join(projectRoot, "pnpm-workspace.yaml")); err == nil {
+			args = append(args, "--workspace-root")
+		}
+	case "npm":
+		args = []string{"install"}
+		if dev {
+			args = append(args, "--save-dev")
+		}
+		if projectConfig.Install.Exact {
+			args = append(args, "--save-exact")
+		}
+		if projectConfig.Install.Prefix != "" {
+			args = append(args, "--save-prefix="+projectConfig.Install.Prefix)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported package manager: %s", globalPackageManager)
+	}
+	return exec.Command(globalPackageManager, append(args, packages...)...), nil
+}
+
+// isYarnClassicWorkspace reports whether dir is the root of a Yarn 1 workspace, where adding
+// packages needs --ignore-workspace-root-check. Later Yarn versions are configured in .yarnrc.yml.
+func isYarnClassicWorkspace(dir string) bool {
+	if _, err := os.Stat(filepath.Join(dir, ".yarnrc.yml")); err == nil {
+		return false
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "package.json"))
 	if err != nil {
+		return false
+	}
+	var pkg struct {
+		Workspaces json.RawMessage `json:"workspaces"`
+	}
+	return json.Unmarshal(data, &pkg) == nil && len(pkg.Workspaces) > 0
+}
+
+// lockfiles are the lockfiles of each package manager.
+var lockfiles = map[string][]string{
+	"npm":  {"package-lock.json", "npm-shrinkwrap.json"},
+	"yarn": {"yarn.lock"},
+	"pnpm": {"pnpm-lock.yaml"},
+	"bun":  {"bun.lock", "bun.lockb"},
+}
+
+// runAdd adds packages like addCommand, in dir. Adding packages rewrites the project's lockfile,
+// which is warned about, so the change is committed rather than left as a dirty diff.
+func runAdd(dir string, packages []string, dev bool) error {
+	if _, err := addCommand(packages, dev); err != nil {
 		return err
 	}
+	for _, name := range lockfiles[globalPackageManager] {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			continue
+		}
+		if os.Getenv("CI") != "" {
+			log.Printf("Warning: adding %v in CI rewrites %s, which then no longer matches the repository; add them and commit %s locally, or run with -offline", packages, name, name)
+		} else {
+			log.Printf("Warning: adding %v rewrites %s; commit it together with package.json", packages, name)
+		}
+	}
+	// The package manager inherits the environment, including proxy and npm_config_ variables.
+	return runInstall(dir, packages, dev)
+}
+
+// restateModules are the Restate packages the generated code imports.
+var restateModules = []string{
+	"@restatedev/restate-sdk",
+	"@restatedev/restate-sdk-clients",
+	"@restatedev/restate-sdk-core",
+}
+
+// missingRestateModules returns the restateModules dir's package.json does not declare and that
+// cannot be resolved from dir either, and whether they belong in devDependencies: as configured by
+// install.dev, or else next to the Restate modules already declared there.
+func missingRestateModules(dir string) (missing []string, dev bool, err error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "package.json"))
+	if err != nil {
+		return nil, false, err
+	}
 	var pkg struct {
 		Dependencies    map[string]string `json:"dependencies"`
 		DevDependencies map[string]string `json:"devDependencies"`
 	}
 	if err := json.Unmarshal(data, &pkg); err != nil {
-		return err
+		return nil, false, err
 	}
-	required := []string{
-		"@restatedev/restate-sdk",
-		"@restatedev/restate-sdk-clients",
-		"@restatedev/restate-sdk-core",
+	for _, dep := range restateModules {
+		_, ok := pkg.Dependencies[dep]
+		_, devOK := pkg.DevDependencies[dep]
+		if !ok && !devOK && installedPackageVersion(dir, dep) == "" {
+			missing = append(missing, dep)
+		}
 	}
-	missing := []string{}
-	for _, dep := range required {
-		if _, ok := pkg.Dependencies[dep]; !ok {
-			if _, ok2 := pkg.DevDependencies[dep]; !ok2 {
-				missing = append(missing, dep)
-			}
+	for _, dep := range restateModules {
+		if _, ok := pkg.DevDependencies[dep]; ok {
+			dev = true
+		}
+		if _, ok := pkg.Dependencies[dep]; ok {
+			dev = false
+			break
 		}
 	}
-	if len(missing) == 0 {
-		return nil
+	if projectConfig.Install.Dev != nil {
+		dev = *projectConfig.Install.Dev
 	}
+	return missing, dev, nil
+}
 
-	var cmd *exec.Cmd
-	switch globalPackageManager {
-	case "yarn":
-		args := append([]string{"add"}, missing...)
-		cmd = exec.Command("yarn", args...)
-	case "pnpm":
-		args := append([]string{"add"}, missing...)
-		cmd = exec.Command("pnpm", args...)
-	case "npm":
-		args := append([]string{"install"}, missing...)
-		cmd = exec.Command("npm", args...)
-	default:
-		return fmt.Errorf("unsupported package manager: %s", globalPackageManager)
+// installRestateModules installs any missing ReState modules using the detected package manager.
+func installRestateModules(dir string) error {
+	missing, dev, err := missingRestateModules(dir)
+	if err != nil || len(missing) == 0 {
+		return err
 	}
-	cmd.Dir = dir
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
 	log.Printf("Installing missing dependencies: %v", missing)
-	return cmd.Run()
+	if err := runAdd(dir, missing, dev); err != nil {
+		return err
+	}
+	recordModifications(dir, nil, missing)
+	return nil
 }
 
 // ensureRestateModulesInstalled checks if the required modules are installed in dir.
@@ -143,7 +318,15 @@ func ensureRestateModulesInstalled(dir string) error {
 	}
 	if installed {
 		restatedModulesInstalled = true
-		return nil
+		return ensurePeerPackages(dir)
+	}
+	if offline {
+		// Reported once per change of the dependencies, see recheckDependencies.
+		restatedModulesInstalled = true
+		if err := reportDependencies(dir); err != nil {
+			return err
+		}
+		return ensurePeerPackages(dir)
 	}
 	log.Printf("Required ReState modules are not installed. Installing using %s...", globalPackageManager)
 	if err := installRestateModules(dir); err != nil {
@@ -159,94 +342,92 @@ func ensureRestateModulesInstalled(dir string) error {
 	}
 	restatedModulesInstalled = true
 	log.Printf("ReState modules installed successfully.")
-	return nil
+	restartEncore("the Restate modules were installed")
+	return ensurePeerPackages(dir)
 }
 
-// updateTsConfig updates the tsconfig.json file.
-func updateTsConfig(root string) error {
-	tsconfigPath := filepath.Join(root, "tsconfig.json")
-	data, err := ioutil.ReadFile(tsconfigPath)
-	if err != nil {
-		return err
+// dependencyFiles are the project root files whose changes may add or remove ReState modules.
+var dependencyFiles = []string{"package.json", "package-lock.json", "yarn.lock", "pnpm-lock.yaml", "bun.lock", "bun.lockb"}
+
+// isDependencyFile reports whether path is one of the project's dependencyFiles.
+func isDependencyFile(path string) bool {
+	if filepath.Dir(path) != projectRoot {
+		return false
+	}
+	for _, name := range dependencyFiles {
+		if filepath.Base(path) == name {
+			return true
+		}
 	}
-	content := string(data)
+	return false
+}
 
-	// If the file already contains the required entries, do nothing.
-	if strings.Contains(content, "\"~restate\"") &&
-		strings.Contains(content, "\"~restate/*\"") &&
-		strings.Contains(content, "\"**/*.ts\"") &&
-		strings.Contains(content, "\"./**/*.ts\"") &&
-		strings.Contains(content, "\"./restate.gen/**/*.ts\"") {
-		return nil
+// cancelDebounce cancels the pending function of key, if any.
+func cancelDebounce(key string) {
+	debounceMutex.Lock()
+	defer debounceMutex.Unlock()
+	if timer, exists := debounceMap[key]; exists {
+		timer.Stop()
+		delete(debounceMap, key)
 	}
+}
 
-	// Patch the "compilerOptions.paths" block.
-	pathsRe := regexp.MustCompile(`("paths"\s*:\s*\{)([\s\S]*?)(\s*\})`)
-	content = pathsRe.ReplaceAllStringFunc(content, func(match string) string {
-		submatches := pathsRe.FindStringSubmatch(match)
-		if len(submatches) < 4 {
-			return match
-		}
-		prefix := submatches[1]
-		body := submatches[2]
-		suffix := submatches[3]
-		if !strings.Contains(body, "\"~restate\"") {
-			body = strings.TrimRight(body, " \n\r\t")
-			body = strings.TrimRight(body, ",")
-			if body != "" {
-				body += ","
+// recheckDependencies re-runs the ReState module check and installation once the dependency files
+// stop changing, e.g. after a lockfile revert dropped the modules.
+func recheckDependencies() {
+	debounce("dependencies", 500*time.Millisecond, func() {
+		restatedDepsMutex.Lock()
+		restatedModulesInstalled = false
+		globalPackageManager = detectPackageManager(projectRoot)
+		restatedDepsMutex.Unlock()
+		if err := ensureRestateModulesInstalled(projectRoot); err != nil {
+			log.Printf("Error ensuring ReState modules installed: %v", err)
+		}
+		if err := checkSdkCompatibility(projectRoot); err != nil {
+			log.Printf("Error checking the Restate SDK version: %v", err)
+		}
+		if projectConfig.Aliases == aliasesImports {
+			if err := updatePackageImports(projectRoot); err != nil {
+				log.Printf("Error updating package.json: %v", err)
 			}
-			body += "\n      \"~restate\": [\"./restate.gen/index.ts\"],\n      \"~restate/*\": [\"./restate.gen/*\"]"
 		}
-		body = strings.TrimRight(body, "\n")
-		return prefix + body + suffix
 	})
+}
 
-	// Patch the "include" array.
-	includeRe := regexp.MustCompile(`("include"\s*:\s*\[)([\s\S]*?)(\s*\])`)
-	if includeRe.MatchString(content) {
-		content = includeRe.ReplaceAllStringFunc(content, func(match string) string {
-			submatches := includeRe.FindStringSubmatch(match)
-			if len(submatches) < 4 {
-				return match
+// reapplyTsConfig re-applies the required tsconfig.json entries once the file stops changing, in
+// case a formatter or another generator dropped them.
+func reapplyTsConfig() {
+	debounce("tsconfig", 500*time.Millisecond, func() {
+		if noTsconfig {
+			if err := checkTsConfig(projectRoot); err != nil {
+				log.Printf("Error: %v", err)
 			}
-			prefix := submatches[1]
-			body := strings.TrimSpace(submatches[2])
-			suffix := submatches[3]
-			var elements []string
-			if body != "" {
-				for _, elem := range strings.Split(body, ",") {
-					elem = strings.TrimSpace(elem)
-					if elem != "" {
-						elements = append(elements, elem)
-					}
-				}
-			}
-			required := []string{`"**/*.ts"`, `"./**/*.ts"`, `"./restate.gen/**/*.ts"`}
-			for _, req := range required {
-				found := false
-				for _, elem := range elements {
-					if elem == req {
-						found = true
-						break
-					}
-				}
-				if !found {
-					elements = append(elements, req)
-				}
-			}
-			newBody := "\n    " + strings.Join(elements, ",\n    ") + "\n"
-			return prefix + newBody + suffix
-		})
-	} else {
-		content = strings.TrimRight(content, " \n\r\t")
-		if strings.HasSuffix(content, "}") {
-			content = content[:len(content)-1] + ",\n  \"include\": [\n    \"**/*.ts\",\n    \"./**/*.ts\",\n    \"./restate.gen/**/*.ts\"\n  ]\n}"
+			return
 		}
-	}
+		if err := updateTsConfig(projectRoot); err != nil {
+			log.Printf("Error updating tsconfig.json: %v", err)
+		}
+	})
+}
 
-	content = strings.ReplaceAll(content, "}\n,", "},")
-	return ioutil.WriteFile(tsconfigPath, []byte(content), 0644)
+// noTsconfig is set by -no-tsconfig or the noTsconfig option: tsconfig.json is only checked for
+// the ~restate aliases, never rewritten.
+var noTsconfig bool
+
+// debounce runs fn after delay, unless debounce is called with the same key before; project-wide
+// tasks use names as keys, service directories their path.
+func debounce(key string, delay time.Duration, fn func()) {
+	debounceMutex.Lock()
+	defer debounceMutex.Unlock()
+	if timer, exists := debounceMap[key]; exists {
+		timer.Stop()
+	}
+	debounceMap[key] = time.AfterFunc(delay, func() {
+		debounceMutex.Lock()
+		delete(debounceMap, key)
+		debounceMutex.Unlock()
+		fn()
+	})
 }
 
 // HandlerEntry holds information about an exported handler.
@@ -254,12 +435,98 @@ type HandlerEntry struct {
 	ExportName string `json:"exportName"` // e.g. "greetHandler"
 	Source     string `json:"source"`     // e.g. "./greeter"
 	Type       string `json:"type"`       // "service", "workflow", or "virtualObject"
+
+	// KeyType is the inferred key type of a virtual object handler, e.g. "UserId".
+	KeyType       string      `json:"keyType,omitempty"`
+	KeyTypeImport *TypeImport `json:"keyTypeImport,omitempty"`
+	// Doc is the handler's leading JSDoc text, without delimiters.
+	Doc string `json:"doc,omitempty"`
+	// InputSchema and OutputSchema are JSON schemas of the request and response types, if extractable.
+	InputSchema  json.RawMessage `json:"inputSchema,omitempty"`
+	OutputSchema json.RawMessage `json:"outputSchema,omitempty"`
+	// ClassName is set for handlers defined as methods of an exported class; ExportName is then
+	// the method name.
+	ClassName string `json:"className,omitempty"`
+	Static    bool   `json:"static,omitempty"`
+	// Default marks the default export. The extractor reports its name as "default";
+	// processDirectory replaces it with a name synthesized from the file name.
+	Default bool `json:"default,omitempty"`
+	// ImportName is set for handlers re-exported under an alias, e.g. export { internalCharge as
+	// charge } from "./impl": it is the name declared in Source, which then is the declaring module.
+	ImportName string `json:"importName,omitempty"`
+}
+
+// importName returns the name imported from the handler's source file.
+func (h HandlerEntry) importName() string {
+	switch {
+	case h.ClassName != "":
+		return h.ClassName
+	case h.ImportName != "":
+		return h.ImportName
+	}
+	return h.ExportName
+}
+
+// localName returns the name the handler is imported as, without the "__" prefix.
+func (h HandlerEntry) localName() string {
+	if h.ClassName != "" {
+		return h.ClassName
+	}
+	return h.ExportName
+}
+
+// Ref returns the expression referring to the imported handler in the generated file. Instance
+// methods are bound to the shared instance of their class, see TemplateData.Instances.
+func (h HandlerEntry) Ref() string {
+	switch {
+	case h.ClassName == "":
+		return "__" + h.ExportName
+	case h.Static:
+		return fmt.Sprintf("__%s.%s", h.ClassName, h.ExportName)
+	default:
+		return fmt.Sprintf("__%sInstance.%s.bind(__%sInstance)", h.ClassName, h.ExportName, h.ClassName)
+	}
+}
+
+// TypeImport describes a named type import, i.e. import type { Name as Alias } from "Source".
+type TypeImport struct {
+	Name   string `json:"name"`
+	Alias  string `json:"alias"`
+	Source string `json:"source"`
+}
+
+// DefinitionEntry is an explicit, exported restate.service/object/workflow({ name, handlers })
+// definition. It is bound as is, next to the definitions inferred from HandlerEntry values.
+type DefinitionEntry struct {
+	ExportName string   `json:"exportName"` // e.g. "billing"
+	Source     string   `json:"source"`     // e.g. "./billing"
+	Type       string   `json:"type"`       // "service", "workflow", or "virtualObject"
+	Name       string   `json:"name"`       // the Restate name, e.g. "Billing"
+	Handlers   []string `json:"handlers"`
+}
+
+// EndpointName returns the name of the Encore endpoint invoking handler, e.g. "billingCharge".
+func (d DefinitionEntry) EndpointName(handler string) string {
+	name := nonIdentifierRe.ReplaceAllString(handler, "")
+	if name == "" {
+		return lowerFirst(d.Name)
+	}
+	return lowerFirst(d.Name) + strings.ToUpper(name[:1]) + name[1:]
 }
 
+var nonIdentifierRe = regexp.MustCompile(`[^A-Za-z0-9_$]`)
+
+// manifestSchemaVersion is the manifest format this binary understands. The extraction bundle
+// embeds the same number; bump both whenever the format changes.
+const manifestSchemaVersion = 1
+
 // Manifest is the output of the Node parser.
 type Manifest struct {
-	ServiceName string         `json:"serviceName"`
-	Handlers    []HandlerEntry `json:"handlers"`
+	SchemaVersion int               `json:"schemaVersion"`
+	ServiceName   string            `json:"serviceName"`
+	Handlers      []HandlerEntry    `json:"handlers"`
+	Definitions   []DefinitionEntry `json:"definitions,omitempty"`
+	Diagnostics   []Diagnostic      `json:"diagnostics,omitempty"`
 }
 
 // GroupedHandler groups handler entries by their Source.
@@ -277,34 +544,121 @@ type TemplateData struct {
 	WorkflowGroup      []GroupedHandler
 	VirtualObjectGroup []GroupedHandler
 	FilePath           string
+	// ObjectKeyType is the key type of the typed object client helpers. It refers to
+	// ObjectKeyImport, when set, which is imported as __ObjectKey.
+	ObjectKeyType   string
+	ObjectKeyImport *TypeImport
+	// Definitions are the explicit definitions found in the service.
+	Definitions []DefinitionEntry
+}
+
+// EndpointHandler returns the Restate SDK handler the generated endpoint wraps, "fetch" or "lambda".
+func (d TemplateData) EndpointHandler() string {
+	return projectConfig.EndpointHandler
+}
+
+// VerifyIdentity reports whether the generated endpoint verifies that requests are signed by Restate.
+func (d TemplateData) VerifyIdentity() bool {
+	return projectConfig.Identity != nil
+}
+
+// groups returns the handler groups of all categories.
+func (d TemplateData) groups() []GroupedHandler {
+	var groups []GroupedHandler
+	groups = append(groups, d.ServiceGroup...)
+	groups = append(groups, d.WorkflowGroup...)
+	return append(groups, d.VirtualObjectGroup...)
+}
+
+// Imports returns the names to import per source file, each imported once even if a class
+// defines handlers of several categories.
+func (d TemplateData) Imports() []GroupedImport {
+	names := make(map[string][]ImportedName)
+	defaults := make(map[string]string)
+	seen := make(map[string]bool)
+	add := func(source, name, local string) {
+		key := source + "\x00" + name + "\x00" + local
+		if !seen[key] {
+			seen[key] = true
+			names[source] = append(names[source], ImportedName{Name: name, Local: local})
+		}
+	}
+	for _, g := range d.groups() {
+		for _, h := range g.Handlers {
+			if h.Default && h.ImportName == "" {
+				defaults[g.Source] = h.localName()
+				continue
+			}
+			add(g.Source, h.importName(), h.localName())
+		}
+	}
+	for _, def := range d.Definitions {
+		add(def.Source, def.ExportName, def.ExportName)
+	}
+	for source := range defaults {
+		if _, ok := names[source]; !ok {
+			names[source] = nil
+		}
+	}
+	var imports []GroupedImport
+	for source, n := range names {
+		imports = append(imports, GroupedImport{Source: source, Default: defaults[source], Names: n})
+	}
+	sort.Slice(imports, func(i, j int) bool { return imports[i].Source < imports[j].Source })
+	return imports
+}
+
+// Instances returns the classes whose instance methods are handlers.
+func (d TemplateData) Instances() []string {
+	var classes []string
+	seen := make(map[string]bool)
+	for _, g := range d.groups() {
+		for _, h := range g.Handlers {
+			if h.ClassName != "" && !h.Static && !seen[h.ClassName] {
+				seen[h.ClassName] = true
+				classes = append(classes, h.ClassName)
+			}
+		}
+	}
+	return classes
+}
+
+// GroupedImport lists the names imported from one source file. Default, if set, is the local name
+// of the default import.
+type GroupedImport struct {
+	Source  string
+	Default string
+	Names   []ImportedName
+}
+
+// ImportedName is a named import, i.e. import { Name as __Local }.
+type ImportedName struct {
+	Name  string
+	Local string
 }
 
 // Combined generated template.
 const combinedTemplate = `// This file is automatically generated by encore-restate-gen.
 // Do not edit this file directly.
 
-{{ if .ServiceGroup -}}
-{{- range .ServiceGroup }}
-import { {{- range $i, $h := .Handlers }}{{if $i}}, {{end}}{{ $h.ExportName }} as __{{ $h.ExportName }}{{ end }} } from "{{ .Source }}";
+{{- range .Imports }}
+import {{ with .Default }}__{{ . }}{{ end }}{{ if and .Default .Names }}, {{ end }}{{ if .Names }}{ {{- range $i, $n := .Names }}{{if $i}}, {{end}}{{ $n.Name }} as __{{ $n.Local }}{{ end }} }{{ end }} from "{{ .Source }}";
 {{- end }}
-{{ end }}
 
-{{ if .WorkflowGroup -}}
-{{- range .WorkflowGroup }}
-import { {{- range $i, $h := .Handlers }}{{if $i}}, {{end}}{{ $h.ExportName }} as __{{ $h.ExportName }}{{ end }} } from "{{ .Source }}";
+import { api{{ if .WorkflowAPI }}, APIError{{ end }} } from "encore.dev/api";
+import { endpoint } from "@restatedev/restate-sdk/{{ .EndpointHandler }}";
+import * as restate from "@restatedev/restate-sdk";
+{{- if .ResultCache }}
+import { SQLDatabase } from "encore.dev/storage/sqldb";
 {{- end }}
-{{ end }}
-
-{{ if .VirtualObjectGroup -}}
-{{- range .VirtualObjectGroup }}
-import { {{- range $i, $h := .Handlers }}{{if $i}}, {{end}}{{ $h.ExportName }} as __{{ $h.ExportName }}{{ end }} } from "{{ .Source }}";
+import { buildEncoreRestateHandler, buildRestateHealthHandler{{ if eq .EndpointHandler "lambda" }}, fetchFromLambda{{ end }}{{ if .VerifyIdentity }}, restateConfig{{ end }}{{ if .VirtualObjectGroup }}, objectClient, objectSendClient, type ClientOptions{{ end }}{{ if or .WorkflowAPI .ResultCache }}, getClient, workflowClient{{ end }}{{ if .WorkflowAPI }}, cancelWorkflow{{ end }} } from "{{ restateImport "" }}";
+{{- with .ObjectKeyImport }}
+import type { {{ .Name }} as __ObjectKey } from "{{ .Source }}";
 {{- end }}
-{{ end }}
+{{- range .Instances }}
 
-import { api } from "encore.dev/api";
-import { endpoint } from "@restatedev/restate-sdk/fetch";
-import * as restate from "@restatedev/restate-sdk";
-import { buildEncoreRestateHandler } from "~restate";
+const __{{ . }}Instance = new __{{ . }}();
+{{- end }}
 
 // Build objects for each category.
 {{ if .ServiceGroup -}}
@@ -313,7 +667,7 @@ export const _{{.ServiceNameTrimmed}}Service = restate.service({
   handlers: {
     {{- range .ServiceGroup }}
       {{- range .Handlers }}
-        {{ .ExportName }}: __{{ .ExportName }},
+{{ jsdoc "        " .Doc }}        {{ .ExportName }}: {{ .Ref }},
       {{- end }}
     {{- end }}
   },
@@ -326,7 +680,7 @@ export const _{{.ServiceNameTrimmed}}Workflow = restate.workflow({
   handlers: {
     {{- range .WorkflowGroup }}
       {{- range .Handlers }}
-        {{ .ExportName }}: __{{ .ExportName }},
+{{ jsdoc "        " .Doc }}        {{ .ExportName }}: {{ .Ref }},
       {{- end }}
     {{- end }}
   },
@@ -339,7 +693,7 @@ export const _{{.ServiceNameTrimmed}}Object = restate.object({
   handlers: {
     {{- range .VirtualObjectGroup }}
       {{- range .Handlers }}
-        {{ .ExportName }}: __{{ .ExportName }},
+{{ jsdoc "        " .Doc }}        {{ .ExportName }}: {{ .Ref }},
       {{- end }}
     {{- end }}
   },
@@ -351,13 +705,24 @@ const restateEndpoint = endpoint();
 {{ if .ServiceGroup }} restateEndpoint.bind(_{{.ServiceNameTrimmed}}Service); {{ end }}
 {{ if .WorkflowGroup }} restateEndpoint.bind(_{{.ServiceNameTrimmed}}Workflow); {{ end }}
 {{ if .VirtualObjectGroup }} restateEndpoint.bind(_{{.ServiceNameTrimmed}}Object); {{ end }}
+{{- range .Definitions }}
+restateEndpoint.bind(__{{ .ExportName }});
+{{- end }}
+{{- if .VerifyIdentity }}
+// Reject requests not signed by one of the configured Restate servers; without keys, reject all.
+const identityKeys = restateConfig.identityKeys();
+restateEndpoint.withIdentityV1(...identityKeys);
+{{- end }}
+
+// The request handler of the Restate endpoint, as provided by the installed SDK.
+const restateHandler = restateEndpoint.handler(){{ if ne .EndpointHandler "lambda" }}.fetch{{ end }};
 
 // Build common endpoint handler.
-export const handler = buildEncoreRestateHandler(restateEndpoint.handler().fetch);
+export const handler = buildEncoreRestateHandler({{ if .VerifyIdentity }}identityKeys.length === 0 ? async () => new Response("No Restate identity keys are configured", { status: 401 }) : {{ end }}{{ if eq .EndpointHandler "lambda" }}fetchFromLambda(restateHandler){{ else }}restateHandler{{ end }});
 
 {{- range .ServiceGroup }}
   {{- range .Handlers }}
-export const {{.ExportName}} = api.raw(
+{{ jsdoc "" .Doc }}export const {{.ExportName}} = api.raw(
   { expose: false, path: '/{{$.ServiceName}}/invoke/{{$.ServiceNameTrimmed}}Service/{{.ExportName}}', method: "POST" },
   handler,
 );
@@ -366,7 +731,7 @@ export const {{.ExportName}} = api.raw(
 
 {{- range .WorkflowGroup }}
   {{- range .Handlers }}
-export const {{.ExportName}} = api.raw(
+{{ jsdoc "" .Doc }}export const {{.ExportName}} = api.raw(
   { expose: false, path: '/{{$.ServiceName}}/invoke/{{$.ServiceNameTrimmed}}Workflow/{{.ExportName}}', method: "POST" },
   handler,
 );
@@ -375,18 +740,213 @@ export const {{.ExportName}} = api.raw(
 
 {{- range .VirtualObjectGroup }}
   {{- range .Handlers }}
-export const {{.ExportName}} = api.raw(
+{{ jsdoc "" .Doc }}export const {{.ExportName}} = api.raw(
   { expose: false, path: '/{{$.ServiceName}}/invoke/{{$.ServiceNameTrimmed}}Object/{{.ExportName}}', method: "POST" },
   handler,
 );
   {{- end }}
 {{- end }}
 
+{{- range $d := .Definitions }}
+  {{- range .Handlers }}
+export const {{ $d.EndpointName . }} = api.raw(
+  { expose: false, path: '/{{$.ServiceName}}/invoke/{{$d.Name}}/{{.}}', method: "POST" },
+  handler,
+);
+  {{- end }}
+{{- end }}
+
 export const discover = api.raw(
   { expose: false, path: '/{{.ServiceName}}/discover', method: "GET" },
   handler,
 );
 
+export const restateHealth = api.raw(
+  { expose: true, path: '/{{.ServiceName}}/restate/health', method: "GET" },
+  buildRestateHealthHandler("{{.ServiceName}}", restateHandler),
+);
+
+{{ with .ResultCache }}
+// The results of {{ .Workflow }} runs, cached for fast reads next to their authoritative state in Restate.
+const __resultDb = new SQLDatabase("{{ .Database }}", { migrations: "./{{ .Migrations }}" });
+
+export type {{ .Workflow }}Result = {
+  key: string;
+  status: "running" | "succeeded" | "failed";
+  output?: {{ .Output }};
+  error?: string;
+  updatedAt: Date;
+};
+
+// Stores the state of the run with the key in the result cache.
+const __storeResult = async (
+  key: string,
+  status: {{ .Workflow }}Result["status"],
+  output?: unknown,
+  error?: string,
+): Promise<{{ .Workflow }}Result> => {
+  const row = await __resultDb.rawQueryRow(
+    "INSERT INTO workflow_results (key, status, output, error, updated_at) VALUES ($1, $2, $3, $4, now()) " +
+      "ON CONFLICT (key) DO UPDATE SET status = $2, output = $3, error = $4, updated_at = now() RETURNING updated_at",
+    key,
+    status,
+    output === undefined ? null : JSON.stringify(output),
+    error ?? null,
+  );
+  return { key, status, output: output as any, error, updatedAt: row?.updated_at ?? new Date() };
+};
+
+// Returns the status of an error Restate answered with, or undefined if Restate was not reached.
+const __restateStatus = (err: unknown) => (err as { status?: number } | undefined)?.status;
+
+// Reports whether err is the terminal failure of the run, which Restate answers with the 4xx code
+// of its terminal error. Unknown runs (404), and errors that may be transient, such as 5xx, 408 and
+// 429 answers or Restate not being reached, are not.
+const __runFailed = (err: unknown) => {
+  const status = __restateStatus(err);
+  return status !== undefined && status >= 400 && status < 500 && status !== 404 && status !== 408 && status !== 429;
+};
+
+/** Returns the cached result of the {{ .Workflow }} run with the key, or undefined if none is cached. */
+export async function cached{{ .Workflow }}Result(key: string): Promise<{{ .Workflow }}Result | undefined> {
+  const row = await __resultDb.rawQueryRow(
+    "SELECT status, output, error, updated_at FROM workflow_results WHERE key = $1",
+    key,
+  );
+  if (!row) {
+    return undefined;
+  }
+  return {
+    key,
+    status: row.status,
+    output: row.output == null ? undefined : JSON.parse(row.output),
+    error: row.error ?? undefined,
+    updatedAt: row.updated_at,
+  };
+}
+
+/** Caches the current state of the {{ .Workflow }} run with the key, as Restate reports it, and returns it. */
+export async function snapshot{{ .Workflow }}Result(key: string): Promise<{{ .Workflow }}Result> {
+  try {
+    const output = await workflowClient({{ .Workflow }}, key).workflowOutput();
+    return output.ready ? __storeResult(key, "succeeded", output.result) : __storeResult(key, "running");
+  } catch (err) {
+    if (!__runFailed(err)) {
+      throw err;
+    }
+    return __storeResult(key, "failed", undefined, String((err as Error)?.message ?? err));
+  }
+}
+
+/** Caches the {{ .Workflow }} run with the key as running, waits for it to end and caches its output or failure. */
+export async function track{{ .Workflow }}Result(key: string): Promise<{{ .Workflow }}Result> {
+  await __storeResult(key, "running");
+  try {
+    // Attaching waits for the run to end, so it is made without the configured call timeout.
+    const output = await getClient().workflowClient({{ .Workflow }}, key).workflowAttach();
+    return __storeResult(key, "succeeded", output);
+  } catch (err) {
+    if (__restateStatus(err) === 404) {
+      await __resultDb.rawExec("DELETE FROM workflow_results WHERE key = $1", key);
+    }
+    if (!__runFailed(err)) {
+      throw err;
+    }
+    return __storeResult(key, "failed", undefined, String((err as Error)?.message ?? err));
+  }
+}
+{{ end }}
+{{ with .WorkflowAPI }}
+// Public endpoints managing {{ .Workflow }}, so frontends do not call Restate directly.
+export type {{ .Workflow }}SubmitRequest = { key: string; input: {{ .Input }} };
+export type {{ .Workflow }}SubmitResponse = { key: string; invocationId: string; status: string };
+export type {{ .Workflow }}StatusResponse = { key: string; done: boolean; output?: {{ .Output }} };
+export type {{ .Workflow }}StreamMessage = { key: string; done: boolean; output?: {{ .Output }}; error?: string{{ if .Progress }}; progress?: {{ .ProgressType }}{{ end }} };
+
+// Maps a 404 of Restate, which does not know the workflow run, to an Encore not found error.
+const __workflowNotFound = (key: string) => (err: unknown) => {
+  if ((err as { status?: number } | undefined)?.status === 404) {
+    throw APIError.notFound("no {{ .Workflow }} run with key " + key);
+  }
+  throw err;
+};
+
+/** Starts {{ .Workflow }} with the key and input of the request; a key runs once. */
+export const submit{{ .Workflow }} = api(
+  { expose: true, auth: {{ .Auth }}, method: "POST", path: "{{ .Path }}" },
+  async (req: {{ .Workflow }}SubmitRequest): Promise<{{ .Workflow }}SubmitResponse> => {
+    const submission = await workflowClient({{ .Workflow }}, req.key).workflowSubmit(req.input as any);
+{{- if $.ResultCache }}
+    if (String(submission.status) === "Accepted") {
+      await __storeResult(req.key, "running");
+    }
+{{- end }}
+    return { key: req.key, invocationId: submission.invocationId, status: String(submission.status) };
+  },
+);
+
+/** Returns whether the {{ .Workflow }} run with the key is done, and its output once it is. */
+export const get{{ .Workflow }}Status = api(
+  { expose: true, auth: {{ .Auth }}, method: "GET", path: "{{ .Path }}/:key" },
+  async ({ key }: { key: string }): Promise<{{ .Workflow }}StatusResponse> => {
+{{- if $.ResultCache }}
+    // Finished runs are answered from the result cache.
+    const cached = await cached{{ .Workflow }}Result(key);
+    if (cached?.status === "succeeded") {
+      return { key, done: true, output: cached.output };
+    }
+{{- end }}
+    const output = await workflowClient({{ .Workflow }}, key).workflowOutput().catch(__workflowNotFound(key));
+{{- if $.ResultCache }}
+    if (output.ready) {
+      await __storeResult(key, "succeeded", output.result);
+    }
+{{- end }}
+    return output.ready ? { key, done: true, output: output.result as any } : { key, done: false };
+  },
+);
+
+/**
+ * Streams the state of the {{ .Workflow }} run with the key every 2 seconds{{ if .Progress }}, with the result of
+ * {{ .Progress }} as its progress{{ end }}, until a last message with its output or error.
+ */
+export const stream{{ .Workflow }} = api.streamOut<{ key: string }, {{ .Workflow }}StreamMessage>(
+  { expose: true, auth: {{ .Auth }}, path: "{{ .Path }}/:key/stream" },
+  async ({ key }, stream) => {
+    // Attaching waits for the run to end, so it is made without the configured call timeout.
+    let ended = false;
+    const end = getClient()
+      .workflowClient({{ .Workflow }}, key)
+      .workflowAttach()
+      .then(
+        (output): {{ .Workflow }}StreamMessage => ({ key, done: true, output: output as any }),
+        (err): {{ .Workflow }}StreamMessage => ({ key, done: true, error: String(err?.message ?? err) }),
+      )
+      .finally(() => {
+        ended = true;
+      });
+    while (!ended) {
+{{- if .Progress }}
+      const progress = await workflowClient({{ .Workflow }}, key).{{ .Progress }}().catch(() => undefined);
+      await stream.send({ key, done: false, progress: progress as any });
+{{- else }}
+      await stream.send({ key, done: false });
+{{- end }}
+      await Promise.race([end, new Promise(resolve => setTimeout(resolve, 2000))]);
+    }
+    await stream.send(await end);
+    await stream.close();
+  },
+);
+
+/** Cancels the {{ .Workflow }} run with the key. */
+export const cancel{{ .Workflow }} = api(
+  { expose: true, auth: {{ .Auth }}, method: "DELETE", path: "{{ .Path }}/:key/cancel" },
+  async ({ key }: { key: string }): Promise<void> => {
+    await cancelWorkflow("{{ .Workflow }}", key).catch(__workflowNotFound(key));
+  },
+);
+{{ end }}
 {{ if .ServiceGroup }}
 export const {{.ServiceNameTrimmed}}Service: typeof _{{.ServiceNameTrimmed}}Service = {
   name: "{{.ServiceNameTrimmed}}Service",
@@ -401,59 +961,751 @@ export const {{.ServiceNameTrimmed}}Workflow: typeof _{{.ServiceNameTrimmed}}Wor
 export const {{.ServiceNameTrimmed}}Object: typeof _{{.ServiceNameTrimmed}}Object = {
   name: "{{.ServiceNameTrimmed}}Object",
 };
+
+// Typed client helpers for {{.ServiceNameTrimmed}}Object.
+export const {{ lowerFirst .ServiceNameTrimmed }}Object = (key: {{ .ObjectKeyType }}, opts?: ClientOptions) =>
+  objectClient({{.ServiceNameTrimmed}}Object, String(key), opts);
+
+export const {{ lowerFirst .ServiceNameTrimmed }}ObjectSend = (key: {{ .ObjectKeyType }}, opts?: ClientOptions) =>
+  objectSendClient({{.ServiceNameTrimmed}}Object, String(key), opts);
+{{ end }}
+{{- range .Definitions }}
+export const {{ .Name }}: typeof __{{ .ExportName }} = {
+  name: "{{ .Name }}",
+};
 {{ end }}
 `
 
-// extractAssets extracts the embedded assets to a temporary directory.
-func extractAssets() (string, error) {
-	tempDir, err := ioutil.TempDir("", "assets_dist")
-	if err != nil {
-		return "", err
-	}
-	err = fs.WalkDir(assets, "assets_dist", func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-		relPath, err := filepath.Rel("assets_dist", path)
-		if err != nil {
-			return err
-		}
-		targetPath := filepath.Join(tempDir, relPath)
-		if d.IsDir() {
-			return os.MkdirAll(targetPath, 0755)
-		}
-		data, err := assets.ReadFile(path)
-		if err != nil {
-			return err
-		}
-		if err = os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
-			return err
-		}
-		return ioutil.WriteFile(targetPath, data, 0755)
-	})
-	if err != nil {
-		return "", err
-	}
-	return tempDir, nil
-}
+// Root index template, written to restate.gen/index.ts.
+const rootIndexTemplate = `// This file is automatically generated by encore-restate-gen.
+// Do not edit this file directly.
 
-// runNodeScript runs the Node extraction script and returns the manifest.
-func runNodeScript(dir string) (*Manifest, error) {
-	assetsDir, err := extractAssets()
+import { api as _api } from "encore.dev/api";
+import type { IncomingMessage, ServerResponse } from "node:http";
+import { existsSync, readFileSync } from "node:fs";
+import { dirname, join } from "node:path";
+{{- if .SharedSecret }}
+import { timingSafeEqual } from "node:crypto";
+{{- end }}
+import * as clients from "@restatedev/restate-sdk-clients";
+{{- if .Client.ForwardAuth }}
+import { getAuthData } from "~encore/auth";
+{{- end }}
+{{- if .Client.PropagateTrace }}
+import { currentRequest } from "encore.dev";
+{{- end }}
+import { restateConfig } from "./restate.config";
+import type {
+  Service,
+  VirtualObject,
+  ServiceDefinitionFrom,
+  VirtualObjectDefinitionFrom,
+  WorkflowDefinitionFrom,
+  Workflow,
+} from "@restatedev/restate-sdk-core";
+export * as services from "{{ restateImport "/services" }}";
+export * as workflows from "{{ restateImport "/workflows" }}";
+export * as objects from "{{ restateImport "/objects" }}";
+export { restateConfig } from "./restate.config";
+
+export type RetryOptions = {
+  maxAttempts?: number;
+  initialDelayMs?: number;
+  maxDelayMs?: number;
+};
+
+export type ClientOptions = {
+  // Per-call timeout in milliseconds. 0 disables the timeout.
+  timeoutMs?: number;
+  // Retries apply to network errors, timeouts, 429 and 5xx responses.
+  // Pass an idempotency key with calls that must not run twice.
+  retry?: RetryOptions;
+  // Static headers sent with every ingress request.
+  headers?: Record<string, string>;
+};
+
+// Defaults from encore-restate-gen.json.
+const defaultClientOptions: ClientOptions = {{ json .ClientDefaults }};
+let clientOptions: ClientOptions = defaultClientOptions;
+
+const mergeClientOptions = (base: ClientOptions, override?: ClientOptions): ClientOptions =>
+  override
+    ? {
+        ...base,
+        ...override,
+        retry: { ...base.retry, ...override.retry },
+        headers: { ...base.headers, ...override.headers },
+      }
+    : base;
+
+// Overrides the generated client defaults for the whole process.
+export const configureClient = (opts: ClientOptions) => {
+  clientOptions = mergeClientOptions(defaultClientOptions, opts);
+  cachedClient = undefined;
+};
+
+// Returns the bearer token of ingress calls. It is asked again after the ingress rejects a token.
+let authTokenProvider: () => string | undefined = restateConfig.authToken;
+
+// Replaces the source of the ingress bearer token, e.g. with one returning a refreshed token.
+export const setAuthTokenProvider = (provider: () => string | undefined) => {
+  authTokenProvider = provider;
+  cachedClient = undefined;
+};
+
+const authHeaders = (): Record<string, string> => {
+  const token = authTokenProvider();
+  return token ? { Authorization: "Bearer " + token } : {};
+};
+
+{{ if .Client.ForwardAuth -}}
+// Headers carrying the Encore auth data of the calling request into Restate invocations.
+export const authUserIdHeader = "x-encore-user-id";
+export const authDataHeader = "x-encore-auth-data";
+
+// Returns the headers forwarding the auth data of the Encore request being served, if any.
+const forwardedAuthHeaders = (): Record<string, string> => {
+  const auth = getAuthData() as { userID?: string } | null;
+  if (!auth) {
+    return {};
+  }
+  return {
+    [authUserIdHeader]: String(auth.userID ?? ""),
+    [authDataHeader]: Buffer.from(JSON.stringify(auth)).toString("base64"),
+  };
+};
+
+// The part of a Restate handler context the auth helpers read.
+type InvocationContext = { request(): { headers: ReadonlyMap<string, string> } };
+
+// Returns the Encore auth data forwarded to the invocation of ctx, or undefined if the call was
+// not made while serving an authenticated request.
+export const authData = <A = Record<string, unknown>>(ctx: InvocationContext): A | undefined => {
+  const encoded = ctx.request().headers.get(authDataHeader);
+  return encoded ? (JSON.parse(Buffer.from(encoded, "base64").toString("utf8")) as A) : undefined;
+};
+
+// Returns the Encore user ID forwarded to the invocation of ctx, or undefined.
+export const authUserId = (ctx: InvocationContext): string | undefined =>
+  ctx.request().headers.get(authUserIdHeader) || undefined;
+
+// Returns the auth headers of the invocation of ctx, to forward them on calls to other handlers:
+// ctx.serviceClient(svc).handler(input, restate.rpc.opts({ headers: forwardAuth(ctx) })).
+export const forwardAuth = (ctx: InvocationContext): Record<string, string> => {
+  const headers: Record<string, string> = {};
+  for (const name of [authUserIdHeader, authDataHeader]) {
+    const value = ctx.request().headers.get(name);
+    if (value) {
+      headers[name] = value;
+    }
+  }
+  return headers;
+};
+
+{{ end -}}
+{{ if .Client.PropagateTrace -}}
+// Returns id as the lowercase hex of an ID of the given bytes. Encore IDs are hex, or base32hex
+// without padding.
+const traceHex = (id: string | undefined, bytes: number): string | undefined => {
+  const s = id?.toLowerCase() ?? "";
+  if (s.length === bytes * 2 && /^[0-9a-f]+$/.test(s)) {
+    return s;
+  }
+  if (s.length !== Math.ceil((bytes * 8) / 5) || !/^[0-9a-v]+$/.test(s)) {
+    return undefined;
+  }
+  let hex = "";
+  let value = 0;
+  let bits = 0;
+  for (const c of s) {
+    value = (value << 5) | parseInt(c, 32);
+    bits += 5;
+    if (bits >= 8) {
+      bits -= 8;
+      hex += ((value >> bits) & 0xff).toString(16).padStart(2, "0");
+      value &= (1 << bits) - 1;
+    }
+  }
+  return hex;
+};
+
+// Returns the W3C traceparent header continuing the trace of the Encore request being served, if any.
+const traceHeaders = (): Record<string, string> => {
+  const trace = (currentRequest() as { trace?: { traceId?: string; spanId?: string } } | undefined)?.trace;
+  const traceId = traceHex(trace?.traceId, 16);
+  const spanId = traceHex(trace?.spanId, 8);
+  if (!traceId || !spanId || /^0+$/.test(traceId) || /^0+$/.test(spanId)) {
+    return {};
+  }
+  return { traceparent: "00-" + traceId + "-" + spanId + "-01" };
+};
+
+{{ end -}}
+const connect = (headers?: Record<string, string>) =>
+  clients.connect({ url: restateConfig.serverUrl(), headers: { ...authHeaders(), ...headers } });
+
+let cachedClient: ReturnType<typeof clients.connect> | undefined;
+
+// Replaces the ingress connection of the generated clients, e.g. with the fake of ~restate/testing.
+// undefined restores the connection to Restate.
+let ingressOverride: clients.Ingress | undefined;
+export const setIngress = (ingress: clients.Ingress | undefined) => {
+  ingressOverride = ingress;
+  cachedClient = undefined;
+};
+
+export const getClient = (opts?: ClientOptions): clients.Ingress => {
+  if (ingressOverride) {
+    return ingressOverride;
+  }
+{{- if .Client.ForwardAuth }}
+  // Calls made while serving an authenticated request forward its auth data.
+  const auth = forwardedAuthHeaders();
+  if (Object.keys(auth).length > 0) {
+    opts = { ...opts, headers: { ...opts?.headers, ...auth } };
+  }
+{{- end }}
+{{- if .Client.PropagateTrace }}
+  // Calls made while serving a traced request continue its trace.
+  const trace = traceHeaders();
+  if (trace.traceparent) {
+    opts = { ...opts, headers: { ...opts?.headers, ...trace } };
+  }
+{{- end }}
+  if (opts?.headers) {
+    return connect(mergeClientOptions(clientOptions, opts).headers);
+  }
+  if (!cachedClient) {
+    cachedClient = connect(clientOptions.headers);
+  }
+  return cachedClient;
+};
+
+const statusOf = (err: unknown) => (err as { status?: number } | undefined)?.status;
+
+// Codes of network errors raised before a request was sent, so Restate never saw the call.
+const notSentCodes = ["ECONNREFUSED", "ENOTFOUND", "EAI_AGAIN"];
+
+const wasNotSent = (err: unknown) => {
+  const e = err as { code?: string; cause?: { code?: string } } | undefined;
+  return notSentCodes.includes(e?.code ?? "") || notSentCodes.includes(e?.cause?.code ?? "");
+};
+
+// A call timed out; it is not retried, as its invocation may still be running in Restate.
+class IngressTimeoutError extends Error {}
+
+// Reports whether a failed call is retried. Calls that never reached Restate and calls it
+// rejected with 429 are retried; 5xx responses only for calls waiting for their result, since
+// every attempt of a send starts another invocation. Other network errors and timeouts are not
+// retried, as the invocation may have started.
+const isRetryable = (err: unknown, send: boolean) => {
+  if (err instanceof IngressTimeoutError) {
+    return false;
+  }
+  const status = statusOf(err);
+  if (status === undefined) {
+    return wasNotSent(err);
+  }
+  return status === 429 || (!send && status >= 500);
+};
+
+const withTimeout = <T>(promise: Promise<T>, timeoutMs: number): Promise<T> => {
+  if (!timeoutMs) {
+    return promise;
+  }
+  let timer: ReturnType<typeof setTimeout> | undefined;
+  const timeout = new Promise<never>((_, reject) => {
+    timer = setTimeout(
+      () => reject(new IngressTimeoutError("Restate ingress call timed out after " + timeoutMs + "ms; its invocation may still run")),
+      timeoutMs,
+    );
+  });
+  return Promise.race([promise, timeout]).finally(() => clearTimeout(timer));
+};
+
+async function callWithResilience<T>(call: () => Promise<T>, opts: ClientOptions, send: boolean, refresh?: () => void): Promise<T> {
+  const maxAttempts = Math.max(1, opts.retry?.maxAttempts ?? 1);
+  const maxDelayMs = opts.retry?.maxDelayMs ?? 2000;
+  let delayMs = opts.retry?.initialDelayMs ?? 100;
+  let refreshed = false;
+  for (let attempt = 1; ; attempt++) {
+    try {
+      return await withTimeout(call(), opts.timeoutMs ?? 0);
+    } catch (err) {
+      if (refresh && !refreshed && statusOf(err) === 401) {
+        // The token may have expired; reconnect once with the token asked again.
+        refreshed = true;
+        refresh();
+        attempt--;
+        continue;
+      }
+      if (attempt >= maxAttempts || !isRetryable(err, send)) {
+        throw err;
+      }
+      await new Promise(resolve => setTimeout(resolve, delayMs));
+      delayMs = Math.min(delayMs * 2, maxDelayMs);
+    }
+  }
+}
+
+// Wraps every method of an ingress client, made by make, with the configured timeout and retry
+// policy; send marks clients whose calls start invocations without waiting for them. A call
+// rejected with 401 is retried once on a new client.
+const withResilience = <C extends object>(make: () => C, opts?: ClientOptions, send = false): C => {
+  const effective = mergeClientOptions(clientOptions, opts);
+  let client = make();
+  const refresh = () => {
+    cachedClient = undefined;
+    client = make();
+  };
+  return new Proxy(client, {
+    get(target, prop, receiver) {
+      const value = Reflect.get(target, prop, receiver);
+      if (typeof value !== "function") {
+        return value;
+      }
+      return (...args: unknown[]) => callWithResilience(() => Reflect.get(client, prop).apply(client, args), effective, send, refresh);
+    },
+  });
+};
+
+export const serviceClient = <D>(svc: ServiceDefinitionFrom<D>, opts?: ClientOptions): clients.IngressClient<Service<D>> =>
+  withResilience(() => getClient(opts).serviceClient(svc), opts);
+
+export const objectClient = <D>(obj: VirtualObjectDefinitionFrom<D>, key: string, opts?: ClientOptions): clients.IngressClient<VirtualObject<D>> =>
+  withResilience(() => getClient(opts).objectClient(obj, key), opts);
+
+export const serviceSendClient = <D>(svc: ServiceDefinitionFrom<D>, opts?: ClientOptions): clients.IngressSendClient<Service<D>> =>
+  withResilience(() => getClient(opts).serviceSendClient(svc), opts, true);
+
+export const objectSendClient = <D>(obj: VirtualObjectDefinitionFrom<D>, key: string, opts?: ClientOptions): clients.IngressSendClient<VirtualObject<D>> =>
+  withResilience(() => getClient(opts).objectSendClient(obj, key), opts, true);
+
+export const workflowClient = <D>(wf: WorkflowDefinitionFrom<D>, key: string, opts?: ClientOptions): clients.IngressWorkflowClient<Workflow<D>> =>
+  withResilience(() => getClient(opts).workflowClient(wf, key), opts);
+
+// SDK version the code was generated against, empty if unknown.
+const expectedSdkVersion = "{{ .SdkVersion }}";
+
+// installedSdkVersion finds the Restate SDK version resolvable from the working directory.
+function installedSdkVersion(): string | undefined {
+  let dir = process.cwd();
+  for (;;) {
+    const pkgPath = join(dir, "node_modules", "@restatedev", "restate-sdk", "package.json");
+    if (existsSync(pkgPath)) {
+      try {
+        return JSON.parse(readFileSync(pkgPath, "utf8")).version;
+      } catch {
+        return undefined;
+      }
+    }
+    const parent = dirname(dir);
+    if (parent === dir) {
+      return undefined;
+    }
+    dir = parent;
+  }
+}
+
+export type RestateHealth = {
+  status: "ok" | "error";
+};
+
+// Reports whether the SDK versions a and b share their major and minor version, so patch releases
+// of the SDK installed after generation are accepted.
+const compatibleSdkVersions = (a: string, b: string) => {
+  const [aMajor, aMinor] = a.split(".");
+  const [bMajor, bMinor] = b.split(".");
+  return aMajor === bMajor && aMinor === bMinor;
+};
+
+// Builds the readiness endpoint handler of a Restate-bound service. It responds with 200 once the
+// SDK bound the endpoint's request handler, restateHandler, and the installed SDK is compatible
+// with the generated code, 503 otherwise. The endpoint is public, so the response only carries the
+// status; the reasons a service is not ready, which name the SDK versions, are logged instead.
+export function buildRestateHealthHandler(service: string, restateHandler: unknown) {
+  return (_req: IncomingMessage, resp: ServerResponse<IncomingMessage>) => {
+    const errors: string[] = [];
+    if (typeof restateHandler !== "function") {
+      errors.push("Restate endpoint handler is not bound");
+    }
+    const sdkVersion = installedSdkVersion();
+    if (!sdkVersion) {
+      errors.push("@restatedev/restate-sdk is not installed");
+    } else if (expectedSdkVersion && !compatibleSdkVersions(sdkVersion, expectedSdkVersion)) {
+      errors.push("SDK version " + sdkVersion + " is not compatible with generated code (" + expectedSdkVersion + ")");
+    }
+    if (errors.length > 0) {
+      console.error("The Restate endpoint of " + service + " is not ready: " + errors.join("; "));
+    }
+    const health: RestateHealth = { status: errors.length === 0 ? "ok" : "error" };
+    resp.writeHead(errors.length === 0 ? 200 : 503, { "Content-Type": "application/json" });
+    resp.end(JSON.stringify(health));
+  };
+}
+
+{{ if .Lambda -}}
+// Adapts a Restate Lambda handler to a fetch handler, passing requests as API Gateway proxy events.
+export function fetchFromLambda(handler: (event: any, context: any) => Promise<any>) {
+  return async (request: Request): Promise<Response> => {
+    const path = new URL(request.url).pathname;
+    const body = Buffer.from(await request.arrayBuffer());
+    const result = await handler(
+      {
+        path,
+        rawPath: path,
+        httpMethod: request.method,
+        headers: Object.fromEntries(request.headers.entries()),
+        body: body.toString("base64"),
+        isBase64Encoded: true,
+      },
+      {},
+    );
+    const payload = result.body == null ? null : Buffer.from(result.body, result.isBase64Encoded ? "base64" : "utf8");
+    return new Response(payload, { status: result.statusCode, headers: result.headers });
+  };
+}
+
+{{ end -}}
+{{ if .WorkflowEndpoints -}}
+// Cancels the running invocation of the workflow name with key through the Restate admin API. It
+// fails with status 404 if there is none.
+export async function cancelWorkflow(name: string, key: string): Promise<void> {
+  const admin = restateConfig.adminUrl().replace(/\/$/, "");
+  const token = restateConfig.adminToken();
+  const headers: Record<string, string> = { "Content-Type": "application/json", Accept: "application/json" };
+  if (token) {
+    headers.Authorization = "Bearer " + token;
+  }
+  const quote = (s: string) => "'" + s.replace(/'/g, "''") + "'";
+  const query = await fetch(admin + "/query", {
+    method: "POST",
+    headers,
+    body: JSON.stringify({
+      query: "SELECT id FROM sys_invocation WHERE target_service_name = " + quote(name) +
+        " AND target_service_key = " + quote(key) + " AND target_handler_name = 'run' AND status != 'completed'",
+    }),
+  });
+  if (!query.ok) {
+    throw Object.assign(new Error("Restate admin API query failed: " + query.status), { status: query.status });
+  }
+  const { rows } = (await query.json()) as { rows: { id: string }[] };
+  if (rows.length === 0) {
+    throw Object.assign(new Error("no running " + name + " invocation with key " + key), { status: 404 });
+  }
+  for (const { id } of rows) {
+    let resp = await fetch(admin + "/invocations/" + encodeURIComponent(id) + "/cancel", { method: "PATCH", headers });
+    if (resp.status === 404 || resp.status === 405) {
+      // Restate servers before 1.4 cancel with DELETE.
+      resp = await fetch(admin + "/invocations/" + encodeURIComponent(id) + "?mode=Cancel", { method: "DELETE", headers });
+    }
+    if (!resp.ok) {
+      throw Object.assign(new Error("Restate admin API cancel failed: " + resp.status), { status: resp.status });
+    }
+  }
+}
+
+{{ end -}}
+{{ if .SharedSecret -}}
+// Reports whether req carries the shared secret, compared in constant time.
+const hasSharedSecret = (req: IncomingMessage): boolean => {
+  const expected = restateConfig.sharedSecret();
+  const actual = req.headers[restateConfig.sharedSecretHeader];
+  if (!expected || typeof actual !== "string") return false;
+  const a = Buffer.from(actual);
+  const b = Buffer.from(expected);
+  return a.length === b.length && timingSafeEqual(a, b);
+};
+
+{{ end -}}
+export function buildEncoreRestateHandler(fetch: (request: Request, ...extraArgs: unknown[]) => Promise<Response>) {
+  return (req: IncomingMessage, resp: ServerResponse<IncomingMessage>) => {
+{{- if .SharedSecret }}
+    // Only Restate knows the shared secret; see sharedSecret in encore-restate-gen.json.
+    if (!hasSharedSecret(req)) {
+      resp.writeHead(401, { "Content-Type": "text/plain" });
+      resp.end("Missing or wrong " + restateConfig.sharedSecretHeader + " header");
+      return;
+    }
+{{- end }}
+    getBody(req)
+      .then(async body => {
+        const url = 'http://'+(req.headers.host ?? "localhost")+req.url;
+        const request = new Request(url, {
+          method: req.method ?? "GET",
+          headers: req.headers as Record<string, string>,
+          body: ["GET", "HEAD"].includes(req.method || "") ? undefined : body,
+        });
+        return fetch(request);
+      })
+      .then(restateResponse => {
+        resp.writeHead(
+          restateResponse.status,
+          Object.fromEntries(restateResponse.headers.entries()),
+        );
+        if (!restateResponse.body) {
+          resp.end();
+          return;
+        }
+        return restateResponse.body.getReader();
+      })
+      .then(reader => {
+        if (!reader) return;
+        const pump = (): Promise<void> => reader.read()
+          .then(({done, value}) => {
+            if (done) {
+              resp.end();
+              return;
+            }
+            resp.write(value);
+            return pump();
+          });
+        return pump();
+      })
+      .catch(err => {
+        console.error(err);
+        resp.writeHead(500, { "Content-Type": "text/plain" });
+        resp.end(String(err));
+      });
+  };
+}
+
+/**
+ * Utility to read the entire request body from Encore's IncomingMessage.
+ * Returns a string, but you could change it to return a Buffer if needed.
+ */
+function getBody(req: IncomingMessage): Promise<Buffer> {
+  return new Promise((resolve, reject) => {
+    const chunks: Buffer[] = [];
+    req.on("data", (chunk) => chunks.push(chunk));
+    req.on("end", () => {
+      try {
+        resolve(Buffer.concat(chunks));
+      } catch (err) {
+        reject(err);
+      }
+    });
+    req.on("error", (err) => reject(err));
+  });
+}`
+
+// RootIndexData holds data passed to the root index template.
+// ClientDefaults is the subset of the client config emitted as ClientOptions.
+type RootIndexData struct {
+	Client         ClientConfig
+	ClientDefaults ClientDefaults
+	SdkVersion     string
+	SharedSecret   *SharedSecretConfig
+	// Lambda adds the adapter of the Restate SDK's Lambda handler.
+	Lambda bool
+	// WorkflowEndpoints adds cancelWorkflow, used by the workflow management endpoints.
+	WorkflowEndpoints bool
+}
+
+// ClientDefaults mirrors the generated ClientOptions type.
+type ClientDefaults struct {
+	TimeoutMs int               `json:"timeoutMs,omitempty"`
+	Retry     *RetryConfig      `json:"retry,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+}
+
+func newRootIndexData(cfg Config) RootIndexData {
+	return RootIndexData{
+		Client: cfg.Client,
+		ClientDefaults: ClientDefaults{
+			TimeoutMs: cfg.Client.TimeoutMs,
+			Retry:     cfg.Client.Retry,
+			Headers:   cfg.Client.Headers,
+		},
+		SdkVersion:        installedPackageVersion(projectRoot, "@restatedev/restate-sdk"),
+		SharedSecret:      cfg.SharedSecret,
+		Lambda:            cfg.EndpointHandler == endpointLambda,
+		WorkflowEndpoints: len(cfg.WorkflowEndpoints) > 0,
+	}
+}
+
+// templateFuncs are available to all generated templates.
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"lowerFirst":    lowerFirst,
+	"jsdoc":         jsdoc,
+	"restateImport": restateImport,
+}
+
+// jsdoc formats doc as a JSDoc block comment at the given indentation, or returns "" if doc is empty.
+func jsdoc(indent, doc string) string {
+	if strings.TrimSpace(doc) == "" {
+		return ""
+	}
+	doc = strings.ReplaceAll(doc, "*/", "*\\/")
+	var b strings.Builder
+	b.WriteString(indent + "/**\n")
+	for _, line := range strings.Split(doc, "\n") {
+		b.WriteString(strings.TrimRight(indent+" * "+line, " ") + "\n")
+	}
+	b.WriteString(indent + " */\n")
+	return b.String()
+}
+
+// lowerFirst lower-cases the first letter of s, e.g. "UserManager" becomes "userManager".
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
+
+// generateRootIndex renders the root index template to filePath.
+func generateRootIndex(filePath string, data RootIndexData) error {
+	return writeTemplate(filePath, "rootIndex", rootIndexTemplate, data)
+}
+
+// extractAssets extracts the embedded assets once per tool version into the cache directory and
+// returns it. An existing extraction is reused if its checksum matches the embedded assets.
+func extractAssets() (string, error) {
+	base, err := cacheDir()
 	if err != nil {
-		return nil, fmt.Errorf("failed to extract embedded assets: %v", err)
+		return "", err
 	}
-	defer os.RemoveAll(assetsDir)
-	scriptPath := filepath.Join(assetsDir, "index.js")
-	cmd := exec.Command("node", scriptPath, dir)
-	cmd.Dir = assetsDir
-	outBytes, err := cmd.CombinedOutput()
+	checksum := assetsChecksum()
+	targetDir := filepath.Join(base, "assets", checksum[:16])
+	if diskChecksum(targetDir) == checksum {
+		return targetDir, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(targetDir), 0755); err != nil {
+		return "", err
+	}
+	// Extract next to the target and rename, so concurrent runs never see a partial extraction.
+	tempDir, err := ioutil.TempDir(filepath.Dir(targetDir), "tmp-")
 	if err != nil {
-		return nil, fmt.Errorf("failed to run Node script: %v, output: %s", err, string(outBytes))
+		return "", err
+	}
+	defer os.RemoveAll(tempDir)
+	err = fs.WalkDir(assets, "assets_dist", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel("assets_dist", path)
+		if err != nil {
+			return err
+		}
+		targetPath := filepath.Join(tempDir, relPath)
+		if d.IsDir() {
+			return os.MkdirAll(targetPath, 0755)
+		}
+		data, err := assets.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err = os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(targetPath, data, 0755)
+	})
+	if err != nil {
+		return "", err
+	}
+	os.RemoveAll(targetDir)
+	if err := os.Rename(tempDir, targetDir); err != nil && diskChecksum(targetDir) != checksum {
+		return "", err
+	}
+	return targetDir, nil
+}
+
+// extractManifest extracts the manifest of dir with the configured extraction backend. If none of
+// the service's source files changed since the last extraction, the cached manifest is returned.
+// While the sources have errors, e.g. mid-edit, the last good manifest is returned instead, so the
+// generated code is kept until they parse again.
+func extractManifest(dir string) (*Manifest, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	// Extract symlinked services at their real location, where their imports are resolved.
+	absDir = realDir(absDir)
+	goBackend := useGoExtractor()
+	state, err := sourceHash(absDir, goBackend)
+	if err != nil {
+		return nil, err
+	}
+	manifest, prev, ok := lookupManifest(absDir, state.Hash)
+	if !ok {
+		switch {
+		case projectConfig.ExtractScript != "":
+			manifest, err = runExtractScript(absDir)
+		case goBackend:
+			manifest, err = goExtractManifest(absDir)
+		default:
+			req := workerRequest{Op: "extract", Dir: absDir, ServiceFile: serviceMarker(absDir), Hash: state.Hash, Ignored: ignoredFiles(absDir)}
+			if changed, incremental := state.changedFiles(absDir, prev); incremental {
+				req.Base, req.Files = prev.Hash, changed
+			}
+			manifest, err = runNodeScript(req)
+		}
+		if err == nil && projectConfig.PostProcessScript != "" {
+			manifest, err = postProcessManifest(absDir, manifest)
+		}
+		if err != nil {
+			return nil, err
+		}
+		checkServiceName(absDir, manifest)
+		// Diagnostics are printed once per change; cache hits have the same diagnostics.
+		printDiagnostics(manifest.Diagnostics)
+		storeManifest(absDir, state, manifest)
+	}
+	if errs := countErrors(manifest.Diagnostics); errs > 0 {
+		recordFailure(absDir)
+		if good := lastGoodManifest(absDir); good != nil {
+			if !ok {
+				log.Printf("Keeping the previously generated code of %s until its %d error(s) are fixed", dir, errs)
+			}
+			return good, nil
+		}
+		return nil, fmt.Errorf("extraction failed with %d error(s)", errs)
+	}
+	recordInputs(absDir, state.Hash)
+	return manifest, nil
+}
+
+var goExtractorOnce sync.Once
+
+// useGoExtractor reports whether the native Go backend is used: when configured, or when neither
+// a backend nor a runtime is configured and no JavaScript runtime is on PATH.
+func useGoExtractor() bool {
+	switch projectConfig.Extractor {
+	case extractorGo:
+		return true
+	case extractorNode:
+		return false
+	}
+	if projectConfig.Runtime != "" {
+		return false
+	}
+	if _, err := jsRuntime(); err != nil {
+		goExtractorOnce.Do(func() {
+			log.Printf("%v, using the native Go extractor", err)
+		})
+		return true
+	}
+	return false
+}
+
+// runNodeScript sends an extract request to the Node extraction worker and returns the manifest.
+func runNodeScript(req workerRequest) (*Manifest, error) {
+	result, err := nodeWorkers.call(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run Node script: %v", err)
 	}
 	var manifest Manifest
-	if err := json.Unmarshal(outBytes, &manifest); err != nil {
-		return nil, fmt.Errorf("failed to parse JSON manifest: %v, output: %s", err, string(outBytes))
+	if err := json.Unmarshal(result, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON manifest: %v, output: %s", err, string(result))
+	}
+	if manifest.SchemaVersion != manifestSchemaVersion {
+		return nil, fmt.Errorf("the extraction bundle produced manifest schema version %d, but this binary expects version %d; reinstall encore-restate-gen so both come from the same release",
+			manifest.SchemaVersion, manifestSchemaVersion)
 	}
 	return &manifest, nil
 }
@@ -471,6 +1723,34 @@ func groupHandlers(handlers []HandlerEntry) []GroupedHandler {
 	return groups
 }
 
+// objectKeyType returns the key type shared by all virtual object handlers. Handlers without an
+// inferred key type are ignored; if the inferred types disagree, it falls back to string.
+func objectKeyType(handlers []HandlerEntry) (string, *TypeImport) {
+	var keyType string
+	var keyImport *TypeImport
+	for _, h := range handlers {
+		if h.KeyType == "" {
+			continue
+		}
+		if keyType == "" {
+			keyType, keyImport = h.KeyType, h.KeyTypeImport
+			continue
+		}
+		if h.KeyType != keyType || (h.KeyTypeImport == nil) != (keyImport == nil) ||
+			(keyImport != nil && (h.KeyTypeImport.Name != keyImport.Name || h.KeyTypeImport.Source != keyImport.Source)) {
+			log.Printf("Conflicting key types %q and %q, falling back to string", keyType, h.KeyType)
+			return "string", nil
+		}
+	}
+	if keyImport != nil {
+		return "__ObjectKey", keyImport
+	}
+	if keyType == "" {
+		return "string", nil
+	}
+	return keyType, nil
+}
+
 func trimSuffixes(s string) string {
 	suffixes := []string{"Workflow", "Object", "Service"}
 	for _, suf := range suffixes {
@@ -481,38 +1761,304 @@ func trimSuffixes(s string) string {
 
 // generateFile generates the combined file using the template.
 func generateFile(filePath string, data TemplateData) error {
-	tmpl, err := template.New("generated").Parse(combinedTemplate)
-	if err != nil {
+	if err := writeResultMigration(filepath.Dir(filePath), data); err != nil {
 		return err
 	}
-	f, err := os.Create(filePath)
-	if err != nil {
-		return err
+	return writeTemplate(filePath, "generated", combinedTemplate, data)
+}
+
+// nameDefaultExports replaces the "default" name of default-exported handlers, or of the class
+// of default-exported class handlers, with one derived from the file name by the
+// defaultHandlerName config, e.g. "sendEmail" for ./send-email.
+func nameDefaultExports(handlers []HandlerEntry) {
+	for i, h := range handlers {
+		if !h.Default {
+			continue
+		}
+		name := defaultHandlerName(h.Source)
+		if h.ClassName != "" {
+			handlers[i].ClassName = name
+		} else {
+			handlers[i].ExportName = name
+		}
 	}
-	defer f.Close()
-	return tmpl.Execute(f, data)
 }
 
-// processDirectory processes a service directory (one containing an encore.service.ts file),
-// runs the Node script to extract handlers, groups them, and generates the unified <servicename>.restate.ts file.
+// defaultHandlerName returns the name of the default export of source, e.g. "./send-email".
+func defaultHandlerName(source string) string {
+	words := strings.FieldsFunc(filepath.Base(source), func(r rune) bool {
+		return !(r == '_' || r == '$' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	})
+	file := ""
+	for i, w := range words {
+		if i > 0 {
+			w = strings.ToUpper(w[:1]) + w[1:]
+		}
+		file += w
+	}
+	file = lowerFirst(file)
+	pattern := projectConfig.DefaultHandlerName
+	if pattern == "" {
+		pattern = "{file}"
+	}
+	name := strings.ReplaceAll(pattern, "{file}", file)
+	if !jsIdentifierRe.MatchString(name) {
+		log.Printf("defaultHandlerName %q gives invalid name %q for %s, using %q", pattern, name, source, file+"Default")
+		return file + "Default"
+	}
+	return name
+}
+
+// validDefinitions returns the explicit definitions of manifest whose names can be exported from
+// the generated file, logging the others.
+func validDefinitions(manifest *Manifest) []DefinitionEntry {
+	trimmed := trimSuffixes(manifest.ServiceName)
+	taken := map[string]bool{
+		"handler": true, "discover": true, "restateHealth": true,
+		trimmed + "Service": true, trimmed + "Workflow": true, trimmed + "Object": true,
+		lowerFirst(trimmed) + "Object": true, lowerFirst(trimmed) + "ObjectSend": true,
+	}
+	for _, h := range manifest.Handlers {
+		taken[h.ExportName] = true
+	}
+	var definitions []DefinitionEntry
+	for _, def := range manifest.Definitions {
+		if !jsIdentifierRe.MatchString(def.Name) || taken[def.Name] {
+			log.Printf("Skipping definition %s in %s: name %q is not a valid or unique identifier", def.ExportName, manifest.ServiceName, def.Name)
+			continue
+		}
+		taken[def.Name] = true
+		definitions = append(definitions, def)
+	}
+	return definitions
+}
+
+var jsIdentifierRe = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// processDirectory processes a service directory (one containing a service marker file),
+// runs the Node script to extract handlers, groups them, and generates the unified <servicename>.restate.ts
+// (or .restate.js in JavaScript output mode) file.
 func processDirectory(serviceDir string) {
+	// Resuming reconciles all directories.
+	if paused() {
+		return
+	}
+	defer lockDir(serviceDir)()
+	// A repeatedly failing directory is extracted again once its backoff has passed.
+	if wait := retryWait(serviceDir); wait > 0 {
+		debounce("retry "+serviceDir, wait, func() {
+			processDirectory(serviceDir)
+			scheduleCentralIndex()
+		})
+		return
+	}
+	cancelDebounce("retry " + serviceDir)
+	if serviceMarker(serviceDir) == "" {
+		forgetService(serviceDir)
+		return
+	}
 	// Before code generation, ensure required ReState modules are installed.
 	if err := ensureRestateModulesInstalled(projectRoot); err != nil {
 		log.Printf("Error ensuring ReState modules installed in %s: %v", serviceDir, err)
 		return
 	}
 
-	manifest, err := runNodeScript(serviceDir)
+	start := time.Now()
+	manifest, err := extractManifest(serviceDir)
+	generateFromManifest(serviceDir, manifest, err)
+	metrics.generation(serviceDir, time.Since(start), err != nil || hasErrors(absRealDir(serviceDir)))
+}
+
+var (
+	// dirLocks serialize the generations of each service directory.
+	dirLocks      = make(map[string]*sync.Mutex)
+	dirLocksMutex sync.Mutex
+)
+
+// lockDir locks serviceDir, so that its extraction and generation do not overlap with another of
+// the same directory, and returns the function unlocking it.
+func lockDir(serviceDir string) func() {
+	dirLocksMutex.Lock()
+	mu, ok := dirLocks[serviceDir]
+	if !ok {
+		mu = &sync.Mutex{}
+		dirLocks[serviceDir] = mu
+	}
+	dirLocksMutex.Unlock()
+	mu.Lock()
+	return mu.Unlock
+}
+
+// dirFailure tracks the consecutive failed extractions of a service directory.
+type dirFailure struct {
+	count int
+	last  time.Time
+}
+
+// maxFailureBackoff caps the time a repeatedly failing directory waits before it is extracted again.
+const maxFailureBackoff = 30 * time.Second
+
+// backoff returns how long to wait after the last failure before extracting again: nothing after
+// the first failure, then doubling from a second.
+func (f *dirFailure) backoff() time.Duration {
+	if f.count < 2 {
+		return 0
+	}
+	if f.count > 7 {
+		return maxFailureBackoff
+	}
+	if d := time.Second << uint(f.count-2); d < maxFailureBackoff {
+		return d
+	}
+	return maxFailureBackoff
+}
+
+// retryWait returns how long serviceDir must wait before it is extracted again, or 0.
+func retryWait(serviceDir string) time.Duration {
+	erroredDirsMutex.Lock()
+	defer erroredDirsMutex.Unlock()
+	failure, ok := erroredDirs[serviceDir]
+	if !ok {
+		return 0
+	}
+	if wait := time.Until(failure.last.Add(failure.backoff())); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+var (
+	// generationFailures are the service directories extracted with error diagnostics and the
+	// generated files that could not be written, for the exit code of generate.
+	generationFailures      = make(map[string]bool)
+	generationFailuresMutex sync.Mutex
+)
+
+// recordFailure adds path to the generation failures.
+func recordFailure(path string) {
+	generationFailuresMutex.Lock()
+	generationFailures[path] = true
+	generationFailuresMutex.Unlock()
+}
+
+// generateFromManifest generates the file of serviceDir from its manifest, or records err if the
+// extraction failed.
+func generateFromManifest(serviceDir string, manifest *Manifest, err error) {
+	erroredDirsMutex.Lock()
+	failure := erroredDirs[serviceDir]
 	if err != nil {
-		log.Printf("Error extracting manifest from %s: %v", serviceDir, err)
+		if failure == nil {
+			failure = &dirFailure{}
+			erroredDirs[serviceDir] = failure
+		}
+		failure.count++
+		failure.last = time.Now()
+	} else if failure != nil {
+		delete(erroredDirs, serviceDir)
+		log.Printf("Extraction of %s succeeded again", serviceDir)
+	}
+	erroredDirsMutex.Unlock()
+	if err != nil {
+		if failure.count == 1 {
+			log.Printf("Error extracting manifest from %s: %v", serviceDir, err)
+		} else {
+			log.Printf("Extraction of %s is still failing after %d attempts, retrying after %v at the earliest: %v",
+				serviceDir, failure.count, failure.backoff(), err)
+		}
 		return
 	}
+	if encoreDiscovery() {
+		if other := declaredElsewhere(serviceDir, manifest.ServiceName); other != "" {
+			log.Printf("Skipping %s: the Encore service %s is already declared in %s", serviceDir, manifest.ServiceName, other)
+			forgetService(serviceDir)
+			return
+		}
+	}
 	if manifest.ServiceName == "" {
+		forgetService(serviceDir)
 		return
 	}
-	genFileName := fmt.Sprintf("%s.restate.ts", strings.ToLower(manifest.ServiceName))
+	data, ok := templateData(serviceDir, manifest)
+	generatedFilePath := data.FilePath
+
+	// If no handlers are found, delete any existing generated file and remove stored data.
+	if !ok {
+		forgetService(serviceDir)
+		if _, err := os.Stat(generatedFilePath); err == nil {
+			removeGenerated(generatedFilePath)
+			log.Printf("Removed generated file: %s", generatedFilePath)
+		}
+		return
+	}
+
+	generatedDataMapMutex.Lock()
+	previous, hadPrevious := generatedDataMap[serviceDir]
+	generatedDataMapMutex.Unlock()
+	if hadPrevious {
+		logRemovedHandlers(previous, &data)
+		// A renamed service is generated to a new file.
+		if previous.FilePath != generatedFilePath {
+			removeGenerated(previous.FilePath)
+			log.Printf("Removed generated file: %s", previous.FilePath)
+		}
+	}
+
+	if err := generateFile(generatedFilePath, data); err != nil {
+		recordFailure(generatedFilePath)
+		log.Printf("Error generating file %s: %v", generatedFilePath, err)
+	} else {
+		log.Printf("Generated file: %s", generatedFilePath)
+		runHooks(hookService, generatedFilePath, data.ServiceName)
+		registerDeployment(data)
+	}
+
+	// Store the generated data for later use in central index generation.
+	generatedDataMapMutex.Lock()
+	generatedDataMap[serviceDir] = data
+	generatedDataMapMutex.Unlock()
+}
+
+// forgetService removes the generated file and stored data of serviceDir, which no longer
+// declares a service with handlers, so it drops out of the central index too.
+func forgetService(serviceDir string) {
+	generatedDataMapMutex.Lock()
+	previous, ok := generatedDataMap[serviceDir]
+	delete(generatedDataMap, serviceDir)
+	generatedDataMapMutex.Unlock()
+	if !ok {
+		return
+	}
+	logRemovedHandlers(previous, nil)
+	if _, err := os.Stat(previous.FilePath); err == nil {
+		removeGenerated(previous.FilePath)
+		log.Printf("Removed generated file: %s", previous.FilePath)
+	}
+}
+
+// logRemovedHandlers logs the handlers of previous that current, nil if the service is gone,
+// no longer has.
+func logRemovedHandlers(previous TemplateData, current *TemplateData) {
+	kept := make(map[string]bool)
+	if current != nil {
+		for _, h := range handlerDumps(*current) {
+			kept[h.Path] = true
+		}
+	}
+	for _, h := range handlerDumps(previous) {
+		if !kept[h.Path] {
+			log.Printf("Removed endpoint %s of handler %s.%s", h.Path, h.Component, h.Name)
+		}
+	}
+}
+
+// templateData builds the data of the file generated for serviceDir from its manifest. It reports
+// false if the service has no handlers or definitions, i.e. no file is generated.
+func templateData(serviceDir string, manifest *Manifest) (TemplateData, bool) {
+	genFileName := fmt.Sprintf("%s.restate%s", strings.ToLower(manifest.ServiceName), outputExt())
 	generatedFilePath := filepath.Join(serviceDir, genFileName)
 
+	nameDefaultExports(manifest.Handlers)
+
 	// Filter handlers by category.
 	serviceHandlers := []HandlerEntry{}
 	workflowHandlers := []HandlerEntry{}
@@ -528,19 +2074,12 @@ func processDirectory(serviceDir string) {
 		}
 	}
 
-	// If no handlers are found, delete any existing generated file and remove stored data.
-	if len(serviceHandlers)+len(workflowHandlers)+len(virtualObjectHandlers) == 0 {
-		if _, err := os.Stat(generatedFilePath); err == nil {
-			os.Remove(generatedFilePath)
-			log.Printf("Removed generated file: %s", generatedFilePath)
-		}
-		generatedDataMapMutex.Lock()
-		delete(generatedDataMap, serviceDir)
-		generatedDataMapMutex.Unlock()
-		return
+	definitions := validDefinitions(manifest)
+	if len(serviceHandlers)+len(workflowHandlers)+len(virtualObjectHandlers)+len(definitions) == 0 {
+		return TemplateData{FilePath: generatedFilePath}, false
 	}
 
-	// Build TemplateData.
+	keyType, keyImport := objectKeyType(virtualObjectHandlers)
 	data := TemplateData{
 		ServiceName:        manifest.ServiceName,
 		ServiceNameTrimmed: trimSuffixes(manifest.ServiceName),
@@ -548,18 +2087,11 @@ func processDirectory(serviceDir string) {
 		WorkflowGroup:      groupHandlers(workflowHandlers),
 		VirtualObjectGroup: groupHandlers(virtualObjectHandlers),
 		FilePath:           generatedFilePath,
+		ObjectKeyType:      keyType,
+		ObjectKeyImport:    keyImport,
+		Definitions:        definitions,
 	}
-
-	if err := generateFile(generatedFilePath, data); err != nil {
-		log.Printf("Error generating file %s: %v", generatedFilePath, err)
-	} else {
-		log.Printf("Generated file: %s", generatedFilePath)
-	}
-
-	// Store the generated data for later use in central index generation.
-	generatedDataMapMutex.Lock()
-	generatedDataMap[serviceDir] = data
-	generatedDataMapMutex.Unlock()
+	return data, true
 }
 
 // generateCentralIndex generates the central index files using the stored TemplateData.
@@ -597,7 +2129,7 @@ func generateCentralIndex(root string) error {
 		if len(data.ServiceGroup) > 0 {
 			rel, err := filepath.Rel(centralDirs["service"], data.FilePath)
 			if err == nil {
-				rel = strings.ReplaceAll(filepath.ToSlash(rel), ".ts", "")
+				rel = importPath(rel)
 				line := fmt.Sprintf("export { %sService as %s } from './%s';", data.ServiceNameTrimmed, data.ServiceNameTrimmed, rel)
 				exports["service"] = append(exports["service"], line)
 			}
@@ -605,7 +2137,7 @@ func generateCentralIndex(root string) error {
 		if len(data.WorkflowGroup) > 0 {
 			rel, err := filepath.Rel(centralDirs["workflow"], data.FilePath)
 			if err == nil {
-				rel = strings.ReplaceAll(filepath.ToSlash(rel), ".ts", "")
+				rel = importPath(rel)
 				line := fmt.Sprintf("export { %sWorkflow as %s } from './%s';", data.ServiceNameTrimmed, data.ServiceNameTrimmed, rel)
 				exports["workflow"] = append(exports["workflow"], line)
 			}
@@ -613,189 +2145,334 @@ func generateCentralIndex(root string) error {
 		if len(data.VirtualObjectGroup) > 0 {
 			rel, err := filepath.Rel(centralDirs["virtualobject"], data.FilePath)
 			if err == nil {
-				rel = strings.ReplaceAll(filepath.ToSlash(rel), ".ts", "")
+				rel = importPath(rel)
 				line := fmt.Sprintf("export { %sObject as %s } from './%s';", data.ServiceNameTrimmed, data.ServiceNameTrimmed, rel)
 				exports["virtualobject"] = append(exports["virtualobject"], line)
-			}
-		}
-	}
-	generatedDataMapMutex.Unlock()
-
-	// If no exports exist in a category, add a default export.
-	for cat, lines := range exports {
-		if len(lines) == 0 {
-			exports[cat] = []string{"export default {};"}
-		}
-	}
-
-	// Write central index files.
-	for cat, dir := range centralDirs {
-		indexContent := strings.Join(exports[cat], "\n")
-		indexPath := filepath.Join(dir, "index.ts")
-		if err := ioutil.WriteFile(indexPath, []byte(indexContent), 0644); err != nil {
-			return fmt.Errorf("error writing index for %s: %v", cat, err)
-		}
-	}
-
-	// Generate root index file.
-	restDir := filepath.Join(root, "restate.gen")
-	if err := os.MkdirAll(restDir, 0755); err != nil {
-		return fmt.Errorf("failed to create restate.gen directory: %v", err)
-	}
-	rootIndexContent := `// This file is automatically generated by encore-restate-gen.
-// Do not edit this file directly.
-
-import { api as _api } from "encore.dev/api";
-import type { IncomingMessage, ServerResponse } from "node:http";
-import * as clients from "@restatedev/restate-sdk-clients";
-import type {
-  Service,
-  VirtualObject,
-  ServiceDefinitionFrom,
-  VirtualObjectDefinitionFrom,
-  WorkflowDefinitionFrom,
-  Workflow,
-} from "@restatedev/restate-sdk-core";
-export * as services from "~restate/services";
-export * as workflows from "~restate/workflows";
-export * as objects from "~restate/objects";
-
-let cachedClient: ReturnType<typeof clients.connect> | undefined;
-export const getClient = () => {
-  if (!cachedClient) {
-    cachedClient = clients.connect({ url: process.env.RESTATE_SERVER_URL ?? "http://localhost:8080" });
-  }
-  return cachedClient;
-};
-
-export const serviceClient = <D>(svc: ServiceDefinitionFrom<D>): clients.IngressClient<Service<D>> =>
-  getClient().serviceClient(svc);
-
-export const objectClient = <D>(obj: VirtualObjectDefinitionFrom<D>, key: string): clients.IngressClient<VirtualObject<D>> =>
-  getClient().objectClient(obj, key);
-
-export const serviceSendClient = <D>(svc: ServiceDefinitionFrom<D>): clients.IngressSendClient<Service<D>> =>
-  getClient().serviceSendClient(svc);
-
-export const objectSendClient = <D>(obj: VirtualObjectDefinitionFrom<D>, key: string): clients.IngressSendClient<VirtualObject<D>> =>
-  getClient().objectSendClient(obj, key);
-
-export const workflowClient = <D>(wf: WorkflowDefinitionFrom<D>, key: string): clients.IngressWorkflowClient<Workflow<D>> =>
-  getClient().workflowClient(wf, key);
-
-export function buildEncoreRestateHandler(fetch: (request: Request, ...extraArgs: unknown[]) => Promise<Response>) {
-  return (req: IncomingMessage, resp: ServerResponse<IncomingMessage>) => {
-    getBody(req)
-      .then(async body => {
-        const url = 'http://'+(req.headers.host ?? "localhost")+req.url;
-        const request = new Request(url, {
-          method: req.method ?? "GET",
-          headers: req.headers as Record<string, string>,
-          body: ["GET", "HEAD"].includes(req.method || "") ? undefined : body,
-        });
-        return fetch(request);
-      })
-      .then(restateResponse => {
-        resp.writeHead(
-          restateResponse.status,
-          Object.fromEntries(restateResponse.headers.entries()),
-        );
-        if (!restateResponse.body) {
-          resp.end();
-          return;
-        }
-        return restateResponse.body.getReader();
-      })
-      .then(reader => {
-        if (!reader) return;
-        const pump = (): Promise<void> => reader.read()
-          .then(({done, value}) => {
-            if (done) {
-              resp.end();
-              return;
-            }
-            resp.write(value);
-            return pump();
-          });
-        return pump();
-      })
-      .catch(err => {
-        console.error(err);
-        resp.writeHead(500, { "Content-Type": "text/plain" });
-        resp.end(String(err));
-      });
-  };
-}
+				helper := lowerFirst(data.ServiceNameTrimmed) + "Object"
+				line = fmt.Sprintf("export { %s, %sSend } from './%s';", helper, helper, rel)
+				exports["virtualobject"] = append(exports["virtualobject"], line)
+			}
+		}
+		for _, def := range data.Definitions {
+			cat := strings.ToLower(def.Type)
+			rel, err := filepath.Rel(centralDirs[cat], data.FilePath)
+			if err == nil {
+				line := fmt.Sprintf("export { %s } from './%s';", def.Name, importPath(rel))
+				exports[cat] = append(exports[cat], line)
+			}
+		}
+	}
+	generatedDataMapMutex.Unlock()
 
-/**
- * Utility to read the entire request body from Encore's IncomingMessage.
- * Returns a string, but you could change it to return a Buffer if needed.
- */
-function getBody(req: IncomingMessage): Promise<Buffer> {
-  return new Promise((resolve, reject) => {
-    const chunks: Buffer[] = [];
-    req.on("data", (chunk) => chunks.push(chunk));
-    req.on("end", () => {
-      try {
-        resolve(Buffer.concat(chunks));
-      } catch (err) {
-        reject(err);
-      }
-    });
-    req.on("error", (err) => reject(err));
-  });
-}`
-	rootIndexPath := filepath.Join(restDir, "index.ts")
-	if err := ioutil.WriteFile(rootIndexPath, []byte(rootIndexContent), 0644); err != nil {
+	// If no exports exist in a category, add a default export.
+	for cat, lines := range exports {
+		if len(lines) == 0 {
+			exports[cat] = []string{"export default {};"}
+		}
+	}
+
+	// Write central index files.
+	for cat, dir := range centralDirs {
+		indexContent := strings.Join(exports[cat], "\n")
+		indexPath := filepath.Join(dir, "index"+outputExt())
+		if err := ioutil.WriteFile(indexPath, []byte(indexContent), 0644); err != nil {
+			return fmt.Errorf("error writing index for %s: %v", cat, err)
+		}
+		removeOtherOutput(indexPath)
+	}
+
+	// Generate root index file.
+	restDir := filepath.Join(root, "restate.gen")
+	if err := os.MkdirAll(restDir, 0755); err != nil {
+		return fmt.Errorf("failed to create restate.gen directory: %v", err)
+	}
+	// The root index imports the Restate settings, so they are written first.
+	if err := generateRestateConfig(root); err != nil {
+		return fmt.Errorf("error writing %s: %v", restateConfigName, err)
+	}
+	rootIndexPath := filepath.Join(restDir, "index"+outputExt())
+	if err := generateRootIndex(rootIndexPath, newRootIndexData(projectConfig)); err != nil {
 		return fmt.Errorf("error writing root restate.gen index: %v", err)
 	}
+	if err := generateTestingModule(root); err != nil {
+		return fmt.Errorf("error writing restate.gen/%s: %v", testingModuleDir, err)
+	}
+
+	// Describe the generated endpoints.
+	generatedDataMapMutex.Lock()
+	datas := make([]TemplateData, 0, len(generatedDataMap))
+	for _, data := range generatedDataMap {
+		datas = append(datas, data)
+	}
+	generatedDataMapMutex.Unlock()
+	if err := generateRemoteModule(root, datas); err != nil {
+		return fmt.Errorf("error writing restate.gen/%s: %v", remoteModuleDir, err)
+	}
+	if err := generateOpenAPI(root, datas); err != nil {
+		return fmt.Errorf("error writing %s: %v", openAPIFileName, err)
+	}
+	if err := generateDeployDescriptor(root, datas); err != nil {
+		return fmt.Errorf("error writing %s: %v", deployFileName, err)
+	}
+	updateComposeFile(root)
+	saveState(root)
+	runHooks(hookIndex, rootIndexPath, "")
 	return nil
 }
 
-// cleanDanglingGeneratedFiles scans the project and removes any generated file ending with .restate.ts
-// in a service directory where no valid handlers are found.
+// cleanDanglingGeneratedFiles scans the project and removes any generated file ending with suffix
+// that the initial scan did not generate or restore, e.g. of a service that was removed, renamed or
+// lost its handlers while the generator was not running. The scan's results decide, so no directory
+// is extracted again; the files of directories that failed to extract are kept.
 func cleanDanglingGeneratedFiles(root, suffix string) {
-	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+	generated := make(map[string]bool)
+	generatedDataMapMutex.Lock()
+	for _, data := range generatedDataMap {
+		generated[data.FilePath] = true
+	}
+	generatedDataMapMutex.Unlock()
+	erroredDirsMutex.Lock()
+	errored := make(map[string]bool, len(erroredDirs))
+	for dir := range erroredDirs {
+		errored[dir] = true
+	}
+	erroredDirsMutex.Unlock()
+	walkDirs(root, func(dir string) error {
+		if errored[dir] {
+			return nil
+		}
+		entries, err := ioutil.ReadDir(dir)
 		if err != nil {
-			return err
+			return nil
 		}
-		if !info.IsDir() && strings.HasSuffix(info.Name(), suffix) {
-			dir := filepath.Dir(path)
-			manifest, err := runNodeScript(dir)
-			if err != nil {
-				return nil
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.HasSuffix(entry.Name(), suffix) {
+				continue
 			}
-			if len(manifest.Handlers) == 0 {
-				os.Remove(path)
-				log.Printf("Removed generated file: %s", path)
-				// Remove any stored TemplateData for this directory.
-				generatedDataMapMutex.Lock()
-				delete(generatedDataMap, dir)
-				generatedDataMapMutex.Unlock()
+			path := filepath.Join(dir, entry.Name())
+			if generated[path] {
+				continue
 			}
+			if data, err := ioutil.ReadFile(path); err != nil || !strings.HasPrefix(string(data), generatedHeader) {
+				continue
+			}
+			removeGenerated(path)
+			log.Printf("Removed generated file: %s", path)
 		}
 		return nil
 	})
 }
 
-// initialScan walks the project and processes every directory that contains an encore.service.ts.
+// defaultServiceMarker is the file marking an Encore service directory.
+const defaultServiceMarker = "encore.service.ts"
+
+// serviceMarker returns the name of the file marking dir as a service directory, or "" if there is
+// none. The configured serviceMarkers, file names or globs, are tried in order.
+func serviceMarker(dir string) string {
+	if encoreDiscovery() {
+		return encoreServiceMarker(dir)
+	}
+	markers := projectConfig.ServiceMarkers
+	if len(markers) == 0 {
+		markers = []string{defaultServiceMarker}
+	}
+	var names []string
+	for _, marker := range markers {
+		if !strings.ContainsAny(marker, "*?[") {
+			if info, err := os.Stat(filepath.Join(dir, marker)); err == nil && !info.IsDir() {
+				return marker
+			}
+			continue
+		}
+		if names == nil {
+			entries, err := ioutil.ReadDir(dir)
+			if err != nil {
+				return ""
+			}
+			names = []string{}
+			for _, entry := range entries {
+				if !entry.IsDir() {
+					names = append(names, entry.Name())
+				}
+			}
+		}
+		for _, name := range names {
+			if ok, _ := filepath.Match(marker, name); ok {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// isServiceMarker reports whether the file at path is named like one of the configured service
+// markers.
+func isServiceMarker(path string) bool {
+	markers := projectConfig.ServiceMarkers
+	if len(markers) == 0 {
+		markers = []string{defaultServiceMarker}
+	}
+	name := filepath.Base(path)
+	for _, marker := range markers {
+		if ok, _ := filepath.Match(marker, name); ok || marker == name {
+			return true
+		}
+	}
+	return false
+}
+
+// scanConcurrency is the number of service directories extracted in parallel during the initial scan.
+var scanConcurrency = defaultConcurrency()
+
+// defaultConcurrency returns the number of CPUs, capped at 4 since each Node worker is memory hungry.
+func defaultConcurrency() int {
+	if n := runtime.NumCPU(); n < 4 {
+		return n
+	}
+	return 4
+}
+
+// initialScan walks the project and processes every service directory.
+// The Restate modules are checked while the project is walked and the unchanged services are
+// restored. Manifests are then extracted concurrently, up to scanConcurrency at a time, and the files
+// of each directory are generated in order as soon as its manifest is ready, while the later ones are
+// still extracted.
 func initialScan(root string) {
-	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+	var deps sync.WaitGroup
+	var depsErr error
+	deps.Add(1)
+	go func() {
+		defer deps.Done()
+		defer startup.phase("dependencies")()
+		depsErr = ensureRestateModulesInstalled(projectRoot)
+	}()
+	stop := startup.phase("walk")
+	dirs := serviceDirs(root)
+	stop()
+	startup.scanned(len(dirs))
+	// Services unchanged since the previous run keep their generated files.
+	stop = startup.phase("restore")
+	restored := restoreState(root, dirs)
+	stop()
+	deps.Wait()
+	if depsErr != nil {
+		log.Printf("Error ensuring ReState modules installed: %v", depsErr)
+		return
+	}
+	var stale []string
+	for _, dir := range dirs {
+		if !restored[dir] {
+			stale = append(stale, dir)
 		}
-		if info.IsDir() && !strings.Contains(path, "node_modules") &&
-			!strings.Contains(path, ".gen") &&
-			!strings.Contains(path, "dist") &&
-			!strings.Contains(path, ".build") &&
-			!strings.Contains(path, "restate.gen") {
-			serviceFile := filepath.Join(path, "encore.service.ts")
-			if _, err := os.Stat(serviceFile); err == nil {
-				processDirectory(path)
+	}
+	if len(restored) > 0 {
+		log.Printf("%d of %d services are unchanged since they were last generated", len(restored), len(dirs))
+		if len(stale) > 0 {
+			// Serve the restored services while the others are extracted.
+			if err := generateCentralIndex(root); err != nil {
+				log.Printf("Error generating central index: %v", err)
 			}
 		}
+	}
+	start := time.Now()
+	var extracted time.Time
+	extractInOrder(stale, func(i int, result extraction) {
+		if result.done.After(extracted) {
+			extracted = result.done
+		}
+		defer startup.phase("writing")()
+		unlock := lockDir(stale[i])
+		generateFromManifest(stale[i], result.manifest, result.err)
+		unlock()
+	})
+	if len(stale) > 0 {
+		startup.add("extraction", extracted.Sub(start))
+	}
+}
+
+// serviceDirs returns the service directories below root, including symlinked ones.
+func serviceDirs(root string) []string {
+	if encoreDiscovery() {
+		return encoreServiceDirs(root)
+	}
+	var dirs []string
+	walkDirs(root, func(dir string) error {
+		if serviceMarker(dir) != "" {
+			dirs = append(dirs, dir)
+		}
 		return nil
 	})
+	return dirs
+}
+
+// extraction is the result of extracting the manifest of one directory.
+type extraction struct {
+	manifest *Manifest
+	err      error
+	done     time.Time
+}
+
+// extractAll extracts the manifests of dirs, up to scanConcurrency at a time.
+func extractAll(dirs []string) []extraction {
+	results := make([]extraction, len(dirs))
+	extractInOrder(dirs, func(i int, result extraction) {
+		results[i] = result
+	})
+	return results
+}
+
+// extractInOrder extracts the manifests of dirs, up to scanConcurrency at a time, and calls fn with
+// the result of each directory in the order of dirs, as soon as it and those before it are extracted.
+func extractInOrder(dirs []string, fn func(i int, result extraction)) {
+	results := make([]chan extraction, len(dirs))
+	for i := range results {
+		results[i] = make(chan extraction, 1)
+	}
+	go func() {
+		sem := make(chan struct{}, scanConcurrency)
+		for i, dir := range dirs {
+			sem <- struct{}{}
+			go func(i int, dir string) {
+				defer func() { <-sem }()
+				manifest, err := extractManifest(dir)
+				results[i] <- extraction{manifest, err, time.Now()}
+			}(i, dir)
+		}
+	}()
+	for i := range dirs {
+		fn(i, <-results[i])
+	}
+}
+
+// removeDirectory drops the watches below the removed directory dir and forgets the services in
+// it. It reports false if dir was not a watched directory, e.g. because it was a file.
+func removeDirectory(watcher dirWatcher, dir string) bool {
+	within := func(path string) bool {
+		return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+	}
+	watched := false
+	for _, path := range watcher.WatchList() {
+		if within(path) {
+			watched = true
+			// The watch of a deleted directory may already be gone.
+			watcher.Remove(path)
+		}
+	}
+	generatedDataMapMutex.Lock()
+	var services []string
+	for serviceDir := range generatedDataMap {
+		if within(serviceDir) {
+			services = append(services, serviceDir)
+		}
+	}
+	generatedDataMapMutex.Unlock()
+	for _, serviceDir := range services {
+		// Wait for a generation in progress, which would otherwise store the service again.
+		unlock := lockDir(serviceDir)
+		forgetService(serviceDir)
+		unlock()
+	}
+	return watched || len(services) > 0
 }
 
 var (
@@ -804,10 +2481,184 @@ var (
 	eventCache    sync.Map // key: file path, value: time.Time
 )
 
+// moveWindow is how long after a file is renamed away a file of the same name created in another
+// directory is taken to be the moved file.
+const moveWindow = time.Second
+
+var (
+	// movedFrom maps the names of files renamed or removed recently to their directory and time.
+	movedFrom = make(map[string]movedFile)
+	// movedDirs maps directories files were moved to to the directories they were moved from,
+	// which are processed together with them.
+	movedDirs = make(map[string][]string)
+)
+
+// movedFile is a file renamed or removed from dir at time at.
+type movedFile struct {
+	dir string
+	at  time.Time
+}
+
+// correlateMove pairs the Create of a file with the Rename or Remove of a file of the same name in
+// another directory, so that both directories of a file moved between services are processed in
+// one pass, from the debounce timer of dir, the destination. Callers hold debounceMutex.
+func correlateMove(event fsnotify.Event, dir string) {
+	name := filepath.Base(event.Name)
+	if event.Op&(fsnotify.Rename|fsnotify.Remove) != 0 {
+		for other, from := range movedFrom {
+			if time.Since(from.at) > moveWindow {
+				delete(movedFrom, other)
+			}
+		}
+		movedFrom[name] = movedFile{dir: dir, at: time.Now()}
+		return
+	}
+	if event.Op&fsnotify.Create == 0 {
+		return
+	}
+	from, ok := movedFrom[name]
+	if !ok || from.dir == dir || time.Since(from.at) > moveWindow {
+		return
+	}
+	delete(movedFrom, name)
+	log.Printf("Moved %s from %s to %s", name, from.dir, dir)
+	// The source directory's pending count moves along with it, unless it is already processing.
+	if timer, exists := debounceMap[from.dir]; exists && timer.Stop() {
+		delete(debounceMap, from.dir)
+		movedDirs[dir] = append(movedDirs[dir], from.dir)
+	}
+}
+
+// processDirectories processes dirs in parallel.
+func processDirectories(dirs []string) {
+	var wg sync.WaitGroup
+	for _, dir := range dirs {
+		wg.Add(1)
+		go func(dir string) {
+			defer wg.Done()
+			processDirectory(dir)
+		}(dir)
+	}
+	wg.Wait()
+}
+
+// indexDebounce is how long the central index waits for a burst of changes, e.g. a branch switch,
+// to settle.
+const indexDebounce = 300 * time.Millisecond
+
+var (
+	indexMutex sync.Mutex
+	indexTimer *time.Timer
+	// pendingDirs counts the directories scheduled or being processed. The central index is only
+	// rewritten once there are none.
+	pendingDirs int
+	// indexGenMutex serializes central index generation.
+	indexGenMutex sync.Mutex
+)
+
+// dirPending records a directory scheduled for processing.
+func dirPending() {
+	indexMutex.Lock()
+	pendingDirs++
+	indexMutex.Unlock()
+}
+
+// dirDone records a processed directory and schedules the central index.
+func dirDone() {
+	indexMutex.Lock()
+	pendingDirs--
+	indexMutex.Unlock()
+	scheduleCentralIndex()
+}
+
+// scheduleCentralIndex regenerates the central index once no directory is pending and no further
+// change was scheduled for indexDebounce, so a burst of changes rewrites it once.
+func scheduleCentralIndex() {
+	indexMutex.Lock()
+	defer indexMutex.Unlock()
+	if indexTimer != nil {
+		indexTimer.Stop()
+	}
+	indexTimer = time.AfterFunc(indexDebounce, func() {
+		indexMutex.Lock()
+		if pendingDirs > 0 || paused() {
+			// The last pending directory, or resuming, schedules the index again.
+			indexMutex.Unlock()
+			return
+		}
+		indexTimer = nil
+		indexMutex.Unlock()
+		indexGenMutex.Lock()
+		defer indexGenMutex.Unlock()
+		if err := generateCentralIndex(projectRoot); err != nil {
+			log.Printf("Error generating central index: %v", err)
+		}
+	})
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "clean" {
+		cleanMain(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "generate" {
+		generateMain(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "compose" {
+		composeMain(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "doctor" {
+		doctorMain(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "identity" {
+		identityMain(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "register" {
+		registerMain(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "deployments" {
+		deploymentsMain(os.Args[2:])
+		return
+	}
+	if app := os.Getenv(appEnv); app != "" {
+		log.SetPrefix(app + ": ")
+	}
+	// dev is the watcher with a local Restate server and Encore app started for it.
+	dev := len(os.Args) > 1 && os.Args[1] == "dev"
+	if dev {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+	flag.IntVar(&scanConcurrency, "concurrency", scanConcurrency, "number of service directories to extract in parallel")
+	flag.BoolVar(&typecheckOutput, "typecheck", false, "type-check generated files and report type errors")
+	var poll pollFlag
+	flag.Var(&poll, "poll", "poll for changes instead of using file system events, optionally at an interval such as -poll=2s; -poll=false disables automatic polling on network file systems")
+	flag.DurationVar(&metricsInterval, "metrics-interval", metricsInterval, "interval of logging event and generation counters while watching; 0 logs them on exit only")
+	flag.DurationVar(&healthInterval, "health-interval", healthInterval, "interval of probing the Restate ingress and admin API while watching; 0 disables probing")
+	flag.BoolVar(&noTsconfig, "no-tsconfig", false, "never rewrite tsconfig.json; only check that it maps the ~restate aliases")
+	flag.BoolVar(&offline, "offline", false, "never run the package manager or call webhooks; report missing and incompatible dependencies instead")
+	flag.BoolVar(&upgradeSdk, "upgrade-sdk", false, "upgrade Restate packages whose versions do not fit the generated code")
+	flag.BoolVar(&registerDeployments, "register", false, "register the generated endpoints with the Restate admin API once the Encore app serves them")
+	flag.StringVar(&restateRuntime, "restate-runtime", restateRuntime, "with dev, how to run the local Restate server: docker, binary (restate-server on PATH, or downloaded) or auto")
+	checkFlag := flag.Bool("check", false, "report the tsconfig.json and package.json entries generated code needs but that are missing, as JSON, without modifying any file, and exit with an error if there are any")
+	printManifestsFlag := flag.Bool("print-manifests", false, "print the handlers and endpoints of all services as JSON and exit")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [project root]\n       %s clean [-revert | -deps] [project root]\n       %s compose [project root]\n       %s deployments prune [-dry-run] [-env name] [project root]\n       %s deployments drift [-env name] [project root]\n       %s dev [flags] [project root]\n       %s doctor [project root]\n       %s generate [-v] [-install] [-no-tsconfig] [project root]\n       %s identity fetch [project root]\n       %s register [-env name] [-force] [project root]\n", os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if scanConcurrency < 1 {
+		log.Fatalf("-concurrency must be at least 1")
+	}
+	nodeWorkers = newWorkerPool(scanConcurrency)
+
 	var root string
-	if len(os.Args) > 1 {
-		root = os.Args[1]
+	if flag.NArg() > 0 {
+		root = flag.Arg(0)
 	} else {
 		var err error
 		root, err = os.Getwd()
@@ -817,9 +2668,83 @@ func main() {
 	}
 	// Set global project root.
 	projectRoot = root
+	// Load the optional project configuration.
+	cfg, err := loadConfig(projectRoot)
+	if err != nil {
+		log.Fatalf("Failed to load %s: %v", configFileName, err)
+	}
+	projectConfig = cfg
+	noTsconfig = noTsconfig || cfg.NoTsconfig
+	offline = offline || cfg.Offline
+	registerDeployments = registerDeployments || cfg.Register != nil
+	projectIgnore = newGitignore(root, cfg.Ignore)
+	// A repository of several Encore apps gets a generator per app.
+	if apps := encoreAppDirs(root); len(apps) > 1 {
+		nodeWorkers.stop()
+		if dev {
+			log.Fatalf("%s holds %d Encore apps; run dev in the directory of one of them", root, len(apps))
+		}
+		if err := multiAppMain(root, apps, os.Args[1:len(os.Args)-flag.NArg()]); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+	// Encore apps written in Go get Go bindings instead of TypeScript code.
+	if app, err := readEncoreApp(root); err == nil && app.Lang == encoreLangGo {
+		nodeWorkers.stop()
+		if err := checkGoAppConfig(projectConfig); err != nil {
+			log.Fatalf("%v", err)
+		}
+		goAppMain(root)
+		return
+	}
 	// Detect the package manager used in the project.
 	globalPackageManager = detectPackageManager(projectRoot)
+	if *checkFlag {
+		err := printCheck(root)
+		nodeWorkers.stop()
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+	if *printManifestsFlag {
+		err := printManifests(root)
+		nodeWorkers.stop()
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+	// Stop the Node worker and running installs on shutdown.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		log.Printf("Watch metrics: %s", metrics.summary())
+		cancelInstalls()
+		runningInstalls.Wait()
+		stopDevProcesses()
+		nodeWorkers.stop()
+		os.Exit(0)
+	}()
+	if dev {
+		if err := startDev(root); err != nil {
+			stopDevProcesses()
+			log.Fatalf("%v", err)
+		}
+	}
+	// The fetched identity keys are written to restate.config by the central index generation. They
+	// are fetched while the dependencies are checked.
+	var identity sync.WaitGroup
+	identity.Add(1)
+	go func() {
+		defer identity.Done()
+		defer startup.phase("identity keys")()
+		refreshIdentityKeys(projectRoot)
+	}()
 	// On init, check for required ReState modules without auto-installing.
+	stopChecks := startup.phase("dependencies")
 	installed, err := checkRestateModules(projectRoot)
 	if err != nil {
 		log.Printf("Warning: could not check ReState modules: %v", err)
@@ -827,84 +2752,201 @@ func main() {
 	} else {
 		restatedModulesInstalled = installed
 	}
+	if installed {
+		// Otherwise the peer packages are installed together with the Restate modules.
+		if err := ensurePeerPackages(projectRoot); err != nil {
+			log.Printf("Error installing the peer packages: %v", err)
+		}
+	}
+	if err := checkSdkCompatibility(projectRoot); err != nil {
+		log.Printf("Error checking the Restate SDK version: %v", err)
+	}
+	stopChecks()
+	if noTsconfig {
+		if err := checkTsConfig(projectRoot); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	if offline {
+		for _, hook := range projectConfig.Hooks {
+			if hook.URL != "" {
+				log.Printf("Offline: the webhook %s is not called", hook.URL)
+			}
+		}
+		if registerDeployments {
+			log.Printf("Offline: the generated endpoints are not registered with Restate")
+		}
+	}
+	identity.Wait()
+	if id := projectConfig.Identity; id != nil && id.KeysURL != "" && !offline {
+		go watchIdentityKeys(projectRoot)
+	}
 	log.Printf("Monitoring Encore project at: %s", root)
 
 	// On startup, run a full scan.
 	initialScan(root)
-	cleanDanglingGeneratedFiles(root, ".restate.ts")
+	stop := startup.phase("cleanup")
+	cleanDanglingGeneratedFiles(root, ".restate"+outputExt())
+	stop()
+	stop = startup.phase("index")
 	if err := generateCentralIndex(root); err != nil {
 		log.Printf("Error generating central index: %v", err)
 	}
+	stop()
+	// Services kept from the previous run are registered too, in case Restate lost them.
+	generatedDataMapMutex.Lock()
+	for _, data := range generatedDataMap {
+		registerDeployment(data)
+	}
+	generatedDataMapMutex.Unlock()
 
 	// Update tsconfig.json with the required paths and include rules.
-	if err := updateTsConfig(projectRoot); err != nil {
-		log.Printf("Error updating tsconfig.json: %v", err)
+	if !noTsconfig {
+		if err := updateTsConfig(projectRoot); err != nil {
+			log.Printf("Error updating tsconfig.json: %v", err)
+		}
+		if err := updateTestRunnerConfig(projectRoot); err != nil {
+			log.Printf("Error updating the test runner configuration: %v", err)
+		}
+	}
+	if projectConfig.Aliases == aliasesImports {
+		if err := updatePackageImports(projectRoot); err != nil {
+			log.Printf("Error updating package.json: %v", err)
+		}
 	}
+	startup.report()
 
-	// Set up file watcher.
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		log.Fatal(err)
+	if encoreApp != nil {
+		encoreApp.launch()
+	}
+
+	if metricsInterval > 0 {
+		go metrics.logPeriodically(metricsInterval)
+	}
+	if healthInterval > 0 && !offline {
+		go watchRestateHealth(healthInterval)
+	}
+
+	// Set up file watcher, polling where file system events are unreliable.
+	var watcher dirWatcher
+	var events <-chan fsnotify.Event
+	var watchErrors <-chan error
+	var fallback *fallbackWatcher
+	if interval := pollInterval(&poll, root); interval > 0 {
+		p := newPoller(interval)
+		watcher, events, watchErrors = p, p.Events, p.Errors
+	} else {
+		w, err := newFallbackWatcher()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fallback = w
+		watcher, events, watchErrors = w, w.Events, w.Errors
 	}
 	defer watcher.Close()
 
 	go func() {
 		for {
 			select {
-			case event, ok := <-watcher.Events:
+			case event, ok := <-events:
 				if !ok {
 					return
 				}
-				// If a new directory is created, add it to the watcher.
+				metrics.event()
+				if paused() {
+					continue
+				}
+				// Re-read a changed .gitignore on next use.
+				if filepath.Base(event.Name) == ".gitignore" {
+					projectIgnore.forget(filepath.Dir(event.Name))
+					continue
+				}
+				// Dependencies may have been removed from package.json or a lockfile.
+				if isDependencyFile(event.Name) {
+					if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) != 0 {
+						recheckDependencies()
+					}
+					continue
+				}
+				if event.Name == filepath.Join(projectRoot, "tsconfig.json") {
+					if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+						reapplyTsConfig()
+					}
+					continue
+				}
+				// If a new directory is created, e.g. the new name of a renamed one, watch it and
+				// its subdirectories, and process the service directories among them.
 				if event.Op&fsnotify.Create != 0 {
 					if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
-						if err := watcher.Add(event.Name); err != nil {
-							log.Printf("Error adding new directory %s to watcher: %v", event.Name, err)
+						if projectIgnore.ignored(event.Name, true) {
+							continue
 						}
-						// Optionally, process the new directory if it might contain an encore.service.ts.
-						processDirectory(event.Name)
+						walkDirs(event.Name, func(dir string) error {
+							if err := watcher.Add(dir); err != nil {
+								log.Printf("Error adding new directory %s to watcher: %v", dir, err)
+							}
+							processDirectory(dir)
+							return nil
+						})
+						scheduleCentralIndex()
 						continue // Skip further file processing for directories.
 					}
 				}
 
-				if event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+				// A removed directory, or the old name of a renamed one, takes the watches and
+				// generated endpoints of the service directories within it along.
+				if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 && removeDirectory(watcher, event.Name) {
+					scheduleCentralIndex()
+					continue
+				}
+
+				if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) != 0 {
 					// Existing file handling logic (only for .ts files and valid paths)
-					if strings.HasSuffix(event.Name, ".ts") &&
-						!strings.Contains(event.Name, "node_modules") &&
-						!strings.Contains(event.Name, ".restate.ts") &&
-						!strings.Contains(event.Name, ".gen") &&
-						!strings.Contains(event.Name, "dist") &&
-						!strings.Contains(event.Name, ".build") &&
-						!strings.Contains(event.Name, "restate.gen") {
+					// Marker files count even if they are not source files, e.g. a configured
+					// service.json, so that deleting one removes the service's generated code.
+					marker := isServiceMarker(event.Name)
+					if (isSourceFile(event.Name) || marker) && !strings.Contains(event.Name, ".restate.") &&
+						!projectIgnore.ignored(event.Name, false) {
 
 						// Check for duplicate events for this file.
 						if lastRaw, ok := eventCache.Load(event.Name); ok {
 							lastTime := lastRaw.(time.Time)
 							if time.Since(lastTime) < 100*time.Millisecond {
+								metrics.duplicate()
 								continue // skip duplicate event
 							}
 						}
 						eventCache.Store(event.Name, time.Now())
 
 						dir := filepath.Dir(event.Name)
-						log.Printf("Change detected: %s", event.Name)
+						if marker && event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+							log.Printf("Service marker removed: %s", event.Name)
+						} else {
+							log.Printf("Change detected: %s", event.Name)
+						}
 						debounceMutex.Lock()
-						if timer, exists := debounceMap[dir]; exists {
-							timer.Stop()
+						// A directory counts as pending once, however often its timer is reset.
+						if timer, exists := debounceMap[dir]; !exists || !timer.Stop() {
+							dirPending()
 						}
+						correlateMove(event, dir)
 						debounceMap[dir] = time.AfterFunc(100*time.Millisecond, func() {
-							processDirectory(dir)
+							debounceMutex.Lock()
+							dirs := append([]string{dir}, movedDirs[dir]...)
+							delete(movedDirs, dir)
+							debounceMutex.Unlock()
+							processDirectories(dirs)
 							debounceMutex.Lock()
 							delete(debounceMap, dir)
 							debounceMutex.Unlock()
-							if err := generateCentralIndex(projectRoot); err != nil {
-								log.Printf("Error generating central index: %v", err)
+							for range dirs {
+								dirDone()
 							}
 						})
 						debounceMutex.Unlock()
 					}
 				}
-			case err, ok := <-watcher.Errors:
+			case err, ok := <-watchErrors:
 				if !ok {
 					return
 				}
@@ -913,26 +2955,31 @@ func main() {
 		}
 	}()
 
-	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if info.IsDir() {
-			if strings.Contains(path, "node_modules") ||
-				strings.Contains(path, ".gen") ||
-				strings.Contains(path, "dist") ||
-				strings.Contains(path, ".build") ||
-				strings.Contains(path, ".restate.ts") ||
-				strings.Contains(path, "restate.gen") {
-				return filepath.SkipDir
-			}
-			return watcher.Add(path)
-		}
-		return nil
+	// Symlinked directories are watched through the link, so events carry the same paths as the
+	// initial scan.
+	err = walkDirs(root, func(dir string) error {
+		return watcher.Add(dir)
 	})
 	if err != nil {
 		log.Fatal(err)
 	}
+	// Pause and resume generation on signals.
+	if pause, resume := pauseSignals(); pause != nil {
+		control := make(chan os.Signal, 1)
+		signal.Notify(control, pause, resume)
+		go func() {
+			for sig := range control {
+				if sig == pause {
+					pauseGeneration()
+				} else {
+					resumeGeneration(root, watcher)
+				}
+			}
+		}()
+	}
+	if fallback != nil && fallback.Polled() > 0 {
+		log.Printf("%d of %d directories are polled instead of watched", fallback.Polled(), len(watcher.WatchList()))
+	}
 
 	select {}
 }
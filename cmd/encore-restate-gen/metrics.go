@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// watchMetrics counts what the watcher did, to diagnose slow regeneration on big projects.
+type watchMetrics struct {
+	mu          sync.Mutex
+	events      int // file system events received
+	deduped     int // events skipped as duplicates of a recent one
+	generations int // service directories processed
+	latency     time.Duration
+	failures    map[string]int // failed extractions per service directory
+	changed     bool           // since the last logged summary
+}
+
+// metrics are the counters of this run, logged every -metrics-interval and on shutdown.
+var metrics = &watchMetrics{failures: make(map[string]int)}
+
+// metricsInterval is the interval of -metrics-interval; 0 logs the summary on shutdown only.
+var metricsInterval = 5 * time.Minute
+
+// event counts a received file system event.
+func (m *watchMetrics) event() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events++
+	m.changed = true
+}
+
+// duplicate counts an event skipped as a duplicate of a recent one.
+func (m *watchMetrics) duplicate() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deduped++
+}
+
+// generation counts a processed service directory and how long it took.
+func (m *watchMetrics) generation(dir string, took time.Duration, failed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.generations++
+	m.latency += took
+	if failed {
+		m.failures[dir]++
+	}
+	m.changed = true
+}
+
+// summary describes the counters in one line.
+func (m *watchMetrics) summary() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.changed = false
+	var average time.Duration
+	if m.generations > 0 {
+		average = (m.latency / time.Duration(m.generations)).Round(time.Millisecond)
+	}
+	s := fmt.Sprintf("%d events (%d deduplicated), %d generations, %v average generation time",
+		m.events, m.deduped, m.generations, average)
+	if len(m.failures) > 0 {
+		var failures []string
+		for dir, n := range m.failures {
+			failures = append(failures, fmt.Sprintf("%s (%d)", dir, n))
+		}
+		sort.Strings(failures)
+		s += ", failures: " + strings.Join(failures, ", ")
+	}
+	return s
+}
+
+// logPeriodically logs the summary every interval in which something happened.
+func (m *watchMetrics) logPeriodically(interval time.Duration) {
+	for range time.Tick(interval) {
+		m.mu.Lock()
+		changed := m.changed
+		m.mu.Unlock()
+		if changed {
+			log.Printf("Watch metrics: %s", m.summary())
+		}
+	}
+}
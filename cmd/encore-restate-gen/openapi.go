@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+)
+
+// openAPIFileName is the OpenAPI description written to restate.gen.
+const openAPIFileName = "openapi.restate.json"
+
+// Default base URLs of the OpenAPI servers. The ingress URL honours RESTATE_SERVER_URL at generation time.
+const (
+	defaultEncoreURL  = "http://localhost:4000"
+	defaultIngressURL = "http://localhost:8080"
+)
+
+// restateComponents maps handler types to the Restate component suffix and whether calls are keyed.
+var restateComponents = map[string]struct {
+	Suffix string
+	Keyed  bool
+}{
+	"service":       {"Service", false},
+	"workflow":      {"Workflow", true},
+	"virtualObject": {"Object", true},
+}
+
+// generateOpenAPI writes an OpenAPI 3 description of the generated invoke, discovery and health
+// endpoints, and of the corresponding Restate ingress paths, to restate.gen/openapi.restate.json.
+func generateOpenAPI(root string, datas []TemplateData) error {
+	ingressServers := []map[string]string{{"url": ingressURL(), "description": "Restate ingress"}}
+
+	sort.Slice(datas, func(i, j int) bool { return datas[i].ServiceName < datas[j].ServiceName })
+	paths := map[string]interface{}{}
+	for _, data := range datas {
+		tag := data.ServiceName
+		paths["/"+data.ServiceName+"/discover"] = map[string]interface{}{
+			"get": operation(tag, "Restate service discovery", nil, nil, false),
+		}
+		paths["/"+data.ServiceName+"/restate/health"] = map[string]interface{}{
+			"get": operation(tag, "Readiness of the Restate endpoint", nil, nil, false),
+		}
+		groups := map[string][]GroupedHandler{
+			"service":       data.ServiceGroup,
+			"workflow":      data.WorkflowGroup,
+			"virtualObject": data.VirtualObjectGroup,
+		}
+		for handlerType, group := range groups {
+			component := data.ServiceNameTrimmed + restateComponents[handlerType].Suffix
+			for _, g := range group {
+				for _, h := range g.Handlers {
+					summary := firstLine(h.Doc)
+					paths["/"+data.ServiceName+"/invoke/"+component+"/"+h.ExportName] = map[string]interface{}{
+						"post": operation(tag, summary, h.InputSchema, h.OutputSchema, false),
+					}
+					ingressPath := "/" + component + "/" + h.ExportName
+					if restateComponents[handlerType].Keyed {
+						ingressPath = "/" + component + "/{key}/" + h.ExportName
+					}
+					ingress := operation(tag, summary, h.InputSchema, h.OutputSchema, restateComponents[handlerType].Keyed)
+					ingress["tags"] = []string{"Restate ingress"}
+					paths[ingressPath] = map[string]interface{}{
+						"servers": ingressServers,
+						"post":    ingress,
+					}
+				}
+			}
+		}
+		for _, def := range data.Definitions {
+			keyed := restateComponents[def.Type].Keyed
+			for _, h := range def.Handlers {
+				paths["/"+data.ServiceName+"/invoke/"+def.Name+"/"+h] = map[string]interface{}{
+					"post": operation(tag, "", nil, nil, false),
+				}
+				ingressPath := "/" + def.Name + "/" + h
+				if keyed {
+					ingressPath = "/" + def.Name + "/{key}/" + h
+				}
+				ingress := operation(tag, "", nil, nil, keyed)
+				ingress["tags"] = []string{"Restate ingress"}
+				paths[ingressPath] = map[string]interface{}{
+					"servers": ingressServers,
+					"post":    ingress,
+				}
+			}
+		}
+	}
+
+	doc := map[string]interface{}{
+		"openapi": "3.1.0",
+		"info": map[string]string{
+			"title":       "Restate endpoints generated by encore-restate-gen",
+			"description": "This file is automatically generated by encore-restate-gen. Do not edit this file directly.",
+			"version":     "1.0.0",
+		},
+		"servers": []map[string]string{{"url": defaultEncoreURL, "description": "Encore app"}},
+		"paths":   paths,
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(root, "restate.gen", openAPIFileName), append(data, '\n'), 0644)
+}
+
+// operation builds an OpenAPI operation object. Schemas may be nil when types could not be extracted.
+func operation(tag, summary string, input, output json.RawMessage, keyed bool) map[string]interface{} {
+	op := map[string]interface{}{
+		"tags": []string{tag},
+	}
+	if summary != "" {
+		op["summary"] = summary
+	}
+	if keyed {
+		op["parameters"] = []map[string]interface{}{{
+			"name":     "key",
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]string{"type": "string"},
+		}}
+	}
+	if input != nil {
+		op["requestBody"] = map[string]interface{}{
+			"content": map[string]interface{}{"application/json": map[string]interface{}{"schema": input}},
+		}
+	}
+	response := map[string]interface{}{"description": "OK"}
+	if output != nil {
+		response["content"] = map[string]interface{}{"application/json": map[string]interface{}{"schema": output}}
+	}
+	op["responses"] = map[string]interface{}{"200": response}
+	return op
+}
+
+// firstLine returns the first line of s.
+func firstLine(s string) string {
+	for i, r := range s {
+		if r == '\n' {
+			return s[:i]
+		}
+	}
+	return s
+}
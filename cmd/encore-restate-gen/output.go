@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// Output modes selected by the "output" config key.
+const (
+	outputTypeScript = "ts"
+	outputJavaScript = "js"
+)
+
+// outputExt returns the extension of generated source files for the configured output mode.
+func outputExt() string {
+	if projectConfig.Output == outputJavaScript {
+		return ".js"
+	}
+	return ".ts"
+}
+
+// Restate SDK handlers selected by the "endpointHandler" config key.
+const (
+	endpointFetch  = "fetch"
+	endpointLambda = "lambda"
+)
+
+// Aliasing modes selected by the "aliases" config key.
+const (
+	aliasesPaths   = "paths"
+	aliasesImports = "imports"
+)
+
+// restateImport returns the module specifier generated code imports the generated module sub,
+// such as "/services", through: a ~restate path alias, or a #restate subpath import.
+func restateImport(sub string) string {
+	if projectConfig.Aliases == aliasesImports {
+		return "#restate" + sub
+	}
+	return "~restate" + sub
+}
+
+// isSourceFile reports whether path is a handler source file that should trigger regeneration.
+func isSourceFile(path string) bool {
+	if strings.HasSuffix(path, ".d.ts") {
+		return false
+	}
+	return strings.HasSuffix(path, ".ts") || (projectConfig.Output == outputJavaScript && strings.HasSuffix(path, ".js"))
+}
+
+// importPath converts a relative file path into an extensionless module specifier.
+func importPath(rel string) string {
+	rel = filepath.ToSlash(rel)
+	return strings.TrimSuffix(rel, filepath.Ext(rel))
+}
+
+// writeTemplate renders a template to filePath. In JavaScript output mode filePath names the .js
+// output: the template is rendered to a sibling .ts file, compiled to .js and .d.ts, and the .ts
+// file is removed. Outputs left behind by the other mode are removed.
+func writeTemplate(filePath, name, text string, data interface{}) error {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return err
+	}
+	base := strings.TrimSuffix(filePath, filepath.Ext(filePath))
+	tsPath := base + ".ts"
+	f, err := os.Create(tsPath)
+	if err != nil {
+		return err
+	}
+	if err := tmpl.Execute(f, data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if typecheckOutput {
+		typecheck(tsPath)
+	}
+	if filepath.Ext(filePath) != ".js" {
+		removeOtherOutput(filePath)
+		return nil
+	}
+	defer os.Remove(tsPath)
+	return emitJavaScript(tsPath)
+}
+
+// removeOtherOutput removes the counterpart of filePath generated by the other output mode.
+func removeOtherOutput(filePath string) {
+	base := strings.TrimSuffix(filePath, filepath.Ext(filePath))
+	if filepath.Ext(filePath) == ".js" {
+		os.Remove(base + ".ts")
+		return
+	}
+	os.Remove(base + ".js")
+	os.Remove(base + ".d.ts")
+}
+
+// emitJavaScript compiles generated .ts files to .js and .d.ts files next to them.
+func emitJavaScript(files ...string) error {
+	if _, err := nodeWorkers.call(workerRequest{Op: "emitJs", ProjectRoot: projectRoot, Files: files}); err != nil {
+		return fmt.Errorf("failed to compile %s to JavaScript: %v", strings.Join(files, ", "), err)
+	}
+	return nil
+}
+
+// typecheckOutput is set by -typecheck: every generated file is type-checked after it is written.
+var typecheckOutput bool
+
+// typecheck type-checks generated .ts files with the project's tsconfig and logs the problems
+// found in them.
+func typecheck(files ...string) {
+	result, err := nodeWorkers.call(workerRequest{Op: "typecheck", ProjectRoot: projectRoot, Files: files})
+	if err != nil {
+		log.Printf("Failed to type-check %s: %v", strings.Join(files, ", "), err)
+		return
+	}
+	var diags []Diagnostic
+	if err := json.Unmarshal(result, &diags); err != nil {
+		log.Printf("Failed to parse type-check result: %v", err)
+		return
+	}
+	if errs := countErrors(diags); errs > 0 {
+		log.Printf("Generated file %s has %d type error(s):", strings.Join(files, ", "), errs)
+	}
+	printDiagnostics(diags)
+}
+
+// removeGenerated removes a generated file, along with its declaration file in JavaScript output mode.
+func removeGenerated(path string) {
+	os.Remove(path)
+	if strings.HasSuffix(path, ".js") {
+		os.Remove(strings.TrimSuffix(path, ".js") + ".d.ts")
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sync/atomic"
+)
+
+// generationPaused is 1 while generation is paused, see pauseGeneration.
+var generationPaused int32
+
+// paused reports whether generation is paused.
+func paused() bool {
+	return atomic.LoadInt32(&generationPaused) == 1
+}
+
+// pauseGeneration stops regenerating on changes, e.g. during a large refactor or git rebase, until
+// resumeGeneration is called.
+func pauseGeneration() {
+	if atomic.CompareAndSwapInt32(&generationPaused, 0, 1) {
+		log.Printf("Generation paused; changes are picked up when it is resumed")
+	}
+}
+
+// resumeGeneration resumes generation with a reconciliation pass over the whole project.
+func resumeGeneration(root string, watcher dirWatcher) {
+	if atomic.CompareAndSwapInt32(&generationPaused, 1, 0) {
+		log.Printf("Generation resumed; reconciling the project")
+		reconcile(root, watcher)
+	}
+}
+
+// reconcile brings the watches and generated files in line with the project after changes that
+// were not processed: services are extracted again unless their inputs are unchanged, and those
+// that are gone are removed.
+func reconcile(root string, watcher dirWatcher) {
+	projectIgnore.forgetAll()
+	for _, dir := range watcher.WatchList() {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			removeDirectory(watcher, dir)
+		}
+	}
+	walkDirs(root, func(dir string) error {
+		if err := watcher.Add(dir); err != nil {
+			log.Printf("Error adding directory %s to watcher: %v", dir, err)
+		}
+		return nil
+	})
+	services := make(map[string]bool)
+	for _, dir := range serviceDirs(root) {
+		services[dir] = true
+	}
+	generatedDataMapMutex.Lock()
+	var gone []string
+	for dir := range generatedDataMap {
+		if !services[dir] {
+			gone = append(gone, dir)
+		}
+	}
+	generatedDataMapMutex.Unlock()
+	for _, dir := range gone {
+		unlock := lockDir(dir)
+		forgetService(dir)
+		unlock()
+	}
+	reapplyTsConfig()
+	recheckDependencies()
+	initialScan(root)
+	scheduleCentralIndex()
+}
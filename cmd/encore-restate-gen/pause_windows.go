@@ -0,0 +1,10 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// pauseSignals returns nil signals, since Windows has no user-defined signals.
+func pauseSignals() (pause, resume os.Signal) {
+	return nil, nil
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// dirWatcher reports changes to the entries of registered directories. fsnotify.Watcher implements
+// it, and so does poller for file systems where fsnotify misses events.
+type dirWatcher interface {
+	Add(name string) error
+	Remove(name string) error
+	WatchList() []string
+	Close() error
+}
+
+// defaultPollInterval is the interval of -poll without a value, and of automatic polling.
+const defaultPollInterval = time.Second
+
+// pollFlag is the value of -poll: "true" polls at the default interval, a duration at that
+// interval, "false" never polls. Unset, polling is enabled where file system events are unreliable.
+type pollFlag struct {
+	set      bool
+	interval time.Duration
+}
+
+func (f *pollFlag) String() string {
+	if f == nil || !f.set {
+		return ""
+	}
+	return f.interval.String()
+}
+
+// IsBoolFlag lets -poll be given without a value.
+func (f *pollFlag) IsBoolFlag() bool { return true }
+
+func (f *pollFlag) Set(s string) error {
+	f.set = true
+	switch s {
+	case "true":
+		f.interval = defaultPollInterval
+	case "false":
+		f.interval = 0
+	default:
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("must be true, false or a positive duration such as 2s")
+		}
+		f.interval = d
+	}
+	return nil
+}
+
+// pollInterval returns the interval to poll root at, or 0 to use file system events.
+func pollInterval(f *pollFlag, root string) time.Duration {
+	if f.set {
+		return f.interval
+	}
+	if reason := unreliableEvents(root); reason != "" {
+		log.Printf("Polling for changes every %v, since %s; pass -poll=false to use file system events", defaultPollInterval, reason)
+		return defaultPollInterval
+	}
+	return 0
+}
+
+// fileStamp is what the poller compares to detect a changed directory entry.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+	isDir   bool
+}
+
+// poller detects changes by listing the registered directories periodically, and reports them as
+// the events fsnotify would: Create and Remove for added and removed entries, Write for modified
+// files.
+type poller struct {
+	Events chan fsnotify.Event
+	Errors chan error
+
+	mu      sync.Mutex
+	entries map[string]map[string]fileStamp // registered directories and their entries
+	done    chan struct{}
+}
+
+// newPoller starts polling at interval. Directories are polled once registered with Add.
+func newPoller(interval time.Duration) *poller {
+	p := &poller{
+		Events:  make(chan fsnotify.Event, 64),
+		Errors:  make(chan error, 1),
+		entries: make(map[string]map[string]fileStamp),
+		done:    make(chan struct{}),
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.poll()
+			case <-p.done:
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// list returns the entries of dir.
+func list(dir string) (map[string]fileStamp, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]fileStamp, len(infos))
+	for _, info := range infos {
+		entries[info.Name()] = fileStamp{modTime: info.ModTime(), size: info.Size(), isDir: info.IsDir()}
+	}
+	return entries, nil
+}
+
+// Add registers dir; changes from its current entries are reported from the next poll on.
+func (p *poller) Add(dir string) error {
+	entries, err := list(dir)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.entries[dir] = entries
+	p.mu.Unlock()
+	return nil
+}
+
+// Remove unregisters dir.
+func (p *poller) Remove(dir string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.entries[dir]; !ok {
+		return fmt.Errorf("%s is not watched", dir)
+	}
+	delete(p.entries, dir)
+	return nil
+}
+
+// WatchList returns the registered directories.
+func (p *poller) WatchList() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	dirs := make([]string, 0, len(p.entries))
+	for dir := range p.entries {
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+// Close stops polling.
+func (p *poller) Close() error {
+	close(p.done)
+	return nil
+}
+
+// poll lists every registered directory and sends events for the entries that changed.
+func (p *poller) poll() {
+	var events []fsnotify.Event
+	p.mu.Lock()
+	for dir, previous := range p.entries {
+		current, err := list(dir)
+		if err != nil {
+			// The directory is gone; its parent reports the removal.
+			continue
+		}
+		for name, stamp := range current {
+			old, ok := previous[name]
+			switch {
+			case !ok:
+				events = append(events, fsnotify.Event{Name: filepath.Join(dir, name), Op: fsnotify.Create})
+			case !stamp.isDir && (!stamp.modTime.Equal(old.modTime) || stamp.size != old.size):
+				events = append(events, fsnotify.Event{Name: filepath.Join(dir, name), Op: fsnotify.Write})
+			}
+		}
+		for name := range previous {
+			if _, ok := current[name]; !ok {
+				events = append(events, fsnotify.Event{Name: filepath.Join(dir, name), Op: fsnotify.Remove})
+			}
+		}
+		p.entries[dir] = current
+	}
+	p.mu.Unlock()
+	// Sent unlocked, since handling an event may register or unregister directories.
+	sort.Slice(events, func(i, j int) bool { return events[i].Name < events[j].Name })
+	for _, event := range events {
+		select {
+		case p.Events <- event:
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// fallbackWatcher watches directories with fsnotify and polls those it cannot watch because the
+// inotify watch limit is reached.
+type fallbackWatcher struct {
+	*fsnotify.Watcher
+	Events chan fsnotify.Event
+	Errors chan error
+
+	mu     sync.Mutex
+	poller *poller // started once the limit is reached
+}
+
+// newFallbackWatcher creates a fallbackWatcher, which forwards the events of both mechanisms.
+func newFallbackWatcher() (*fallbackWatcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	f := &fallbackWatcher{Watcher: w, Events: make(chan fsnotify.Event, 64), Errors: make(chan error, 1)}
+	go f.forward(w.Events, w.Errors)
+	return f, nil
+}
+
+// forward sends events and errors on to the watcher's channels until events is closed.
+func (f *fallbackWatcher) forward(events <-chan fsnotify.Event, errs <-chan error) {
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			f.Events <- event
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			f.Errors <- err
+		}
+	}
+}
+
+// Add watches dir, or polls it if the watch limit is reached.
+func (f *fallbackWatcher) Add(dir string) error {
+	err := f.Watcher.Add(dir)
+	if !watchLimitReached(err) {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.poller == nil {
+		log.Printf("The inotify watch limit (fs.inotify.max_user_watches = %s) is reached; directories that cannot be watched are polled every %v instead. Raise the limit with: sudo sysctl fs.inotify.max_user_watches=524288",
+			maxUserWatches(), defaultPollInterval)
+		f.poller = newPoller(defaultPollInterval)
+		go f.forward(f.poller.Events, f.poller.Errors)
+	}
+	return f.poller.Add(dir)
+}
+
+// Remove stops watching or polling dir.
+func (f *fallbackWatcher) Remove(dir string) error {
+	if p := f.polling(); p != nil && p.Remove(dir) == nil {
+		return nil
+	}
+	return f.Watcher.Remove(dir)
+}
+
+// WatchList returns the watched and the polled directories.
+func (f *fallbackWatcher) WatchList() []string {
+	dirs := f.Watcher.WatchList()
+	if p := f.polling(); p != nil {
+		dirs = append(dirs, p.WatchList()...)
+	}
+	return dirs
+}
+
+// Polled returns the number of directories polled instead of watched.
+func (f *fallbackWatcher) Polled() int {
+	if p := f.polling(); p != nil {
+		return len(p.WatchList())
+	}
+	return 0
+}
+
+// Close stops watching and polling.
+func (f *fallbackWatcher) Close() error {
+	if p := f.polling(); p != nil {
+		p.Close()
+	}
+	return f.Watcher.Close()
+}
+
+func (f *fallbackWatcher) polling() *poller {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.poller
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"syscall"
+)
+
+// File system magic numbers, see statfs(2), of file systems that do not deliver inotify events for
+// changes made outside the machine or container.
+var remoteFilesystems = map[uint32]string{
+	0x6969:     "NFS",
+	0xff534d42: "CIFS",
+	0xfe534d42: "SMB2",
+	0x01021997: "9p",
+	0x65735546: "FUSE",
+	0x6a656a63: "virtiofs",
+}
+
+// unreliableEvents returns why file system events may be missed for root, e.g. because it is on a
+// network file system, a Docker Desktop bind mount or a Windows drive under WSL, or "" if they
+// are reliable.
+func unreliableEvents(root string) string {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(root, &st); err == nil {
+		if name, ok := remoteFilesystems[uint32(st.Type)]; ok {
+			return "the project is on a " + name + " file system"
+		}
+	}
+	if version, err := ioutil.ReadFile("/proc/version"); err == nil &&
+		strings.Contains(strings.ToLower(string(version)), "microsoft") && strings.HasPrefix(realDir(root), "/mnt/") {
+		return "the project is on a Windows drive under WSL"
+	}
+	return ""
+}
+
+// watchLimitReached reports whether err means that fs.inotify.max_user_watches is exhausted.
+func watchLimitReached(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
+
+// maxUserWatches returns the inotify watch limit, or "unknown".
+func maxUserWatches() string {
+	data, err := ioutil.ReadFile("/proc/sys/fs/inotify/max_user_watches")
+	if err != nil {
+		return "unknown"
+	}
+	return strings.TrimSpace(string(data))
+}
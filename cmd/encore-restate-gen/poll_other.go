@@ -0,0 +1,20 @@
+//go:build !linux
+
+package main
+
+// unreliableEvents returns why file system events may be missed for root, or "" if they are
+// reliable. Detection is only implemented on Linux.
+func unreliableEvents(root string) string {
+	return ""
+}
+
+// watchLimitReached reports whether err means that the watch limit is exhausted, which only
+// inotify on Linux has.
+func watchLimitReached(err error) bool {
+	return false
+}
+
+// maxUserWatches returns the inotify watch limit; there is none outside Linux.
+func maxUserWatches() string {
+	return "unknown"
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of the register config section.
+const (
+	defaultAdminURL = "http://localhost:9070"
+	// registerReadyTimeout bounds the wait for the Encore app to serve a generated endpoint.
+	registerReadyTimeout = 2 * time.Minute
+	// registerDebounce lets a burst of regenerations settle before registering.
+	registerDebounce = time.Second
+)
+
+// localEnvironment names the environment of the register section, the one watching registers in.
+const localEnvironment = "local"
+
+// targetEnvironment is the environment admin calls go to, selected with -env; nil for local.
+var targetEnvironment *EnvironmentConfig
+
+// registerDeployments is set by -register or the register config section: generated endpoints are
+// registered with the Restate admin API once the Encore app serves them.
+var registerDeployments bool
+
+var (
+	registerMutex sync.Mutex
+	// pendingRegister and registeredServices are keyed by the generated file's directory.
+	pendingRegister    = make(map[string]TemplateData)
+	registeredServices = make(map[string]TemplateData)
+	registerWake       = make(chan struct{}, 1)
+	registerOnce       sync.Once
+)
+
+// adminURL returns the Restate admin API URL: the target environment's, register.adminUrl, the
+// Restate Cloud environment's, RESTATE_ADMIN_URL or http://localhost:9070.
+func adminURL() string {
+	if env := targetEnvironment; env != nil {
+		return strings.TrimSuffix(env.AdminURL, "/")
+	}
+	if r := projectConfig.Register; r != nil && r.AdminURL != "" {
+		return strings.TrimSuffix(r.AdminURL, "/")
+	}
+	if url := cloudURL(cloudAdminPort); url != "" {
+		return url
+	}
+	if url := os.Getenv("RESTATE_ADMIN_URL"); url != "" {
+		return strings.TrimSuffix(url, "/")
+	}
+	return defaultAdminURL
+}
+
+// encoreURL returns the base URL the generator reaches the Encore app at.
+func encoreURL() string {
+	if r := projectConfig.Register; r != nil && r.EncoreURL != "" {
+		return strings.TrimSuffix(r.EncoreURL, "/")
+	}
+	return defaultEncoreURL
+}
+
+// deploymentURL returns the URL Restate reaches the endpoint of service at in the target environment.
+func deploymentURL(service string) string {
+	if env := targetEnvironment; env != nil {
+		return strings.TrimSuffix(env.DeploymentURL, "/") + "/" + service
+	}
+	return localDeploymentURL(service)
+}
+
+// localDeploymentURL returns the URL Restate reaches the endpoint of service at locally.
+func localDeploymentURL(service string) string {
+	base := encoreURL()
+	if r := projectConfig.Register; r != nil && r.DeploymentURL != "" {
+		base = strings.TrimSuffix(r.DeploymentURL, "/")
+	}
+	return base + "/" + service
+}
+
+// selectEnvironment makes name the target environment of admin calls.
+func selectEnvironment(name string) error {
+	if name == localEnvironment {
+		targetEnvironment = nil
+		return nil
+	}
+	env, ok := projectConfig.Environments[name]
+	if !ok {
+		return fmt.Errorf("environment %q is not configured in %s", name, configFileName)
+	}
+	if env.AdminURL == "" {
+		return fmt.Errorf("environments.%s.adminUrl is needed to call the Restate admin API there", name)
+	}
+	targetEnvironment = &env
+	return nil
+}
+
+// registerMain implements the register subcommand, which registers the endpoints of all services
+// in an environment at once, e.g. from a deploy pipeline.
+func registerMain(args []string) {
+	flags := flag.NewFlagSet("register", flag.ExitOnError)
+	env := flags.String("env", localEnvironment, "the environment to register in")
+	force := flags.Bool("force", false, "replace the registrations of the same URLs even if their services changed incompatibly")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s register [flags] [project root]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	root := "."
+	if flags.NArg() > 0 {
+		root = flags.Arg(0)
+	}
+	projectRoot = root
+	cfg, err := loadConfig(root)
+	if err != nil {
+		log.Fatalf("Failed to load %s: %v", configFileName, err)
+	}
+	projectConfig = cfg
+	if err := selectEnvironment(*env); err != nil {
+		log.Fatalf("%v", err)
+	}
+	projectIgnore = newGitignore(root, cfg.Ignore)
+	nodeWorkers = newWorkerPool(scanConcurrency)
+	datas, err := localServices(root)
+	nodeWorkers.stop()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	secret, err := sharedSecretValue()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	failed := 0
+	for _, data := range datas {
+		uri := deploymentURL(data.ServiceName)
+		answer, err := adminRequest("POST", "/deployments", registrationBody(uri, secret, *force))
+		if err != nil {
+			log.Printf("Error registering %s in %s: %v", data.ServiceName, *env, err)
+			failed++
+			continue
+		}
+		var deployment struct {
+			ID string `json:"id"`
+		}
+		json.Unmarshal(answer, &deployment)
+		log.Printf("Registered %s in %s as deployment %s", uri, *env, deployment.ID)
+	}
+	if failed > 0 {
+		log.Fatalf("%d of %d services failed to register", failed, len(datas))
+	}
+}
+
+// registerDeployment queues the registration of the endpoint generated for data, unless it was
+// registered with the same name and handlers before. Registrations run one at a time in the
+// background, after registerDebounce; a service queued again before its turn is registered once.
+func registerDeployment(data TemplateData) {
+	if !registerDeployments || offline {
+		return
+	}
+	registerOnce.Do(func() {
+		go func() {
+			for range registerWake {
+				time.Sleep(registerDebounce)
+				registerMutex.Lock()
+				pending := pendingRegister
+				pendingRegister = make(map[string]TemplateData)
+				registerMutex.Unlock()
+				for dir, data := range pending {
+					if superseded(dir) {
+						continue
+					}
+					if err := registerService(dir, data); err != nil {
+						log.Printf("Error registering %s with Restate: %v", data.ServiceName, err)
+						continue
+					}
+					registerMutex.Lock()
+					previous, had := registeredServices[dir]
+					registeredServices[dir] = data
+					registerMutex.Unlock()
+					if had && previous.ServiceName != data.ServiceName {
+						removeDeployments(deploymentURL(previous.ServiceName))
+					}
+				}
+			}
+		}()
+	})
+	dir := filepath.Dir(data.FilePath)
+	registerMutex.Lock()
+	previous, ok := registeredServices[dir]
+	if ok && previous.ServiceName == data.ServiceName && reflect.DeepEqual(restateComponentsOf(previous), restateComponentsOf(data)) {
+		registerMutex.Unlock()
+		return
+	}
+	pendingRegister[dir] = data
+	registerMutex.Unlock()
+	select {
+	case registerWake <- struct{}{}:
+	default:
+	}
+}
+
+// removeDeployments removes the deployments registered at uri, e.g. of a renamed service.
+func removeDeployments(uri string) {
+	deployments, err := appDeployments()
+	if err != nil {
+		log.Printf("Error listing the deployments to remove %s: %v", uri, err)
+		return
+	}
+	for _, d := range deployments {
+		if d.URI != uri {
+			continue
+		}
+		if _, err := adminRequest("DELETE", "/deployments/"+url.PathEscape(d.ID)+"?force=true", nil); err != nil {
+			log.Printf("Error removing deployment %s at %s: %v", d.ID, uri, err)
+			continue
+		}
+		log.Printf("Removed deployment %s at %s of the renamed service", d.ID, uri)
+	}
+}
+
+// expectedComponents returns the Restate components the endpoint generated for data serves, with
+// their handler names.
+func expectedComponents(data TemplateData) map[string][]string {
+	components := make(map[string][]string)
+	for _, c := range restateComponentsOf(data) {
+		components[c.Name] = c.Handlers
+	}
+	return components
+}
+
+// checkServed returns nil if the discovery endpoint of data's service answers with every component
+// and handler generated for it, and not with those of a previous build still running, or else why
+// it does not.
+func checkServed(client *http.Client, data TemplateData, secret string) error {
+	discoverURL := encoreURL() + "/" + data.ServiceName + "/discover"
+	req, err := http.NewRequest("GET", discoverURL, nil)
+	if err != nil {
+		return err
+	}
+	if ss := projectConfig.SharedSecret; ss != nil {
+		req.Header.Set(ss.Header, secret)
+	}
+	req.Header.Set("Accept", "application/vnd.restate.endpointmanifest.v1+json, application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("the Encore app is not reachable at %s; is encore run running?", encoreURL())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%s is not served yet; Encore may still be compiling the generated code", discoverURL)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s; check the Encore app's output for errors", discoverURL, resp.Status)
+	}
+	var manifest struct {
+		Services []struct {
+			Name     string `json:"name"`
+			Handlers []struct {
+				Name string `json:"name"`
+			} `json:"handlers"`
+		} `json:"services"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+		return fmt.Errorf("%s answered with an invalid manifest: %v", discoverURL, err)
+	}
+	served := make(map[string]bool)
+	for _, s := range manifest.Services {
+		for _, h := range s.Handlers {
+			served[s.Name+"/"+h.Name] = true
+		}
+	}
+	var missing []string
+	for component, handlers := range expectedComponents(data) {
+		for _, h := range handlers {
+			if !served[component+"/"+h] {
+				missing = append(missing, component+"/"+h)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("%s does not serve %v yet; Encore may still be running the previous build", discoverURL, missing)
+	}
+	return nil
+}
+
+// registerReadyWait returns how long registration waits for the Encore app to serve a generated
+// endpoint: register.readyTimeoutMs, or registerReadyTimeout.
+func registerReadyWait() time.Duration {
+	if r := projectConfig.Register; r != nil && r.ReadyTimeoutMs > 0 {
+		return time.Duration(r.ReadyTimeoutMs) * time.Millisecond
+	}
+	return registerReadyTimeout
+}
+
+// superseded reports whether the service generated into dir was queued for registration again.
+func superseded(dir string) bool {
+	registerMutex.Lock()
+	defer registerMutex.Unlock()
+	_, ok := pendingRegister[dir]
+	return ok
+}
+
+// registerService waits for the Encore app to serve the endpoint generated for data and registers
+// it with the Restate admin API, replacing an earlier registration of the same URL. The wait ends
+// early, without registering, if the service is generated again meanwhile.
+func registerService(dir string, data TemplateData) error {
+	secret, err := sharedSecretValue()
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	wait := registerReadyWait()
+	deadline := time.Now().Add(wait)
+	delay := 500 * time.Millisecond
+	for waited := false; ; waited = true {
+		notServed := checkServed(client, data, secret)
+		if notServed == nil {
+			break
+		}
+		if !waited {
+			log.Printf("Waiting for the Encore app to serve %s before registering it: %v", data.ServiceName, notServed)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("gave up waiting after %v: %v", wait, notServed)
+		}
+		time.Sleep(delay)
+		if delay < 5*time.Second {
+			delay *= 2
+		}
+		if superseded(dir) {
+			return nil
+		}
+	}
+	uri := deploymentURL(data.ServiceName)
+	answer, err := adminRequest("POST", "/deployments", registrationBody(uri, secret, true))
+	if err != nil {
+		return err
+	}
+	var deployment struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(answer, &deployment)
+	log.Printf("Registered %s with Restate as deployment %s", uri, deployment.ID)
+	return nil
+}
+
+// adminRequest sends a request with body, if not nil, as JSON to path of the Restate admin API and
+// returns the answer. The adminToken is sent as a bearer token.
+func adminRequest(method, path string, body interface{}) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, adminURL()+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	token, tokenEnv := adminToken()
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Restate admin API unreachable at %s: %v", adminURL(), err)
+	}
+	defer resp.Body.Close()
+	answer, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		if token == "" {
+			return nil, fmt.Errorf("%s %s%s answered %s; set the API token in %s", method, adminURL(), path, resp.Status, tokenEnv)
+		}
+		return nil, fmt.Errorf("%s %s%s answered %s; the API token in %s was rejected, it may have expired or been revoked", method, adminURL(), path, resp.Status, tokenEnv)
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s %s%s answered %s: %s", method, adminURL(), path, resp.Status, strings.TrimSpace(string(answer)))
+	}
+	return answer, nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultRegistry is the registry packages are installed from unless configured otherwise.
+const defaultRegistry = "https://registry.npmjs.org/"
+
+// registrySettings are the registry and proxy the package manager installs the Restate modules
+// through, as configured by environment variables, .npmrc files or .yarnrc.yml.
+type registrySettings struct {
+	Registry string
+	Source   string // where Registry is configured
+	Auth     bool   // whether a token or credentials are configured for Registry
+	Proxy    string
+}
+
+// readNpmrc returns the settings of the .npmrc file at path, with ${VAR} references expanded.
+func readNpmrc(path string) map[string]string {
+	settings := make(map[string]string)
+	f, err := os.Open(path)
+	if err != nil {
+		return settings
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if i := strings.IndexByte(line, '='); i > 0 {
+			key := strings.TrimSpace(line[:i])
+			value := strings.Trim(strings.TrimSpace(line[i+1:]), `"'`)
+			settings[key] = os.ExpandEnv(value)
+		}
+	}
+	return settings
+}
+
+// readYarnrc returns the top-level scalar settings of the .yarnrc.yml file at path.
+func readYarnrc(path string) map[string]string {
+	settings := make(map[string]string)
+	f, err := os.Open(path)
+	if err != nil {
+		return settings
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == ' ' || line[0] == '#' {
+			continue
+		}
+		if i := strings.IndexByte(line, ':'); i > 0 {
+			settings[strings.TrimSpace(line[:i])] = os.ExpandEnv(strings.Trim(strings.TrimSpace(line[i+1:]), `"'`))
+		}
+	}
+	return settings
+}
+
+// registryFor returns the registry settings scope's packages are installed from in dir.
+func registryFor(dir, scope string) registrySettings {
+	r := registrySettings{Registry: defaultRegistry, Source: "default"}
+	type source struct {
+		name     string
+		settings map[string]string
+	}
+	var sources []source
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		if i := strings.IndexByte(kv, '='); i > 0 && strings.HasPrefix(strings.ToLower(kv[:i]), "npm_config_") {
+			env[strings.ReplaceAll(strings.ToLower(kv[len("npm_config_"):i]), "_", "-")] = kv[i+1:]
+		}
+	}
+	sources = append(sources, source{"environment", env})
+	yarnrc := filepath.Join(dir, ".yarnrc.yml")
+	if _, err := os.Stat(yarnrc); globalPackageManager == "yarn" && err == nil {
+		// Yarn 2 and later ignore .npmrc.
+		y := readYarnrc(yarnrc)
+		sources = append(sources, source{yarnrc, map[string]string{
+			"registry":    y["npmRegistryServer"],
+			"https-proxy": y["httpsProxy"],
+			"proxy":       y["httpProxy"],
+			"_authToken":  y["npmAuthToken"],
+		}})
+	} else {
+		sources = append(sources, source{filepath.Join(dir, ".npmrc"), readNpmrc(filepath.Join(dir, ".npmrc"))})
+		if home, err := os.UserHomeDir(); err == nil {
+			sources = append(sources, source{filepath.Join(home, ".npmrc"), readNpmrc(filepath.Join(home, ".npmrc"))})
+		}
+	}
+	// The first source setting a key wins.
+	lookup := func(key string) (string, string) {
+		for _, s := range sources {
+			if v := s.settings[key]; v != "" {
+				return v, s.name
+			}
+		}
+		return "", ""
+	}
+	if v, src := lookup(scope + ":registry"); v != "" {
+		r.Registry, r.Source = v, src
+	} else if v, src := lookup("registry"); v != "" {
+		r.Registry, r.Source = v, src
+	}
+	if !strings.HasSuffix(r.Registry, "/") {
+		r.Registry += "/"
+	}
+	// Credentials are keyed by the registry URL without its scheme, e.g. //npm.example.com/:_authToken.
+	if u, err := url.Parse(r.Registry); err == nil {
+		prefix := "//" + u.Host + u.Path
+		for _, key := range []string{prefix + ":_authToken", prefix + ":_auth", prefix + ":_password", "_authToken", "_auth"} {
+			if v, _ := lookup(key); v != "" {
+				r.Auth = true
+				break
+			}
+		}
+	}
+	for _, key := range []string{"https-proxy", "proxy"} {
+		if v, _ := lookup(key); v != "" {
+			r.Proxy = v
+			break
+		}
+	}
+	if r.Proxy == "" {
+		for _, key := range []string{"HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy"} {
+			if v := os.Getenv(key); v != "" {
+				r.Proxy = v
+				break
+			}
+		}
+	}
+	return r
+}
+
+// String describes r in one line, without credentials.
+func (r registrySettings) String() string {
+	auth := "without credentials"
+	if r.Auth {
+		auth = "with credentials"
+	}
+	s := fmt.Sprintf("registry %s (from %s, %s)", r.Registry, r.Source, auth)
+	if r.Proxy != "" {
+		proxy := r.Proxy
+		if u, err := url.Parse(proxy); err == nil && u.User != nil {
+			u.User = nil
+			proxy = u.String()
+		}
+		s += " via proxy " + proxy
+	}
+	return s
+}
+
+// installFailures maps errors package managers print to the likely cause of a failed install.
+// Transient failures are retried.
+var installFailures = []struct {
+	patterns  []string
+	cause     string
+	transient bool
+}{
+	{[]string{"E401", "401 Unauthorized", "ERR_PNPM_FETCH_401", "Unauthorized"}, "the registry rejected the credentials; check the auth token for the registry in .npmrc", false},
+	{[]string{"E403", "403 Forbidden", "ERR_PNPM_FETCH_403"}, "the registry denied access to the packages; check that your token may read @restatedev packages", false},
+	{[]string{"E404", "404 Not Found", "ERR_PNPM_FETCH_404"}, "the registry does not have the packages; a private registry may need to proxy the public one", false},
+	{[]string{"407 Proxy Authentication Required", "E407"}, "the proxy requires credentials; include them in the proxy URL", false},
+	{[]string{"SELF_SIGNED_CERT_IN_CHAIN", "UNABLE_TO_GET_ISSUER_CERT", "UNABLE_TO_VERIFY_LEAF_SIGNATURE", "CERT_"}, "a proxy or registry presents a certificate that is not trusted; set cafile in .npmrc or NODE_EXTRA_CA_CERTS", false},
+	{[]string{"ENOTFOUND"}, "the registry or proxy host cannot be resolved", false},
+	{[]string{"EAI_AGAIN"}, "the registry or proxy host could not be resolved temporarily", true},
+	{[]string{"ECONNREFUSED", "ECONNRESET", "ETIMEDOUT", "ESOCKETTIMEDOUT", "ERR_SOCKET_TIMEOUT"}, "the registry cannot be reached; behind a corporate proxy, set HTTPS_PROXY or https-proxy in .npmrc", true},
+	{[]string{"E500", "E502", "E503", "E504", "ERR_PNPM_FETCH_5", "502 Bad Gateway", "503 Service Unavailable", "504 Gateway Timeout"}, "the registry failed with a server error", true},
+}
+
+// installFailure returns the likely cause of a failed install from the package manager's output,
+// and whether it is transient.
+func installFailure(output string) (string, bool) {
+	for _, failure := range installFailures {
+		for _, pattern := range failure.patterns {
+			if strings.Contains(output, pattern) {
+				return failure.cause, failure.transient
+			}
+		}
+	}
+	return "", false
+}
+
+// diagnoseInstall explains err, a failed install through r, using the package manager's output.
+func diagnoseInstall(err error, output string, r registrySettings) error {
+	if cause, _ := installFailure(output); cause != "" {
+		return fmt.Errorf("%v: %s; installed from %s", err, cause, r)
+	}
+	return fmt.Errorf("%v; installed from %s", err, r)
+}
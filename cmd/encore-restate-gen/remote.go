@@ -0,0 +1,118 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// remoteModuleDir is the directory of restate.gen holding the clients of the handlers of every
+// Encore service, imported as ~restate/remote.
+const remoteModuleDir = "remote"
+
+// RemoteService is an Encore service whose Restate components get clients in the remote module.
+type RemoteService struct {
+	// Helper is the name of the exported clients, the service name with a lower-case first letter.
+	Helper string
+	// Source is the import path of the generated file of the service, relative to the remote module.
+	Source string
+	Data   TemplateData
+}
+
+// ObjectKey returns the key type of the object clients: the declared key type unless it is imported
+// from the service's sources, which the remote module does not import.
+func (s RemoteService) ObjectKey() string {
+	if s.Data.ObjectKeyImport != nil || s.Data.ObjectKeyType == "" {
+		return "string"
+	}
+	return s.Data.ObjectKeyType
+}
+
+// Definitions returns the names of the definitions the generated file of the service exports.
+func (s RemoteService) Definitions() []string {
+	var names []string
+	if len(s.Data.ServiceGroup) > 0 {
+		names = append(names, "_"+s.Data.ServiceNameTrimmed+"Service")
+	}
+	if len(s.Data.WorkflowGroup) > 0 {
+		names = append(names, "_"+s.Data.ServiceNameTrimmed+"Workflow")
+	}
+	if len(s.Data.VirtualObjectGroup) > 0 {
+		names = append(names, "_"+s.Data.ServiceNameTrimmed+"Object")
+	}
+	return names
+}
+
+// remoteTemplate is written to restate.gen/remote/index.ts. The definitions are typed with type-only
+// imports of the generated files, so code calling another service's handlers does not import its
+// sources, and are called by their Restate names through the ingress clients.
+const remoteTemplate = `// This file is automatically generated by encore-restate-gen.
+// Do not edit this file directly.
+
+import {
+  serviceClient,
+  serviceSendClient,
+  objectClient,
+  objectSendClient,
+  workflowClient,
+  type ClientOptions,
+} from "../index";
+{{- range . }}
+import type { {{ range $i, $d := .Definitions }}{{ if $i }}, {{ end }}{{ $d }}{{ end }} } from "{{ .Source }}";
+{{- end }}
+{{ range . }}
+{{- $t := .Data.ServiceNameTrimmed }}
+// The Restate components of the Encore service {{ .Data.ServiceName }}.
+{{- if .Data.ServiceGroup }}
+const {{ $t }}Service = { name: "{{ $t }}Service" } as typeof _{{ $t }}Service;
+{{- end }}
+{{- if .Data.WorkflowGroup }}
+const {{ $t }}Workflow = { name: "{{ $t }}Workflow" } as typeof _{{ $t }}Workflow;
+{{- end }}
+{{- if .Data.VirtualObjectGroup }}
+const {{ $t }}Object = { name: "{{ $t }}Object" } as typeof _{{ $t }}Object;
+{{- end }}
+
+export const {{ .Helper }} = {
+{{- if .Data.ServiceGroup }}
+  service: (opts?: ClientOptions) => serviceClient({{ $t }}Service, opts),
+  serviceSend: (opts?: ClientOptions) => serviceSendClient({{ $t }}Service, opts),
+{{- end }}
+{{- if .Data.WorkflowGroup }}
+  workflow: (key: string, opts?: ClientOptions) => workflowClient({{ $t }}Workflow, key, opts),
+{{- end }}
+{{- if .Data.VirtualObjectGroup }}
+  object: (key: {{ .ObjectKey }}, opts?: ClientOptions) => objectClient({{ $t }}Object, String(key), opts),
+  objectSend: (key: {{ .ObjectKey }}, opts?: ClientOptions) => objectSendClient({{ $t }}Object, String(key), opts),
+{{- end }}
+};
+{{ end -}}
+`
+
+// generateRemoteModule writes the clients of the handlers of every generated service, grouped by
+// Encore service, to restate.gen/remote.
+func generateRemoteModule(root string, datas []TemplateData) error {
+	dir := filepath.Join(root, "restate.gen", remoteModuleDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	var services []RemoteService
+	for _, data := range datas {
+		if len(data.ServiceGroup)+len(data.WorkflowGroup)+len(data.VirtualObjectGroup) == 0 {
+			continue
+		}
+		helper := lowerFirst(data.ServiceName)
+		if !identifierRe.MatchString(helper) {
+			log.Printf("Skipping the remote clients of %s: its name is not a valid identifier", data.ServiceName)
+			continue
+		}
+		rel, err := filepath.Rel(dir, data.FilePath)
+		if err != nil {
+			continue
+		}
+		services = append(services, RemoteService{Helper: helper, Source: importPath(rel), Data: data})
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Helper < services[j].Helper })
+	return writeTemplate(filepath.Join(dir, "index"+outputExt()), "remote", remoteTemplate, services)
+}
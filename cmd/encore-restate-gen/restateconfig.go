@@ -0,0 +1,117 @@
+package main
+
+import "path/filepath"
+
+// restateConfigName is the module in restate.gen holding the Restate settings of generated code.
+const restateConfigName = "restate.config"
+
+// RestateConfigData is the data of restateConfigTemplate.
+type RestateConfigData struct {
+	// ServerURL and AdminURL are used unless RESTATE_SERVER_URL and RESTATE_ADMIN_URL are set.
+	ServerURL       string
+	AdminURL        string
+	AuthTokenSecret string
+	// AdminTokenSecret is the secret of the admin API token; without it, the ingress token is used.
+	AdminTokenSecret string
+	Identity         IdentityConfig
+	SharedSecret     *SharedSecretConfig
+	// EnvironmentURLs are the Restate URLs of the configured environments that set them, by Encore
+	// environment name.
+	EnvironmentURLs map[string]EnvironmentURLs
+}
+
+// EnvironmentURLs are the Restate URLs the generated code uses in an Encore environment.
+type EnvironmentURLs struct {
+	Ingress string `json:"ingress,omitempty"`
+	Admin   string `json:"admin,omitempty"`
+}
+
+// restateConfigTemplate is written to restate.gen/restate.config.ts. It is the one place generated
+// code reads Restate settings from: environment variables, Encore secrets and configured values.
+const restateConfigTemplate = `// This file is automatically generated by encore-restate-gen.
+// Do not edit this file directly.
+{{- if .EnvironmentURLs }}
+
+import { appMeta } from "encore.dev";
+{{- end }}
+{{- if or .AuthTokenSecret .AdminTokenSecret .Identity.KeysSecret .SharedSecret }}
+
+import { secret } from "encore.dev/config";
+{{- end }}
+{{- if .AuthTokenSecret }}
+
+const authTokenSecret = secret("{{ .AuthTokenSecret }}");
+{{- end }}
+{{- if .AdminTokenSecret }}
+
+const adminTokenSecret = secret("{{ .AdminTokenSecret }}");
+{{- end }}
+{{- if .Identity.KeysSecret }}
+
+const identityKeysSecret = secret("{{ .Identity.KeysSecret }}");
+{{- end }}
+{{- with .SharedSecret }}
+
+const sharedSecret = secret("{{ .Secret }}");
+{{- end }}
+{{- if .EnvironmentURLs }}
+
+// Restate URLs per Encore environment, from environments in encore-restate-gen.json. Other
+// environments, such as local development, use the URLs configured for local.
+const environmentUrls: Record<string, { ingress?: string; admin?: string }> = {{ json .EnvironmentURLs }};
+const environmentUrl = (kind: "ingress" | "admin"): string | undefined =>
+  environmentUrls[appMeta().environment.name]?.[kind];
+{{- end }}
+
+// Restate settings of the generated code, from encore-restate-gen.json and Encore secrets.
+export const restateConfig = {
+  // Restate ingress URL the generated client calls{{ if .EnvironmentURLs }}, the one of the Encore environment if configured{{ end }}. RESTATE_SERVER_URL overrides it.
+  serverUrl: (): string => process.env.RESTATE_SERVER_URL ?? {{ if .EnvironmentURLs }}environmentUrl("ingress") ?? {{ end }}{{ json .ServerURL }},
+  // Restate admin API URL{{ if .EnvironmentURLs }}, the one of the Encore environment if configured{{ end }}. RESTATE_ADMIN_URL overrides it.
+  adminUrl: (): string => process.env.RESTATE_ADMIN_URL ?? {{ if .EnvironmentURLs }}environmentUrl("admin") ?? {{ end }}{{ json .AdminURL }},
+  // Bearer token of ingress calls{{ if .AuthTokenSecret }}, from the Encore secret {{ .AuthTokenSecret }}{{ end }}.
+  authToken: (): string | undefined => {{ if .AuthTokenSecret }}authTokenSecret(){{ else }}undefined{{ end }},
+  // Bearer token of admin API calls{{ if .AdminTokenSecret }}, from the Encore secret {{ .AdminTokenSecret }}{{ else }}, the ingress token{{ end }}.
+  adminToken: (): string | undefined => {{ if .AdminTokenSecret }}adminTokenSecret(){{ else if .AuthTokenSecret }}authTokenSecret(){{ else }}undefined{{ end }},
+  // Public keys of the Restate servers the generated endpoints accept requests from; empty accepts any.
+  identityKeys: (): string[] => [
+    ...{{ json .Identity.Keys }},
+    {{- if .Identity.KeysSecret }}
+    ...identityKeysSecret().split(/[\s,]+/).filter((key) => key !== ""),
+    {{- end }}
+  ],
+  {{- with .SharedSecret }}
+  // Requests to the generated invoke and discover endpoints must carry the Encore secret
+  // {{ .Secret }} in this header. Restate sends it as registered by encore-restate-gen, which reads
+  // it from {{ .Env }}.
+  sharedSecretHeader: {{ json .Header }},
+  sharedSecret: (): string => sharedSecret(),
+  {{- end }}
+};
+`
+
+// generateRestateConfig writes restate.gen/restate.config.ts, or .js in JavaScript output mode.
+func generateRestateConfig(root string) error {
+	data := RestateConfigData{
+		ServerURL:        defaultIngress(),
+		AdminURL:         adminURL(),
+		AuthTokenSecret:  projectConfig.Client.AuthTokenSecret,
+		AdminTokenSecret: projectConfig.Client.AdminTokenSecret,
+		SharedSecret:     projectConfig.SharedSecret,
+	}
+	if projectConfig.Identity != nil {
+		data.Identity = *projectConfig.Identity
+	}
+	data.Identity.Keys = identityKeys()
+	for name, env := range projectConfig.Environments {
+		if env.IngressURL == "" && env.AdminURL == "" {
+			continue
+		}
+		if data.EnvironmentURLs == nil {
+			data.EnvironmentURLs = make(map[string]EnvironmentURLs)
+		}
+		data.EnvironmentURLs[name] = EnvironmentURLs{Ingress: env.IngressURL, Admin: env.AdminURL}
+	}
+	path := filepath.Join(root, "restate.gen", restateConfigName+outputExt())
+	return writeTemplate(path, "restateConfig", restateConfigTemplate, data)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// resultMigrationsDir is the migrations directory of the result cache database, next to the
+	// generated file of the service.
+	resultMigrationsDir = "restate.migrations"
+	// resultMigrationFile creates the table of the cached results. Encore rejects edits to an applied
+	// migration, so it is never rewritten once written: a change of the schema must go in a new
+	// migration file, numbered 2 and up.
+	resultMigrationFile = "1_create_workflow_results.up.sql"
+)
+
+// resultMigration is the migration creating the cached results table.
+const resultMigration = `-- This file is automatically generated by encore-restate-gen.
+-- Do not edit this file directly.
+CREATE TABLE workflow_results (
+  key TEXT PRIMARY KEY,
+  status TEXT NOT NULL,
+  output TEXT,
+  error TEXT,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+`
+
+// ResultCache describes the Encore database caching the results of the workflow of a service,
+// generated for the services listed in resultCache.
+type ResultCache struct {
+	// Database is the name of the Encore database, e.g. user_workflow_results.
+	Database string
+	// Migrations is the migrations directory, relative to the generated file.
+	Migrations string
+	// Workflow is the Restate name of the workflow.
+	Workflow string
+	// Output is the TypeScript type of the output of its run handler.
+	Output string
+}
+
+// ResultCache returns the result cache of the service's workflow, or nil if resultCache does not list
+// the service or its workflow has no run handler.
+func (d TemplateData) ResultCache() *ResultCache {
+	if !stringIn(projectConfig.ResultCache, d.ServiceName) {
+		return nil
+	}
+	for _, g := range d.WorkflowGroup {
+		for _, h := range g.Handlers {
+			if h.ExportName == "run" {
+				return &ResultCache{
+					Database:   snakeCase(d.ServiceName) + "_results",
+					Migrations: resultMigrationsDir,
+					Workflow:   d.ServiceNameTrimmed + "Workflow",
+					Output:     schemaType(h.OutputSchema),
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// snakeCase converts a service name such as UserWorkflow or user-workflow to user_workflow.
+func snakeCase(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			if i > 0 && !strings.HasSuffix(b.String(), "_") {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r - 'A' + 'a')
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+		default:
+			if b.Len() > 0 && !strings.HasSuffix(b.String(), "_") {
+				b.WriteByte('_')
+			}
+		}
+	}
+	return b.String()
+}
+
+// writeResultMigration writes the migration of the result cache of the service in dir, or removes it
+// once the service no longer caches results.
+func writeResultMigration(dir string, data TemplateData) error {
+	migrations := filepath.Join(dir, resultMigrationsDir)
+	path := filepath.Join(migrations, resultMigrationFile)
+	if data.ResultCache() == nil {
+		if existing, err := ioutil.ReadFile(path); err == nil && strings.HasPrefix(string(existing), generatedSQLHeader) {
+			os.Remove(path)
+			// Only removed if the migration was the last file in it.
+			os.Remove(migrations)
+		}
+		return nil
+	}
+	// An existing migration is kept as is, as it may have been applied.
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(migrations, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(resultMigration), 0644)
+}
+
+// generatedSQLHeader starts the generated migrations.
+const generatedSQLHeader = "-- This file is automatically generated by encore-restate-gen."
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// scriptPath resolves a script configured relative to the project root.
+func scriptPath(script string) string {
+	if filepath.IsAbs(script) {
+		return script
+	}
+	return filepath.Join(projectRoot, script)
+}
+
+// runScript runs a project script with the JavaScript runtime, passing args and stdin, and returns
+// its stdout. stderr is logged. The script is killed after the extraction timeout.
+func runScript(script string, args []string, stdin []byte) ([]byte, error) {
+	runtime, err := jsRuntime()
+	if err != nil {
+		return nil, err
+	}
+	cmdArgs := append(append(append([]string{}, runtimeArgs[runtime]...), scriptPath(script)), args...)
+	cmd := exec.Command(runtime, cmdArgs...)
+	cmd.Dir = projectRoot
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &lineLogger{prefix: filepath.Base(script) + ": "}
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to run %s: %v", script, err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			return nil, fmt.Errorf("%s failed: %v", script, err)
+		}
+		return stdout.Bytes(), nil
+	case <-time.After(extractTimeout()):
+		killProcessGroup(cmd)
+		<-done
+		return nil, fmt.Errorf("%s timed out after %v", script, extractTimeout())
+	}
+}
+
+// parseScriptManifest parses the manifest printed by a project script. A missing schemaVersion is
+// taken to be the current one.
+func parseScriptManifest(script string, out []byte) (*Manifest, error) {
+	var manifest Manifest
+	if err := json.Unmarshal(out, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest printed by %s: %v", script, err)
+	}
+	switch manifest.SchemaVersion {
+	case 0:
+		manifest.SchemaVersion = manifestSchemaVersion
+	case manifestSchemaVersion:
+	default:
+		return nil, fmt.Errorf("%s printed manifest schema version %d, but this binary expects version %d",
+			script, manifest.SchemaVersion, manifestSchemaVersion)
+	}
+	return &manifest, nil
+}
+
+// runExtractScript extracts the manifest of dir with the configured extractScript, which is called
+// with the service directory and marker file name and prints the manifest to stdout.
+func runExtractScript(dir string) (*Manifest, error) {
+	script := projectConfig.ExtractScript
+	out, err := runScript(script, []string{dir, serviceMarker(dir)}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return parseScriptManifest(script, out)
+}
+
+// postProcessManifest passes the manifest of dir through the configured postProcessScript, which
+// reads it from stdin and prints the resulting manifest to stdout.
+func postProcessManifest(dir string, manifest *Manifest) (*Manifest, error) {
+	script := projectConfig.PostProcessScript
+	in, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	out, err := runScript(script, []string{dir}, in)
+	if err != nil {
+		return nil, err
+	}
+	return parseScriptManifest(script, out)
+}
+
+// hashScripts writes the contents of the configured extraction scripts to h, so that editing them
+// invalidates cached manifests.
+func hashScripts(h io.Writer) {
+	for _, script := range []string{projectConfig.ExtractScript, projectConfig.PostProcessScript} {
+		if script == "" {
+			continue
+		}
+		h.Write([]byte("\x00" + script + "\x00"))
+		if data, err := ioutil.ReadFile(scriptPath(script)); err == nil {
+			h.Write(data)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// sdkRequirement is the oldest version of a Restate package a feature of the generated code needs.
+type sdkRequirement struct {
+	Package    string
+	Feature    string
+	MinVersion string
+}
+
+// sdkRequirements is the compatibility matrix of the generated code and the Restate SDK.
+var sdkRequirements = []sdkRequirement{
+	{"@restatedev/restate-sdk", "the generated service definition", "1.0.0"},
+	{"@restatedev/restate-sdk", "the generated fetch endpoint (@restatedev/restate-sdk/fetch)", "1.2.0"},
+	{"@restatedev/restate-sdk-clients", "the generated ingress client", "1.0.0"},
+	{"@restatedev/restate-sdk-core", "the typing of the generated client", "1.0.0"},
+}
+
+// peerRequirements returns the packages besides the Restate SDK that the generated TypeScript needs
+// to compile. Missing ones are installed as dev dependencies.
+func peerRequirements() []sdkRequirement {
+	if projectConfig.Output == outputJavaScript {
+		return nil
+	}
+	return []sdkRequirement{
+		{"typescript", "the generated code, which uses type-only imports,", "3.8.0"},
+		{"@types/node", "the generated index, which imports node:http and node:fs,", "16.0.0"},
+	}
+}
+
+// sdkMajorVersion is the major version of the Restate SDK the generated code is written against.
+const sdkMajorVersion = 1
+
+// offline is set by -offline or the offline option: the package manager is never run, and no
+// webhook called; missing and incompatible dependencies are reported instead.
+var offline bool
+
+// upgradeSdk is set by -upgrade-sdk: incompatible Restate packages are upgraded with the project's
+// package manager instead of only being reported.
+var upgradeSdk bool
+
+// sdkMismatch is a Restate package whose version does not fit the generated code.
+type sdkMismatch struct {
+	Package  string
+	Version  string
+	Problems []string
+	Upgrade  string // the package specifier to install instead
+	dev      bool
+	min      [3]int // the version Upgrade asks for
+}
+
+// parseVersion returns the major, minor and patch numbers of a version or of the lower bound of a
+// simple semver range, such as "^1.4.0" or ">=1.2".
+func parseVersion(version string) (v [3]int, ok bool) {
+	version = strings.TrimLeft(strings.TrimSpace(version), "^~>=v ")
+	for i, part := range strings.SplitN(version, ".", 3) {
+		n := 0
+		digits := 0
+		for digits < len(part) && part[digits] >= '0' && part[digits] <= '9' {
+			n = n*10 + int(part[digits]-'0')
+			digits++
+		}
+		if digits == 0 {
+			return v, i > 0
+		}
+		v[i] = n
+	}
+	return v, true
+}
+
+// versionLess reports whether version a is older than b.
+func versionLess(a, b [3]int) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
+
+// sdkMismatches compares the installed, or else declared, versions of the Restate and peer packages
+// of dir with sdkRequirements and peerRequirements.
+func sdkMismatches(dir string) ([]sdkMismatch, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "package.json"))
+	if err != nil {
+		return nil, err
+	}
+	var pkg struct {
+		Dependencies    map[string]string `json:"dependencies"`
+		DevDependencies map[string]string `json:"devDependencies"`
+	}
+	if err := json.Unmarshal(data, &pkg); err != nil {
+		return nil, err
+	}
+	var mismatches []sdkMismatch
+	index := make(map[string]int)
+	for _, req := range append(sdkRequirements, peerRequirements()...) {
+		version := installedPackageVersion(dir, req.Package)
+		_, dev := pkg.DevDependencies[req.Package]
+		if version == "" {
+			version = pkg.Dependencies[req.Package]
+		}
+		if version == "" {
+			version = pkg.DevDependencies[req.Package]
+		}
+		current, ok := parseVersion(version)
+		if !ok {
+			// Not installed, or a tag or URL that cannot be compared.
+			continue
+		}
+		min, _ := parseVersion(req.MinVersion)
+		var problem string
+		switch {
+		case current[0] > sdkMajorVersion && strings.HasPrefix(req.Package, "@restatedev/"):
+			problem = fmt.Sprintf("%s targets version %d.x", req.Feature, sdkMajorVersion)
+		case versionLess(current, min):
+			problem = fmt.Sprintf("%s needs %s or later", req.Feature, req.MinVersion)
+		default:
+			continue
+		}
+		i, ok := index[req.Package]
+		if !ok {
+			i = len(mismatches)
+			index[req.Package] = i
+			mismatches = append(mismatches, sdkMismatch{Package: req.Package, Version: version, dev: dev})
+		}
+		m := &mismatches[i]
+		m.Problems = append(m.Problems, problem)
+		if m.Upgrade == "" || versionLess(m.min, min) {
+			m.Upgrade, m.min = m.Package+"@^"+req.MinVersion, min
+		}
+	}
+	return mismatches, nil
+}
+
+// checkSdkCompatibility logs the Restate packages of dir whose versions do not fit the generated
+// code, what needs a different version and how to upgrade, and with -upgrade-sdk upgrades them.
+func checkSdkCompatibility(dir string) error {
+	mismatches, err := sdkMismatches(dir)
+	if err != nil || len(mismatches) == 0 {
+		return err
+	}
+	var upgrades, devUpgrades []string
+	for _, m := range mismatches {
+		log.Printf("Warning: %s %s does not fit the generated code: %s", m.Package, m.Version, strings.Join(m.Problems, "; "))
+		if m.dev {
+			devUpgrades = append(devUpgrades, m.Upgrade)
+		} else {
+			upgrades = append(upgrades, m.Upgrade)
+		}
+	}
+	for _, group := range []struct {
+		packages []string
+		dev      bool
+	}{{upgrades, false}, {devUpgrades, true}} {
+		if len(group.packages) == 0 {
+			continue
+		}
+		cmd, err := addCommand(group.packages, group.dev)
+		if err != nil {
+			return err
+		}
+		if offline {
+			log.Printf("Upgrade with: %s", strings.Join(cmd.Args, " "))
+			continue
+		}
+		if !upgradeSdk {
+			log.Printf("Upgrade with: %s, or run encore-restate-gen with -upgrade-sdk", strings.Join(cmd.Args, " "))
+			continue
+		}
+		log.Printf("Upgrading %v", group.packages)
+		if err := runAdd(dir, group.packages, group.dev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dependencyFinding is a Restate package that is missing or does not fit the generated code,
+// reported instead of being installed in offline mode and by -check.
+type dependencyFinding struct {
+	Package string `json:"package"`
+	// Version is the installed or declared version; "" if the package is missing.
+	Version string `json:"version,omitempty"`
+	Problem string `json:"problem"`
+	// Install is the command adding the package in a fitting version.
+	Install string `json:"install"`
+}
+
+// dependencyFindings returns the Restate packages of dir that are missing or do not fit the
+// generated code.
+func dependencyFindings(dir string) ([]dependencyFinding, error) {
+	findings := []dependencyFinding{}
+	missing, dev, err := missingRestateModules(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, dep := range missing {
+		cmd, err := addCommand([]string{dep}, dev)
+		if err != nil {
+			return nil, err
+		}
+		findings = append(findings, dependencyFinding{Package: dep, Problem: "neither declared in package.json nor installed", Install: strings.Join(cmd.Args, " ")})
+	}
+	peers, err := missingPeerPackages(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, peer := range peers {
+		cmd, err := addCommand([]string{peer.Package}, true)
+		if err != nil {
+			return nil, err
+		}
+		findings = append(findings, dependencyFinding{Package: peer.Package, Problem: "not installed, but " + peer.Feature + " needs it to compile", Install: strings.Join(cmd.Args, " ")})
+	}
+	mismatches, err := sdkMismatches(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range mismatches {
+		cmd, err := addCommand([]string{m.Upgrade}, m.dev)
+		if err != nil {
+			return nil, err
+		}
+		findings = append(findings, dependencyFinding{Package: m.Package, Version: m.Version, Problem: strings.Join(m.Problems, "; "), Install: strings.Join(cmd.Args, " ")})
+	}
+	return findings, nil
+}
+
+// reportDependencies logs the missing Restate packages of dir, in offline mode, where they are not
+// installed. Incompatible ones are reported by checkSdkCompatibility.
+func reportDependencies(dir string) error {
+	missing, dev, err := missingRestateModules(dir)
+	if err != nil || len(missing) == 0 {
+		return err
+	}
+	cmd, err := addCommand(missing, dev)
+	if err != nil {
+		return err
+	}
+	log.Printf("Offline: required Restate modules are not installed: %v; install them with: %s", missing, strings.Join(cmd.Args, " "))
+	return nil
+}
+
+// missingPeerPackages returns the peerRequirements that dir's package.json does not declare and
+// that are not installed either, one per package.
+func missingPeerPackages(dir string) ([]sdkRequirement, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "package.json"))
+	if err != nil {
+		return nil, err
+	}
+	var pkg struct {
+		Dependencies    map[string]string `json:"dependencies"`
+		DevDependencies map[string]string `json:"devDependencies"`
+	}
+	if err := json.Unmarshal(data, &pkg); err != nil {
+		return nil, err
+	}
+	var missing []sdkRequirement
+	for _, req := range peerRequirements() {
+		_, ok := pkg.Dependencies[req.Package]
+		_, devOK := pkg.DevDependencies[req.Package]
+		if !ok && !devOK && installedPackageVersion(dir, req.Package) == "" {
+			missing = append(missing, req)
+		}
+	}
+	return missing, nil
+}
+
+// ensurePeerPackages installs the missing peerRequirements of dir as dev dependencies, or, in
+// offline mode or with install.peers set to false, logs how to install them.
+func ensurePeerPackages(dir string) error {
+	missing, err := missingPeerPackages(dir)
+	if err != nil || len(missing) == 0 {
+		return err
+	}
+	var packages []string
+	for _, req := range missing {
+		log.Printf("%s is not installed, but %s needs it to compile", req.Package, req.Feature)
+		packages = append(packages, req.Package)
+	}
+	if offline || projectConfig.Install.Peers != nil && !*projectConfig.Install.Peers {
+		cmd, err := addCommand(packages, true)
+		if err != nil {
+			return err
+		}
+		log.Printf("Install them with: %s", strings.Join(cmd.Args, " "))
+		return nil
+	}
+	if err := runAdd(dir, packages, true); err != nil {
+		return err
+	}
+	recordModifications(dir, nil, packages)
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Defaults of the sharedSecret config section.
+const (
+	defaultSharedSecretHeader = "x-restate-shared-secret"
+	defaultSharedSecretEnv    = "RESTATE_SHARED_SECRET"
+)
+
+// registrationBody returns the admin API request body registering the deployment at uri. With a
+// shared secret, Restate is told to send secret in its header with every request.
+func registrationBody(uri, secret string, force bool) map[string]interface{} {
+	body := map[string]interface{}{"uri": uri, "use_http_11": true}
+	if force {
+		body["force"] = true
+	}
+	if ss := projectConfig.SharedSecret; ss != nil {
+		body["additional_headers"] = map[string]string{ss.Header: secret}
+	}
+	return body
+}
+
+// sharedSecretValue returns the shared secret Restate must send, read from sharedSecret.env, or ""
+// if no shared secret is configured.
+func sharedSecretValue() (string, error) {
+	ss := projectConfig.SharedSecret
+	if ss == nil {
+		return "", nil
+	}
+	value := os.Getenv(ss.Env)
+	if value == "" {
+		return "", fmt.Errorf("sharedSecret is configured but %s is not set; the generated endpoints would reject Restate's requests", ss.Env)
+	}
+	return value, nil
+}
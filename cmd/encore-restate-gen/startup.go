@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// startupTimer measures the phases of startup, to make regressions of the startup time of big
+// projects visible. Phases may overlap, so their durations need not add up to the total.
+type startupTimer struct {
+	mu       sync.Mutex
+	start    time.Time
+	names    []string
+	took     map[string]time.Duration
+	services int
+	reported bool
+}
+
+// startup times this run's startup, until it is reported.
+var startup = &startupTimer{start: time.Now(), took: make(map[string]time.Duration)}
+
+// add adds took to the duration of the phase name.
+func (t *startupTimer) add(name string, took time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.reported {
+		return
+	}
+	if _, ok := t.took[name]; !ok {
+		t.names = append(t.names, name)
+	}
+	t.took[name] += took
+}
+
+// phase starts timing the phase name and returns the function ending it.
+func (t *startupTimer) phase(name string) func() {
+	start := time.Now()
+	return func() { t.add(name, time.Since(start)) }
+}
+
+// scanned records the number of service directories found.
+func (t *startupTimer) scanned(services int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.reported {
+		t.services = services
+	}
+}
+
+// summary describes the startup time and its phases in one line.
+func (t *startupTimer) summary() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	phases := make([]string, 0, len(t.names))
+	for _, name := range t.names {
+		phases = append(phases, fmt.Sprintf("%s %v", name, t.took[name].Round(time.Millisecond)))
+	}
+	return fmt.Sprintf("Started in %v with %d services: %s",
+		time.Since(t.start).Round(time.Millisecond), t.services, strings.Join(phases, ", "))
+}
+
+// report logs the summary once; later phases, e.g. the scan after a resume, are not recorded.
+func (t *startupTimer) report() {
+	log.Print(t.summary())
+	t.mu.Lock()
+	t.reported = true
+	t.mu.Unlock()
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sync"
+)
+
+// watcherState is the generated data of the project's services, persisted after every central
+// index generation, so that a restarted watcher can restore the services whose inputs did not
+// change instead of extracting and generating them again.
+type watcherState struct {
+	// Tool identifies the binary, configuration and Restate SDK the files were generated with.
+	Tool     string                  `json:"tool"`
+	Services map[string]serviceState `json:"services"`
+}
+
+// serviceState is the generated data of a service directory, the hash of the inputs, see
+// sourceState, it was generated from, and the hash of the generated file.
+type serviceState struct {
+	Hash   string       `json:"hash"`
+	Output string       `json:"output"`
+	Data   TemplateData `json:"data"`
+}
+
+var (
+	// inputHashes maps real service directories to the hash of the inputs they were last extracted
+	// from without errors.
+	inputHashes      = make(map[string]string)
+	inputHashesMutex sync.Mutex
+)
+
+// recordInputs records the hash of the inputs of dir, a real directory, after a successful extraction.
+func recordInputs(dir, hash string) {
+	inputHashesMutex.Lock()
+	inputHashes[dir] = hash
+	inputHashesMutex.Unlock()
+}
+
+// absRealDir returns dir as the absolute, symlink-free path extractManifest records it under.
+func absRealDir(dir string) string {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	return realDir(dir)
+}
+
+// fileHash returns the hash of the contents of the file at path.
+func fileHash(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// statePath returns the file the watcher state of root is persisted to, in its project cache.
+func statePath(root string) string {
+	return filepath.Join(projectCache(root), "state.json")
+}
+
+// toolIdentity hashes what generated files depend on besides a service's inputs. It does not
+// depend on where the tool or the project are, so a cache restored in CI stays valid.
+func toolIdentity() string {
+	h := sha256.New()
+	h.Write([]byte(toolVersion() + "\x00" + assetsChecksum() + "\x00"))
+	config, _ := json.Marshal(projectConfig)
+	h.Write(config)
+	h.Write([]byte("\x00" + installedPackageVersion(projectRoot, "@restatedev/restate-sdk")))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+var (
+	toolVersionOnce  sync.Once
+	toolVersionValue string
+)
+
+// toolVersion identifies the build of the tool: its module version, else the commit it was built
+// from, else, for builds with local changes, the hash of the executable.
+func toolVersion() string {
+	toolVersionOnce.Do(func() {
+		if info, ok := debug.ReadBuildInfo(); ok {
+			if v := info.Main.Version; v != "" && v != "(devel)" {
+				toolVersionValue = v
+				return
+			}
+			settings := make(map[string]string)
+			for _, s := range info.Settings {
+				settings[s.Key] = s.Value
+			}
+			if rev := settings["vcs.revision"]; rev != "" && settings["vcs.modified"] != "true" {
+				toolVersionValue = rev
+				return
+			}
+		}
+		if exe, err := os.Executable(); err == nil {
+			if hash, err := fileHash(exe); err == nil {
+				toolVersionValue = hash
+			}
+		}
+	})
+	return toolVersionValue
+}
+
+// saveState persists the generated data of root's services. Failing to persist it is not fatal.
+func saveState(root string) {
+	state := watcherState{Tool: toolIdentity(), Services: make(map[string]serviceState)}
+	generatedDataMapMutex.Lock()
+	inputHashesMutex.Lock()
+	for dir, data := range generatedDataMap {
+		hash, ok := inputHashes[absRealDir(dir)]
+		if !ok {
+			continue
+		}
+		output, err := fileHash(data.FilePath)
+		if err != nil {
+			continue
+		}
+		// Directories and generated files are stored relative to root, so the state stays valid
+		// in another checkout of the project.
+		rel, err := filepath.Rel(root, dir)
+		if err != nil {
+			continue
+		}
+		if file, err := filepath.Rel(root, data.FilePath); err == nil {
+			data.FilePath = filepath.ToSlash(file)
+		}
+		state.Services[filepath.ToSlash(rel)] = serviceState{Hash: hash, Output: output, Data: data}
+	}
+	inputHashesMutex.Unlock()
+	generatedDataMapMutex.Unlock()
+	path := statePath(root)
+	data, err := json.Marshal(state)
+	if err != nil {
+		return
+	}
+	if err := mkdirProjectCache(root, filepath.Dir(path)); err != nil {
+		return
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err == nil {
+		os.Rename(tmp, path)
+	}
+}
+
+// restoreState restores the generated data of those of dirs whose inputs and generated file are
+// unchanged since the previous run, and returns them. A generated file that was edited or removed
+// since is generated again.
+func restoreState(root string, dirs []string) map[string]bool {
+	restored := make(map[string]bool)
+	data, err := ioutil.ReadFile(statePath(root))
+	if err != nil {
+		return restored
+	}
+	var state watcherState
+	if err := json.Unmarshal(data, &state); err != nil || state.Tool != toolIdentity() {
+		return restored
+	}
+	goBackend := useGoExtractor()
+	// The sources are hashed up to scanConcurrency directories at a time.
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, scanConcurrency)
+	)
+	for _, dir := range dirs {
+		rel, err := filepath.Rel(root, dir)
+		if err != nil {
+			continue
+		}
+		service, ok := state.Services[filepath.ToSlash(rel)]
+		if !ok {
+			continue
+		}
+		if !filepath.IsAbs(service.Data.FilePath) {
+			service.Data.FilePath = filepath.Join(root, filepath.FromSlash(service.Data.FilePath))
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(dir string, service serviceState) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if output, err := fileHash(service.Data.FilePath); err != nil || output != service.Output {
+				return
+			}
+			real := absRealDir(dir)
+			current, err := sourceHash(real, goBackend)
+			if err != nil || current.Hash != service.Hash {
+				return
+			}
+			recordInputs(real, service.Hash)
+			generatedDataMapMutex.Lock()
+			generatedDataMap[dir] = service.Data
+			generatedDataMapMutex.Unlock()
+			mu.Lock()
+			restored[dir] = true
+			mu.Unlock()
+		}(dir, service)
+	}
+	wg.Wait()
+	return restored
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// testingModuleDir is the directory of restate.gen holding the test doubles of the generated clients,
+// imported as ~restate/testing.
+const testingModuleDir = "testing"
+
+// testingTemplate is written to restate.gen/testing/index.ts. Its fake replaces the ingress
+// connection of the generated clients, so service tests can stub and assert on Restate calls
+// without a Restate server.
+const testingTemplate = `// This file is automatically generated by encore-restate-gen.
+// Do not edit this file directly.
+
+import type * as clients from "@restatedev/restate-sdk-clients";
+import { setIngress } from "../index";
+
+// A generated service, object or workflow definition, e.g. services.Greeter.
+type Definition = { name: string };
+
+// A call made through the generated clients while a fake is installed.
+export type RestateCall = {
+  service: string;
+  handler: string;
+  // The key of object and workflow calls.
+  key?: string;
+  input: unknown;
+  // Whether the call was a one-way send or a workflow submission.
+  send: boolean;
+};
+
+// Answers a faked call; it may return a promise or throw.
+export type FakeHandler = (input: any, call: RestateCall) => unknown;
+
+// A workflow run started with workflowSubmit.
+type Run = { done: boolean; output?: unknown; error?: unknown; result: Promise<unknown> };
+
+const notFound = (message: string) => Object.assign(new Error(message), { status: 404 });
+
+// FakeRestate answers the calls of the generated clients in memory and records them.
+export class FakeRestate {
+  readonly calls: RestateCall[] = [];
+  private handlers = new Map<string, FakeHandler>();
+  private runs = new Map<string, Run>();
+  private invocations = 0;
+
+  // Answers the calls of handler of def with impl, e.g.
+  // fake.on(services.Greeter, "greet", (name: string) => "Hello " + name).
+  on(def: Definition, handler: string, impl: FakeHandler): this {
+    this.handlers.set(def.name + "/" + handler, impl);
+    return this;
+  }
+
+  // Returns the calls made to def, or only those to its handler.
+  callsTo(def: Definition, handler?: string): RestateCall[] {
+    return this.calls.filter(c => c.service === def.name && (handler === undefined || c.handler === handler));
+  }
+
+  // Forgets the recorded calls, the handlers and the workflow runs.
+  reset() {
+    this.calls.length = 0;
+    this.handlers.clear();
+    this.runs.clear();
+  }
+
+  // Restores the connection of the generated clients to Restate.
+  restore() {
+    setIngress(undefined);
+  }
+
+  private async invoke(call: RestateCall): Promise<unknown> {
+    this.calls.push(call);
+    const impl = this.handlers.get(call.service + "/" + call.handler);
+    if (!impl) {
+      throw notFound("no fake handler for " + call.service + "/" + call.handler + "; add one with on()");
+    }
+    return impl(call.input, call);
+  }
+
+  private sent() {
+    return { invocationId: "inv_fake" + ++this.invocations, status: "Accepted" };
+  }
+
+  // Returns a client whose methods invoke the handlers of service, like the ingress clients.
+  private client(service: string, key: string | undefined, send: boolean): any {
+    return new Proxy(
+      {},
+      {
+        get: (_, handler) => {
+          if (typeof handler !== "string" || handler === "then") {
+            return undefined;
+          }
+          return async (input?: unknown) => {
+            const result = this.invoke({ service, handler, key, input, send });
+            if (!send) {
+              return result;
+            }
+            // Like Restate, a send is accepted before the handler runs.
+            result.catch(() => {});
+            return this.sent();
+          };
+        },
+      },
+    );
+  }
+
+  private workflow(service: string, key: string): any {
+    const id = service + "/" + key;
+    const run = () => {
+      const r = this.runs.get(id);
+      if (!r) {
+        throw notFound("no " + service + " run with key " + key);
+      }
+      return r;
+    };
+    const handlers = this.client(service, key, false);
+    return new Proxy(
+      {},
+      {
+        get: (_, prop) => {
+          switch (prop) {
+            case "workflowSubmit":
+              return async (input?: unknown) => {
+                if (this.runs.has(id)) {
+                  return { ...this.sent(), status: "PreviouslyAccepted" };
+                }
+                const r: Run = { done: false, result: this.invoke({ service, handler: "run", key, input, send: true }) };
+                r.result.then(
+                  output => Object.assign(r, { done: true, output }),
+                  error => Object.assign(r, { done: true, error }),
+                );
+                this.runs.set(id, r);
+                return this.sent();
+              };
+            case "workflowAttach":
+              return async () => run().result;
+            case "workflowOutput":
+              return async () => {
+                const r = run();
+                // Lets a run that already settled be seen as done.
+                await Promise.resolve();
+                if (r.done && r.error !== undefined) {
+                  throw r.error;
+                }
+                return r.done ? { ready: true, result: r.output } : { ready: false };
+              };
+            default:
+              return handlers[prop];
+          }
+        },
+      },
+    );
+  }
+
+  // Returns the ingress connection the generated clients use while the fake is installed.
+  ingress(): clients.Ingress {
+    return {
+      serviceClient: (def: Definition) => this.client(def.name, undefined, false),
+      serviceSendClient: (def: Definition) => this.client(def.name, undefined, true),
+      objectClient: (def: Definition, key: string) => this.client(def.name, key, false),
+      objectSendClient: (def: Definition, key: string) => this.client(def.name, key, true),
+      workflowClient: (def: Definition, key: string) => this.workflow(def.name, key),
+    } as unknown as clients.Ingress;
+  }
+}
+
+// Installs a new fake in place of the Restate connection of the generated clients, until its
+// restore() is called, e.g. in beforeEach and afterEach of a test file.
+export const fakeRestate = (): FakeRestate => {
+  const fake = new FakeRestate();
+  setIngress(fake.ingress());
+  return fake;
+};
+`
+
+// generateTestingModule writes the test doubles of the generated clients to restate.gen/testing.
+func generateTestingModule(root string) error {
+	dir := filepath.Join(root, "restate.gen", testingModuleDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return writeTemplate(filepath.Join(dir, "index"+outputExt()), "testing", testingTemplate, nil)
+}
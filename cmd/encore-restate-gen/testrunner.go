@@ -0,0 +1,133 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// vitestConfigs are the Vitest config files looked for in the project root, in Vitest's order.
+var vitestConfigs = []string{"vitest.config.ts", "vitest.config.mts", "vitest.config.js", "vitest.config.mjs"}
+
+// vitestAlias is the resolve.alias entry mapping ~restate and ~restate/* to the generated code.
+const vitestAlias = `"~restate": fileURLToPath(new URL("./restate.gen", import.meta.url))`
+
+// updateTestRunnerConfig adds the ~restate aliases to the Jest and Vitest configuration of the
+// project, as test runners do not read tsconfig paths. Configurations in code that cannot be
+// patched safely get a log message with the entry to add instead.
+func updateTestRunnerConfig(root string) error {
+	if projectConfig.Aliases != aliasesPaths {
+		// Jest and Vitest resolve subpath imports themselves.
+		return nil
+	}
+	f, err := planJestConfig(root)
+	if err != nil {
+		return err
+	}
+	if f != nil {
+		log.Printf("Added the ~restate module name mappings to %s", f.path)
+		if err := ioutil.WriteFile(f.path, []byte(f.editor.src), 0644); err != nil {
+			return err
+		}
+		recordModifications(root, f.added, nil)
+	}
+	return updateVitestConfig(root)
+}
+
+// jestMappings are the moduleNameMapper entries mapping the ~restate aliases to the generated code.
+func jestMappings() [][2]string {
+	return [][2]string{
+		{"^~restate$", "<rootDir>/restate.gen/index" + outputExt()},
+		{"^~restate/(.*)$", "<rootDir>/restate.gen/$1"},
+	}
+}
+
+// planJestConfig patches the Jest configuration of root, in jest.config.json or the "jest" key of
+// package.json, in memory, and returns it, or nil if nothing is missing or there is none.
+func planJestConfig(root string) (*tsconfigFile, error) {
+	var keys []string
+	path := filepath.Join(root, "jest.config.json")
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		for _, name := range []string{"jest.config.js", "jest.config.ts", "jest.config.mjs", "jest.config.cjs"} {
+			if _, err := os.Stat(filepath.Join(root, name)); err == nil {
+				log.Printf("Add %q: %q and %q: %q to the moduleNameMapper of %s so tests can import generated code",
+					jestMappings()[0][0], jestMappings()[0][1], jestMappings()[1][0], jestMappings()[1][1], name)
+				return nil, nil
+			}
+		}
+		keys = []string{"jest"}
+		path = filepath.Join(root, "package.json")
+		data, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	f := &tsconfigFile{path: path, editor: newJSONCEditor(string(data))}
+	if f.root, err = parseJSONC(f.editor.src); err != nil {
+		return nil, err
+	}
+	config := f.lookup(keys...)
+	if config == nil || config.kind != '{' || config.member("rootDir") != nil {
+		// Without Jest, nothing is needed; with a custom rootDir, <rootDir> is not the project root.
+		return nil, nil
+	}
+	mapperKeys := append(append([]string{}, keys...), "moduleNameMapper")
+	if config.member("moduleNameMapper") == nil {
+		if err := f.insert([]string{`"moduleNameMapper": {}`}, keys...); err != nil {
+			return nil, err
+		}
+	}
+	mapper := f.lookup(mapperKeys...)
+	if mapper.kind != '{' {
+		return nil, nil
+	}
+	var missing []string
+	for _, mapping := range jestMappings() {
+		if mapper.member(mapping[0]) == nil {
+			missing = append(missing, quoteJSON(mapping[0])+": "+quoteJSON(mapping[1]))
+		}
+	}
+	if len(missing) == 0 {
+		return nil, nil
+	}
+	return f, f.insert(missing, mapperKeys...)
+}
+
+// updateVitestConfig adds the ~restate alias to the project's Vitest config, if it has one that
+// does not mention ~restate yet. It is inserted into the object passed to defineConfig, unless
+// that already configures resolve options, which cannot be merged safely.
+func updateVitestConfig(root string) error {
+	for _, name := range vitestConfigs {
+		path := filepath.Join(root, name)
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		src := string(data)
+		imported := strings.Contains(src, "fileURLToPath")
+		if strings.Contains(src, "~restate") {
+			return nil
+		}
+		start := strings.Index(src, "defineConfig({")
+		if start < 0 || strings.Contains(src, "alias") || strings.Contains(src, "resolve") {
+			log.Printf("Add %s to the resolve.alias of %s so tests can import generated code, importing fileURLToPath from \"node:url\"", vitestAlias, name)
+			return nil
+		}
+		editor := newJSONCEditor(src)
+		pos := start + len("defineConfig({")
+		indent := editor.indentOf(start)
+		src = src[:pos] + editor.newline + indent + editor.unit + "resolve: { alias: { " + vitestAlias + " } }," + src[pos:]
+		if !imported {
+			src = `import { fileURLToPath } from "node:url";` + editor.newline + src
+		}
+		log.Printf("Added the ~restate alias to %s", path)
+		return ioutil.WriteFile(path, []byte(src), 0644)
+	}
+	return nil
+}
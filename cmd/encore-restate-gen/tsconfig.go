@@ -0,0 +1,902 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// jsoncValue is a value parsed from JSON with comments and trailing commas, as tsconfig.json
+// allows, together with its position in the source, so that it can be edited in place.
+type jsoncValue struct {
+	// kind is '{' for objects, '[' for arrays, '"' for strings and 0 for other literals.
+	kind       byte
+	start, end int // the span of the value in the source, end exclusive
+	members    []jsoncMember
+	elements   []*jsoncValue
+	str        string // the decoded value of a string
+}
+
+// jsoncMember is a member of an object.
+type jsoncMember struct {
+	key      string
+	keyStart int
+	value    *jsoncValue
+}
+
+// member returns the value of the member key of an object, or nil.
+func (v *jsoncValue) member(key string) *jsoncValue {
+	if v == nil || v.kind != '{' {
+		return nil
+	}
+	for _, m := range v.members {
+		if m.key == key {
+			return m.value
+		}
+	}
+	return nil
+}
+
+// jsoncParser parses JSONC source.
+type jsoncParser struct {
+	src string
+	pos int
+}
+
+// parseJSONC parses src, which must hold a single value.
+func parseJSONC(src string) (*jsoncValue, error) {
+	p := &jsoncParser{src: src}
+	if strings.HasPrefix(src, "\ufeff") {
+		p.pos = len("\ufeff")
+	}
+	v, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.skip(); err != nil {
+		return nil, err
+	}
+	if p.pos < len(src) {
+		return nil, p.errorf("unexpected %q after the value", src[p.pos])
+	}
+	return v, nil
+}
+
+func (p *jsoncParser) errorf(format string, args ...interface{}) error {
+	line := strings.Count(p.src[:p.pos], "\n") + 1
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+// skip skips whitespace and comments.
+func (p *jsoncParser) skip() error {
+	for p.pos < len(p.src) {
+		switch c := p.src[p.pos]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			p.pos++
+		case strings.HasPrefix(p.src[p.pos:], "//"):
+			if i := strings.IndexByte(p.src[p.pos:], '\n'); i >= 0 {
+				p.pos += i
+			} else {
+				p.pos = len(p.src)
+			}
+		case strings.HasPrefix(p.src[p.pos:], "/*"):
+			i := strings.Index(p.src[p.pos+2:], "*/")
+			if i < 0 {
+				return p.errorf("unterminated comment")
+			}
+			p.pos += i + 4
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (p *jsoncParser) value() (*jsoncValue, error) {
+	if err := p.skip(); err != nil {
+		return nil, err
+	}
+	if p.pos >= len(p.src) {
+		return nil, p.errorf("unexpected end of file")
+	}
+	switch p.src[p.pos] {
+	case '{':
+		return p.object()
+	case '[':
+		return p.array()
+	case '"':
+		return p.string()
+	}
+	start := p.pos
+	for p.pos < len(p.src) && strings.IndexByte(",:]}/ \t\r\n", p.src[p.pos]) < 0 {
+		p.pos++
+	}
+	if p.pos == start {
+		return nil, p.errorf("unexpected %q", p.src[p.pos])
+	}
+	return &jsoncValue{start: start, end: p.pos}, nil
+}
+
+func (p *jsoncParser) string() (*jsoncValue, error) {
+	start := p.pos
+	p.pos++
+	for p.pos < len(p.src) && p.src[p.pos] != '"' {
+		if p.src[p.pos] == '\\' {
+			p.pos++
+		}
+		p.pos++
+	}
+	if p.pos >= len(p.src) {
+		return nil, p.errorf("unterminated string")
+	}
+	p.pos++
+	v := &jsoncValue{kind: '"', start: start, end: p.pos}
+	if err := json.Unmarshal([]byte(p.src[start:p.pos]), &v.str); err != nil {
+		return nil, p.errorf("invalid string %s", p.src[start:p.pos])
+	}
+	return v, nil
+}
+
+func (p *jsoncParser) object() (*jsoncValue, error) {
+	v := &jsoncValue{kind: '{', start: p.pos}
+	p.pos++
+	for {
+		if err := p.skip(); err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.src) {
+			return nil, p.errorf("unterminated object")
+		}
+		if p.src[p.pos] == '}' {
+			p.pos++
+			v.end = p.pos
+			return v, nil
+		}
+		if p.src[p.pos] != '"' {
+			return nil, p.errorf("expected a member name")
+		}
+		key, err := p.string()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.skip(); err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.src) || p.src[p.pos] != ':' {
+			return nil, p.errorf("expected ':' after %s", p.src[key.start:key.end])
+		}
+		p.pos++
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		v.members = append(v.members, jsoncMember{key: key.str, keyStart: key.start, value: value})
+		if err := p.separator('}'); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *jsoncParser) array() (*jsoncValue, error) {
+	v := &jsoncValue{kind: '[', start: p.pos}
+	p.pos++
+	for {
+		if err := p.skip(); err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.src) {
+			return nil, p.errorf("unterminated array")
+		}
+		if p.src[p.pos] == ']' {
+			p.pos++
+			v.end = p.pos
+			return v, nil
+		}
+		element, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		v.elements = append(v.elements, element)
+		if err := p.separator(']'); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// separator consumes the comma after a member or element, if the container does not end there.
+func (p *jsoncParser) separator(closing byte) error {
+	if err := p.skip(); err != nil {
+		return err
+	}
+	if p.pos < len(p.src) && p.src[p.pos] == ',' {
+		p.pos++
+		return nil
+	}
+	if p.pos < len(p.src) && p.src[p.pos] == closing {
+		return nil
+	}
+	return p.errorf("expected ',' or '%c'", closing)
+}
+
+// jsoncEditor inserts into JSONC source while keeping its comments and formatting.
+type jsoncEditor struct {
+	src     string
+	newline string // the file's line ending
+	unit    string // one level of the file's indentation
+}
+
+func newJSONCEditor(src string) *jsoncEditor {
+	e := &jsoncEditor{src: src, newline: "\n", unit: "  "}
+	if strings.Contains(src, "\r\n") {
+		e.newline = "\r\n"
+	}
+	for _, line := range strings.Split(src, "\n") {
+		if trimmed := strings.TrimLeft(line, " \t"); trimmed != line && trimmed != "" {
+			e.unit = line[:len(line)-len(trimmed)]
+			break
+		}
+	}
+	return e
+}
+
+// indentOf returns the indentation of the line containing pos.
+func (e *jsoncEditor) indentOf(pos int) string {
+	lineStart := strings.LastIndexByte(e.src[:pos], '\n') + 1
+	line := e.src[lineStart:]
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+// insert adds items, member or element source text, at the end of container, an object or
+// array: after its last item, or on new lines if it is empty. A trailing comma and comments
+// after the last item are kept where they are.
+func (e *jsoncEditor) insert(container *jsoncValue, items []string) {
+	if len(items) == 0 {
+		return
+	}
+	var last *jsoncValue
+	if n := len(container.members); n > 0 {
+		last = container.members[n-1].value
+	} else if n := len(container.elements); n > 0 {
+		last = container.elements[n-1]
+	}
+	if last == nil && !strings.Contains(e.src, "\n") {
+		// Minified files stay on one line.
+		e.src = e.src[:container.end-1] + strings.Join(items, ",") + e.src[container.end-1:]
+		return
+	}
+	if last == nil {
+		indent := e.indentOf(container.start)
+		text := e.newline + indent + e.unit + strings.Join(items, ","+e.newline+indent+e.unit)
+		inner := strings.TrimRight(e.src[container.start+1:container.end-1], " \t\r\n")
+		if inner == "" {
+			e.src = e.src[:container.start+1] + text + e.newline + indent + e.src[container.end-1:]
+		} else {
+			// Comments in an otherwise empty container stay before the items.
+			pos := container.start + 1 + len(inner)
+			e.src = e.src[:pos] + text + e.src[pos:]
+		}
+		return
+	}
+	if !strings.Contains(e.src[container.start:container.end], "\n") {
+		// Single-line containers stay on one line.
+		e.src = e.src[:last.end] + ", " + strings.Join(items, ", ") + e.src[last.end:]
+		return
+	}
+	// Find the comma after the last item, if any, and the end of its line, skipping comments.
+	p := &jsoncParser{src: e.src, pos: last.end}
+	p.skip()
+	trailingComma := p.pos < len(e.src) && e.src[p.pos] == ','
+	pos := last.end
+	if trailingComma {
+		pos = p.pos + 1
+	}
+	lineEnd := pos
+	for lineEnd < len(e.src) {
+		rest := e.src[lineEnd:]
+		if c := rest[0]; c == ' ' || c == '\t' {
+			lineEnd++
+		} else if strings.HasPrefix(rest, "//") {
+			lineEnd += strings.IndexAny(rest+"\n", "\r\n")
+		} else if strings.HasPrefix(rest, "/*") && !strings.Contains(rest[:strings.Index(rest, "*/")+2], "\n") {
+			lineEnd += strings.Index(rest, "*/") + 2
+		} else {
+			break
+		}
+	}
+	onOwnLine := lineEnd >= len(e.src) || e.src[lineEnd] == '\n' || strings.HasPrefix(e.src[lineEnd:], "\r\n")
+	indent := e.indentOf(last.start)
+	if container.kind == '{' {
+		indent = e.indentOf(container.members[len(container.members)-1].keyStart)
+	}
+	text := e.newline + indent + strings.Join(items, ","+e.newline+indent)
+	if trailingComma {
+		text += ","
+	}
+	if !onOwnLine {
+		lineEnd = pos
+	}
+	if trailingComma {
+		e.src = e.src[:lineEnd] + text + e.src[lineEnd:]
+		return
+	}
+	e.src = e.src[:pos] + "," + e.src[pos:lineEnd] + text + e.src[lineEnd:]
+}
+
+// remove removes the item at index, a member or element, from container, together with the comma
+// separating it from its neighbours. A trailing comma after the last item is kept.
+func (e *jsoncEditor) remove(container *jsoncValue, index int) {
+	var starts []int
+	var items []*jsoncValue
+	for _, m := range container.members {
+		starts = append(starts, m.keyStart)
+		items = append(items, m.value)
+	}
+	for _, element := range container.elements {
+		starts = append(starts, element.start)
+		items = append(items, element)
+	}
+	switch {
+	case len(items) == 1:
+		e.src = e.src[:container.start+1] + e.src[container.end-1:]
+	case index+1 < len(items):
+		e.src = e.src[:starts[index]] + e.src[starts[index+1]:]
+	default:
+		e.src = e.src[:items[index-1].end] + e.src[items[index].end:]
+	}
+}
+
+// quoteJSON returns s as a JSON string.
+func quoteJSON(s string) string {
+	var b strings.Builder
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(s)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// tsconfigFile is a tsconfig file being patched, and the configs it extends.
+type tsconfigFile struct {
+	path    string
+	editor  *jsoncEditor
+	root    *jsoncValue
+	extends []*tsconfigFile // in the order listed; later ones take precedence
+	added   []configEntry   // entries inserted so far
+}
+
+// configEntry is an entry of the project configuration: one -check reports missing, or one the
+// generator added, recorded so that clean -revert can remove it.
+type configEntry struct {
+	File string `json:"file"`
+	// Key is the dotted path of the object or array the entry belongs in; "" for the top level.
+	Key   string `json:"key"`
+	Entry string `json:"entry"`
+}
+
+// loadTsconfig parses the tsconfig at path and, recursively, the configs it extends. Configs
+// already in seen are shared, which also ends extends cycles.
+func loadTsconfig(path string, seen map[string]*tsconfigFile) (*tsconfigFile, error) {
+	if f, ok := seen[path]; ok {
+		return f, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &tsconfigFile{path: path, editor: newJSONCEditor(string(data))}
+	seen[path] = f
+	if f.root, err = parseJSONC(f.editor.src); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	if f.root.kind != '{' {
+		return nil, fmt.Errorf("%s is not an object", path)
+	}
+	var specs []string
+	if ext := f.root.member("extends"); ext != nil && ext.kind == '"' {
+		specs = append(specs, ext.str)
+	} else if ext != nil && ext.kind == '[' {
+		for _, element := range ext.elements {
+			specs = append(specs, element.str)
+		}
+	}
+	for _, spec := range specs {
+		basePath := resolveExtends(filepath.Dir(path), spec)
+		if basePath == "" {
+			log.Printf("Cannot resolve %q extended by %s; its settings are not taken into account", spec, path)
+			continue
+		}
+		base, err := loadTsconfig(basePath, seen)
+		if err != nil {
+			return nil, err
+		}
+		f.extends = append(f.extends, base)
+	}
+	return f, nil
+}
+
+// resolveExtends returns the file an extends entry of a config in dir refers to: a path relative to
+// dir, or a package in node_modules. It returns "" if there is none.
+func resolveExtends(dir, spec string) string {
+	var candidates []string
+	if filepath.IsAbs(spec) || strings.HasPrefix(spec, "./") || strings.HasPrefix(spec, "../") {
+		path := spec
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, spec)
+		}
+		candidates = []string{path, path + ".json"}
+	} else {
+		for d := dir; ; d = filepath.Dir(d) {
+			path := filepath.Join(d, "node_modules", filepath.FromSlash(spec))
+			candidates = append(candidates, path, path+".json", filepath.Join(path, "tsconfig.json"))
+			if filepath.Dir(d) == d {
+				break
+			}
+		}
+	}
+	for _, path := range candidates {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
+
+// chain returns f and the configs it extends in the order TypeScript looks up a setting: f first,
+// then the configs it extends, the last listed first.
+func (f *tsconfigFile) chain() []*tsconfigFile {
+	var chain []*tsconfigFile
+	seen := make(map[*tsconfigFile]bool)
+	var walk func(f *tsconfigFile)
+	walk = func(f *tsconfigFile) {
+		if seen[f] {
+			return
+		}
+		seen[f] = true
+		chain = append(chain, f)
+		for i := len(f.extends) - 1; i >= 0; i-- {
+			walk(f.extends[i])
+		}
+	}
+	walk(f)
+	return chain
+}
+
+// lookup returns the value at the member path keys of f's root.
+func (f *tsconfigFile) lookup(keys ...string) *jsoncValue {
+	v := f.root
+	for _, key := range keys {
+		v = v.member(key)
+	}
+	return v
+}
+
+// owner returns the nearest config of f's chain setting keys, or nil.
+func (f *tsconfigFile) owner(keys ...string) *tsconfigFile {
+	for _, c := range f.chain() {
+		if c.lookup(keys...) != nil {
+			return c
+		}
+	}
+	return nil
+}
+
+// insert inserts items into the container at keys, and parses f again.
+func (f *tsconfigFile) insert(items []string, keys ...string) error {
+	if len(items) == 0 {
+		return nil
+	}
+	f.editor.insert(f.lookup(keys...), items)
+	for _, item := range items {
+		f.added = append(f.added, configEntry{File: f.path, Key: strings.Join(keys, "."), Entry: item})
+	}
+	root, err := parseJSONC(f.editor.src)
+	if err != nil {
+		return fmt.Errorf("failed to patch %s: %v", f.path, err)
+	}
+	f.root = root
+	return nil
+}
+
+// baseURL returns the absolute compilerOptions.baseUrl in effect for f, or "" if none is set. Paths
+// resolve against it, or else against the directory of the config setting them.
+func (f *tsconfigFile) baseURL() string {
+	if c := f.owner("compilerOptions", "baseUrl"); c != nil {
+		if v := c.lookup("compilerOptions", "baseUrl"); v.kind == '"' {
+			return filepath.Join(filepath.Dir(c.path), filepath.FromSlash(v.str))
+		}
+	}
+	return ""
+}
+
+// replace replaces value, in the container at keys, with text, records entry as added, and parses
+// f again.
+func (f *tsconfigFile) replace(value *jsoncValue, text, entry string, keys ...string) error {
+	f.editor.src = f.editor.src[:value.start] + text + f.editor.src[value.end:]
+	f.added = append(f.added, configEntry{File: f.path, Key: strings.Join(keys, "."), Entry: entry})
+	root, err := parseJSONC(f.editor.src)
+	if err != nil {
+		return fmt.Errorf("failed to patch %s: %v", f.path, err)
+	}
+	f.root = root
+	return nil
+}
+
+// editable reports whether f belongs to the project and may be patched, unlike shared configs
+// installed in node_modules.
+func (f *tsconfigFile) editable() bool {
+	rel, err := filepath.Rel(projectRoot, f.path)
+	return err == nil && !strings.HasPrefix(rel, "..") &&
+		!strings.Contains(filepath.ToSlash(rel), "node_modules/")
+}
+
+// relativeTo returns path relative to dir, in the "./" form tsconfig paths use.
+func relativeTo(dir, path string) string {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == "." {
+		return "."
+	}
+	if !strings.HasPrefix(rel, "../") {
+		rel = "./" + rel
+	}
+	return rel
+}
+
+// tsconfigIncludes returns the include globs generated code needs, relative to dir.
+func tsconfigIncludes(dir string) []string {
+	if rel := relativeTo(dir, projectRoot); rel != "." {
+		return []string{rel + "/**/*.ts", rel + "/restate.gen/**/*.ts"}
+	}
+	return []string{"**/*.ts", "./**/*.ts", "./restate.gen/**/*.ts"}
+}
+
+// generatedIncludes returns the include glob of the generated code alone, relative to dir, for
+// projects referenced by the project's tsconfig.json.
+func generatedIncludes(dir string) []string {
+	return []string{relativeTo(dir, filepath.Join(projectRoot, "restate.gen")) + "/**/*.ts"}
+}
+
+// tsconfigPaths returns the path aliases generated code is imported through, relative to base,
+// the directory paths are resolved against.
+func tsconfigPaths(base string) [][2]string {
+	gen := filepath.Join(projectRoot, "restate.gen")
+	return [][2]string{
+		{"~restate", relativeTo(base, filepath.Join(gen, "index"+outputExt()))},
+		{"~restate/*", relativeTo(base, gen) + "/*"},
+	}
+}
+
+// updateTsConfig adds the ~restate path aliases and the include globs of generated code to the
+// project's tsconfig.json, or to the config it extends that sets them, and to the projects it
+// references that import generated code. Only missing entries are inserted; comments and
+// formatting are kept.
+func updateTsConfig(root string) error {
+	files, err := planTsConfig(root)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		log.Printf("Added the required paths and include entries to %s", f.path)
+		if err := ioutil.WriteFile(f.path, []byte(f.editor.src), 0644); err != nil {
+			return err
+		}
+		recordModifications(root, f.added, nil)
+	}
+	if len(files) > 0 {
+		restartEncore("tsconfig.json changed")
+	}
+	return nil
+}
+
+// planTsConfig patches the configs updateTsConfig would in memory, and returns those that changed.
+// Unchanged content is not written back, so watching tsconfig.json does not loop.
+func planTsConfig(root string) ([]*tsconfigFile, error) {
+	seen := make(map[string]*tsconfigFile)
+	leaf, err := loadTsconfig(filepath.Join(root, "tsconfig.json"), seen)
+	if err != nil {
+		return nil, err
+	}
+	references, err := referencedProjects(leaf, seen)
+	if err != nil {
+		return nil, err
+	}
+	original := make(map[*tsconfigFile]string)
+	for _, f := range seen {
+		original[f] = f.editor.src
+	}
+
+	// Subpath imports are resolved through package.json, see updatePackageImports.
+	aliases := projectConfig.Aliases == aliasesPaths
+	if aliases {
+		if err := patchPaths(leaf); err != nil {
+			return nil, err
+		}
+	}
+	if err := patchInclude(leaf, tsconfigIncludes); err != nil {
+		return nil, err
+	}
+	for _, project := range references {
+		if aliases {
+			if err := patchPaths(project); err != nil {
+				return nil, err
+			}
+		}
+		if err := patchInclude(project, generatedIncludes); err != nil {
+			return nil, err
+		}
+	}
+	var changed []*tsconfigFile
+	for _, f := range seen {
+		if f.editor.src != original[f] {
+			changed = append(changed, f)
+		}
+	}
+	sort.Slice(changed, func(i, j int) bool { return changed[i].path < changed[j].path })
+	return changed, nil
+}
+
+// referencedProjects returns the projects the project of leaf references, directly or through
+// other references, that belong to the project and import generated code.
+func referencedProjects(leaf *tsconfigFile, seen map[string]*tsconfigFile) ([]*tsconfigFile, error) {
+	var projects []*tsconfigFile
+	visited := map[*tsconfigFile]bool{leaf: true}
+	queue := []*tsconfigFile{leaf}
+	for len(queue) > 0 {
+		f := queue[0]
+		queue = queue[1:]
+		references := f.lookup("references")
+		if references == nil || references.kind != '[' {
+			continue
+		}
+		for _, reference := range references.elements {
+			path := reference.member("path")
+			if path == nil || path.kind != '"' {
+				continue
+			}
+			file := filepath.Join(filepath.Dir(f.path), filepath.FromSlash(path.str))
+			if info, err := os.Stat(file); err == nil && info.IsDir() {
+				file = filepath.Join(file, "tsconfig.json")
+			}
+			if _, err := os.Stat(file); err != nil {
+				log.Printf("Cannot find the project %q referenced by %s", path.str, f.path)
+				continue
+			}
+			project, err := loadTsconfig(file, seen)
+			if err != nil {
+				return nil, err
+			}
+			if visited[project] {
+				continue
+			}
+			visited[project] = true
+			queue = append(queue, project)
+			if project.editable() && importsGeneratedCode(filepath.Dir(project.path)) {
+				projects = append(projects, project)
+			}
+		}
+	}
+	return projects, nil
+}
+
+// importsGeneratedCode reports whether a source file in dir, or below it, imports through the
+// ~restate aliases or #restate subpath imports.
+func importsGeneratedCode(dir string) bool {
+	found := false
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || found {
+			return filepath.SkipDir
+		}
+		if info.IsDir() {
+			name := info.Name()
+			if path != dir && (name == "node_modules" || name == "restate.gen" || name == "encore.gen" || strings.HasPrefix(name, ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !isSourceFile(path) {
+			return nil
+		}
+		data, err := ioutil.ReadFile(path)
+		if err == nil && (strings.Contains(string(data), `"~restate`) || strings.Contains(string(data), `"#restate`)) {
+			found = true
+		}
+		return nil
+	})
+	return found
+}
+
+// patchPaths adds the ~restate aliases to the compilerOptions.paths in effect for leaf. They are
+// added where paths are set, or, if that is a shared config outside the project, to leaf together
+// with a copy of the inherited paths, which leaf's own paths would otherwise replace.
+func patchPaths(leaf *tsconfigFile) error {
+	baseURL := leaf.baseURL()
+	base := func(f *tsconfigFile) string {
+		if baseURL != "" {
+			return baseURL
+		}
+		return filepath.Dir(f.path)
+	}
+	target := leaf.owner("compilerOptions", "paths")
+	var inherited []string
+	if target != nil && !target.editable() {
+		for _, m := range target.lookup("compilerOptions", "paths").members {
+			if m.value.kind != '[' {
+				continue
+			}
+			var values []string
+			for _, element := range m.value.elements {
+				abs := filepath.Join(base(target), filepath.FromSlash(element.str))
+				values = append(values, quoteJSON(relativeTo(base(leaf), abs)))
+			}
+			inherited = append(inherited, quoteJSON(m.key)+": ["+strings.Join(values, ", ")+"]")
+		}
+		target = nil
+	}
+	if target == nil {
+		target = leaf
+		if options := leaf.lookup("compilerOptions"); options == nil {
+			if err := target.insert([]string{`"compilerOptions": {}`}); err != nil {
+				return err
+			}
+		} else if options.kind != '{' {
+			return nil
+		}
+		created := []string{`"paths": {}`}
+		if baseURL == "" && pathsNeedBaseURL() {
+			created = []string{`"baseUrl": "."`, `"paths": {}`}
+			baseURL = filepath.Dir(leaf.path)
+		}
+		if err := target.insert(created, "compilerOptions"); err != nil {
+			return err
+		}
+		if err := target.insert(inherited, "compilerOptions", "paths"); err != nil {
+			return err
+		}
+	}
+	paths := target.lookup("compilerOptions", "paths")
+	if paths.kind != '{' {
+		return nil
+	}
+	var missing []string
+	for _, alias := range tsconfigPaths(base(target)) {
+		entry := quoteJSON(alias[0]) + ": [" + quoteJSON(alias[1]) + "]"
+		current := paths.member(alias[0])
+		switch {
+		case current == nil:
+			missing = append(missing, entry)
+		case mapsTo(paths, alias[0], base(target), alias[1]):
+		case legacyAlias(current):
+			// Earlier versions wrote the targets relative to the config, ignoring baseUrl.
+			if err := target.replace(current, "["+quoteJSON(alias[1])+"]", entry, "compilerOptions", "paths"); err != nil {
+				return err
+			}
+			paths = target.lookup("compilerOptions", "paths")
+		default:
+			log.Printf("Warning: %s maps %s elsewhere than to the generated code; with baseUrl and paths as configured, it should be %s", target.path, alias[0], entry)
+		}
+	}
+	return target.insert(missing, "compilerOptions", "paths")
+}
+
+// legacyAlias reports whether value is an alias target written by earlier versions, which did not
+// take baseUrl into account.
+func legacyAlias(value *jsoncValue) bool {
+	if value.kind != '[' || len(value.elements) != 1 {
+		return false
+	}
+	switch value.elements[0].str {
+	case "./restate.gen/index.ts", "./restate.gen/index.js", "./restate.gen/*":
+		return true
+	}
+	return false
+}
+
+// checkTsConfig returns an error unless the compilerOptions.paths in effect for the project's
+// tsconfig.json map the ~restate aliases to the generated code. It replaces updateTsConfig when
+// tsconfig patching is disabled.
+func checkTsConfig(root string) error {
+	if projectConfig.Aliases != aliasesPaths {
+		return nil
+	}
+	leaf, err := loadTsconfig(filepath.Join(root, "tsconfig.json"), make(map[string]*tsconfigFile))
+	if err != nil {
+		return err
+	}
+	var paths *jsoncValue
+	base := leaf.baseURL()
+	if owner := leaf.owner("compilerOptions", "paths"); owner != nil {
+		paths = owner.lookup("compilerOptions", "paths")
+		if base == "" {
+			base = filepath.Dir(owner.path)
+		}
+	}
+	var missing []string
+	for _, alias := range tsconfigPaths(base) {
+		if !mapsTo(paths, alias[0], base, alias[1]) {
+			missing = append(missing, fmt.Sprintf("%q to %q", alias[0], alias[1]))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("tsconfig patching is disabled, but compilerOptions.paths in %s do not map %s", leaf.path, strings.Join(missing, " and "))
+	}
+	return nil
+}
+
+// mapsTo reports whether paths, resolved against base, map alias to the path value does.
+func mapsTo(paths *jsoncValue, alias, base, value string) bool {
+	if paths == nil || paths.kind != '{' {
+		return false
+	}
+	values := paths.member(alias)
+	if values == nil || values.kind != '[' {
+		return false
+	}
+	want := filepath.Join(base, filepath.FromSlash(value))
+	for _, element := range values.elements {
+		if element.kind == '"' && filepath.Join(base, filepath.FromSlash(element.str)) == want {
+			return true
+		}
+	}
+	return false
+}
+
+// pathsNeedBaseURL reports whether the installed TypeScript predates 4.1, which ignores paths
+// unless a baseUrl is set.
+func pathsNeedBaseURL() bool {
+	var major, minor int
+	if _, err := fmt.Sscanf(installedPackageVersion(projectRoot, "typescript"), "%d.%d", &major, &minor); err != nil {
+		return false
+	}
+	return major < 4 || major == 4 && minor < 1
+}
+
+// patchInclude adds the include globs, relative to the directory of the config they are added to,
+// to the include in effect for leaf, where it is set, or to leaf if that is a shared config
+// outside the project, together with a copy of the inherited globs.
+func patchInclude(leaf *tsconfigFile, globs func(dir string) []string) error {
+	target := leaf.owner("include")
+	var inherited []string
+	if target == nil && leaf.owner("files") == nil {
+		// Without include and files, TypeScript includes every file, which an explicit include
+		// of generated code alone would narrow.
+		inherited = []string{`"**/*"`}
+	}
+	if target != nil && !target.editable() {
+		for _, element := range target.lookup("include").elements {
+			abs := filepath.Join(filepath.Dir(target.path), filepath.FromSlash(element.str))
+			inherited = append(inherited, quoteJSON(relativeTo(filepath.Dir(leaf.path), abs)))
+		}
+		target = nil
+	}
+	if target == nil {
+		target = leaf
+		if err := target.insert([]string{`"include": []`}); err != nil {
+			return err
+		}
+		if err := target.insert(inherited, "include"); err != nil {
+			return err
+		}
+	}
+	include := target.lookup("include")
+	if include.kind != '[' {
+		return nil
+	}
+	present := make(map[string]bool)
+	for _, element := range include.elements {
+		present[element.str] = true
+	}
+	var missing []string
+	for _, glob := range globs(filepath.Dir(target.path)) {
+		if !present[glob] {
+			missing = append(missing, quoteJSON(glob))
+		}
+	}
+	return target.insert(missing, "include")
+}
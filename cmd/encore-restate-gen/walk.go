@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// walkDirs calls fn for root and every directory below it that is not ignored, see gitignore,
+// following symlinked directories. Each directory is visited once,
+// under the first path found to it: directories of the tree itself come before symlinks into it,
+// so a service symlinked from elsewhere in the project is not processed twice, and symlink cycles
+// end.
+func walkDirs(root string, fn func(dir string) error) error {
+	seen := make(map[string]bool)
+	var links []string
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		real, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return err
+		}
+		if seen[real] {
+			return nil
+		}
+		seen[real] = true
+		if err := fn(dir); err != nil {
+			return err
+		}
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			switch {
+			case entry.IsDir():
+				if projectIgnore.ignored(path, true) {
+					continue
+				}
+				if err := walk(path); err != nil {
+					return err
+				}
+			case entry.Mode()&os.ModeSymlink != 0:
+				if info, err := os.Stat(path); err == nil && info.IsDir() && !projectIgnore.ignored(path, true) {
+					links = append(links, path)
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(root); err != nil {
+		return err
+	}
+	for len(links) > 0 {
+		link := links[0]
+		links = links[1:]
+		if err := walk(link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// realDir returns dir with symlinks resolved, or dir itself if they cannot be.
+func realDir(dir string) string {
+	if real, err := filepath.EvalSymlinks(dir); err == nil {
+		return real
+	}
+	return dir
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// workerRequest is a request to the Node extraction worker.
+type workerRequest struct {
+	ID          int      `json:"id"`
+	Op          string   `json:"op"`
+	Dir         string   `json:"dir,omitempty"`
+	ServiceFile string   `json:"serviceFile,omitempty"`
+	ProjectRoot string   `json:"projectRoot,omitempty"`
+	Files       []string `json:"files,omitempty"`
+	// Base and Hash make an extract request incremental: if the worker's last extraction of Dir
+	// was of inputs with hash Base, only Files changed since and the rest is reused. Hash
+	// identifies the inputs of this request for the next one.
+	Base string `json:"base,omitempty"`
+	Hash string `json:"hash,omitempty"`
+	// Ignored are the names of files in Dir not to extract, e.g. editor temporary files.
+	Ignored []string `json:"ignored,omitempty"`
+}
+
+// workerResponse is the worker's answer to a request with the same ID.
+type workerResponse struct {
+	ID     int             `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  string          `json:"error"`
+}
+
+// extractionWorker is a long-lived Node process running the embedded extraction bundle in worker
+// mode. Requests are serialized; the process is started on first use and restarted if it dies.
+type extractionWorker struct {
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	nextID int
+	// stderr keeps the tail of the worker's stderr since the last request, for timeout reports.
+	stderr *tailBuffer
+}
+
+// errWorkerTimeout is returned when the worker does not answer within the extraction timeout.
+var errWorkerTimeout = errors.New("extraction timed out")
+
+// defaultExtractTimeout bounds a single worker request unless extractTimeoutMs is configured.
+const defaultExtractTimeout = 60 * time.Second
+
+// extractTimeout returns the configured extraction timeout.
+func extractTimeout() time.Duration {
+	if projectConfig.ExtractTimeoutMs > 0 {
+		return time.Duration(projectConfig.ExtractTimeoutMs) * time.Millisecond
+	}
+	return defaultExtractTimeout
+}
+
+// tailBuffer is an io.Writer keeping the last max bytes written to it.
+type tailBuffer struct {
+	mu  sync.Mutex
+	buf []byte
+	max int
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.max {
+		b.buf = b.buf[len(b.buf)-b.max:]
+	}
+	return len(p), nil
+}
+
+// lineLogger is an io.Writer passing each complete line written to it to log.Printf.
+type lineLogger struct {
+	mu     sync.Mutex
+	prefix string
+	buf    []byte
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if line := strings.TrimRight(string(l.buf[:i]), "\r"); line != "" {
+			log.Printf("%s%s", l.prefix, line)
+		}
+		l.buf = l.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) reset() {
+	b.mu.Lock()
+	b.buf = b.buf[:0]
+	b.mu.Unlock()
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.buf)
+}
+
+// workerPool hands out up to size Node workers, each started on first use.
+type workerPool struct {
+	workers chan *extractionWorker
+	all     []*extractionWorker
+}
+
+// newWorkerPool creates a pool of size workers.
+func newWorkerPool(size int) *workerPool {
+	if size < 1 {
+		size = 1
+	}
+	p := &workerPool{workers: make(chan *extractionWorker, size)}
+	for i := 0; i < size; i++ {
+		w := &extractionWorker{}
+		p.all = append(p.all, w)
+		p.workers <- w
+	}
+	return p
+}
+
+// call sends a request to the next free worker, waiting for one if all are busy.
+func (p *workerPool) call(req workerRequest) (json.RawMessage, error) {
+	w := <-p.workers
+	defer func() { p.workers <- w }()
+	return w.call(req)
+}
+
+// stop terminates all workers.
+func (p *workerPool) stop() {
+	for _, w := range p.all {
+		w.stop()
+	}
+}
+
+var nodeWorkers = newWorkerPool(1)
+
+var (
+	assetsDirMutex sync.Mutex
+	assetsDir      string
+)
+
+// workerAssetsDir extracts the embedded assets on first use and returns their directory.
+func workerAssetsDir() (string, error) {
+	assetsDirMutex.Lock()
+	defer assetsDirMutex.Unlock()
+	if assetsDir == "" {
+		dir, err := extractAssets()
+		if err != nil {
+			return "", fmt.Errorf("failed to extract embedded assets: %v", err)
+		}
+		assetsDir = dir
+	}
+	return assetsDir, nil
+}
+
+// JavaScript runtimes able to run the extraction bundle, in order of preference.
+const (
+	runtimeNode = "node"
+	runtimeBun  = "bun"
+	runtimeDeno = "deno"
+)
+
+var runtimeArgs = map[string][]string{
+	runtimeNode: nil,
+	runtimeBun:  {"run"},
+	runtimeDeno: {"run", "--allow-all"},
+}
+
+// jsRuntime returns the runtime running the extraction bundle: the configured one, or else the
+// first of node, bun and deno found on PATH.
+func jsRuntime() (string, error) {
+	if projectConfig.Runtime != "" {
+		if _, err := exec.LookPath(projectConfig.Runtime); err != nil {
+			return "", fmt.Errorf("configured runtime %s not found on PATH", projectConfig.Runtime)
+		}
+		return projectConfig.Runtime, nil
+	}
+	for _, name := range []string{runtimeNode, runtimeBun, runtimeDeno} {
+		if _, err := exec.LookPath(name); err == nil {
+			return name, nil
+		}
+	}
+	return "", errors.New("no JavaScript runtime (node, bun or deno) found on PATH")
+}
+
+// start launches the worker process. Callers must hold w.mu.
+func (w *extractionWorker) start() error {
+	dir, err := workerAssetsDir()
+	if err != nil {
+		return err
+	}
+	runtime, err := jsRuntime()
+	if err != nil {
+		return err
+	}
+	args := append(append([]string{}, runtimeArgs[runtime]...), filepath.Join(dir, "index.js"), "--worker")
+	cmd := exec.Command(runtime, args...)
+	cmd.Dir = dir
+	w.stderr = &tailBuffer{max: 8 << 10}
+	cmd.Stderr = io.MultiWriter(&lineLogger{prefix: runtime + " worker: "}, w.stderr)
+	setProcessGroup(cmd)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start %s worker: %v", runtime, err)
+	}
+	w.cmd = cmd
+	w.stdin = stdin
+	w.stdout = bufio.NewReaderSize(stdout, 1<<20)
+	return nil
+}
+
+// kill terminates the worker process. Callers must hold w.mu.
+func (w *extractionWorker) kill() {
+	if w.cmd == nil {
+		return
+	}
+	w.stdin.Close()
+	killProcessGroup(w.cmd)
+	w.cmd.Wait()
+	w.cmd = nil
+}
+
+// roundTrip sends one request and reads its response, giving up after the extraction timeout.
+// On timeout the worker's process group is killed. Callers must hold w.mu.
+func (w *extractionWorker) roundTrip(req workerRequest) (*workerResponse, error) {
+	line, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	w.stderr.reset()
+	if _, err := w.stdin.Write(append(line, '\n')); err != nil {
+		return nil, err
+	}
+	type result struct {
+		resp *workerResponse
+		err  error
+	}
+	done := make(chan result, 1)
+	stdout := w.stdout
+	go func() {
+		for {
+			out, err := stdout.ReadBytes('\n')
+			if err != nil {
+				done <- result{err: err}
+				return
+			}
+			// Anything but a response, e.g. output of a library writing to stdout directly, is
+			// logged and skipped.
+			var resp workerResponse
+			if err := json.Unmarshal(out, &resp); err != nil || resp.ID == 0 && resp.Error == "" {
+				if line := strings.TrimSpace(string(out)); line != "" {
+					log.Printf("Ignoring unexpected worker output: %s", line)
+				}
+				continue
+			}
+			if resp.ID == req.ID {
+				done <- result{resp: &resp}
+				return
+			}
+		}
+	}()
+	select {
+	case r := <-done:
+		return r.resp, r.err
+	case <-time.After(extractTimeout()):
+		partial := w.stderr.String()
+		w.kill()
+		if partial != "" {
+			log.Printf("Partial Node worker output before timeout:\n%s", partial)
+		}
+		return nil, errWorkerTimeout
+	}
+}
+
+// call sends a request to the worker, starting it if necessary. If the worker died, it is
+// restarted and the request retried once.
+func (w *extractionWorker) call(req workerRequest) (json.RawMessage, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var resp *workerResponse
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if w.cmd == nil {
+			if err = w.start(); err != nil {
+				return nil, err
+			}
+		}
+		w.nextID++
+		req.ID = w.nextID
+		resp, err = w.roundTrip(req)
+		if err == nil {
+			break
+		}
+		if err == errWorkerTimeout {
+			return nil, fmt.Errorf("%v after %v", err, extractTimeout())
+		}
+		log.Printf("Node worker failed, restarting: %v", err)
+		w.kill()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("%s", resp.Error)
+	}
+	return resp.Result, nil
+}
+
+// stop terminates the worker. The extracted assets stay cached for the next run.
+func (w *extractionWorker) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.kill()
+}
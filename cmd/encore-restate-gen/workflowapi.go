@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// WorkflowAPI describes the public endpoints managing the workflow of a service, generated for
+// the services listed in workflowEndpoints.
+type WorkflowAPI struct {
+	// Path is the base path of the endpoints, /workflows/<service name>.
+	Path string
+	// Workflow is the Restate name of the workflow.
+	Workflow string
+	// Input and Output are the TypeScript types of the input and output of its run handler.
+	Input  string
+	Output string
+	// Progress is the shared handler reporting the progress of a run, or "", and ProgressType the
+	// TypeScript type of its output.
+	Progress     string
+	ProgressType string
+	// Auth requires the app's auth handler to accept a call, unless publicWorkflowEndpoints lists
+	// the service.
+	Auth bool
+}
+
+// WorkflowAPI returns the workflow management endpoints of the service, or nil if workflowEndpoints
+// does not list it or its workflow has no run handler.
+func (d TemplateData) WorkflowAPI() *WorkflowAPI {
+	if !stringIn(projectConfig.WorkflowEndpoints, d.ServiceName) {
+		return nil
+	}
+	var api *WorkflowAPI
+	progress := projectConfig.WorkflowProgress[d.ServiceName]
+	progressType := "any"
+	for _, g := range d.WorkflowGroup {
+		for _, h := range g.Handlers {
+			switch h.ExportName {
+			case "run":
+				api = &WorkflowAPI{
+					Path:     "/workflows/" + d.ServiceName,
+					Workflow: d.ServiceNameTrimmed + "Workflow",
+					Input:    schemaType(h.InputSchema),
+					Output:   schemaType(h.OutputSchema),
+					Auth:     !stringIn(projectConfig.PublicWorkflowEndpoints, d.ServiceName),
+				}
+			case progress:
+				progressType = schemaType(h.OutputSchema)
+			}
+		}
+	}
+	if api != nil && progress != "" {
+		api.Progress, api.ProgressType = progress, progressType
+	}
+	return api
+}
+
+// stringIn reports whether list contains s.
+func stringIn(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+var identifierRe = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// schemaType returns the TypeScript type of the JSON schema raw, as an inline type Encore can
+// parse for API schemas. Schemas that are missing or cannot be expressed yield any.
+func schemaType(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return "any"
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(raw, &schema); err != nil {
+		return "any"
+	}
+	return schemaTypeOf(schema)
+}
+
+// schemaTypeOf returns the TypeScript type of a decoded JSON schema.
+func schemaTypeOf(schema map[string]interface{}) string {
+	if c, ok := schema["const"]; ok {
+		return literalType(c)
+	}
+	if values, ok := schema["enum"].([]interface{}); ok && len(values) > 0 {
+		var types []string
+		for _, v := range values {
+			types = append(types, literalType(v))
+		}
+		return strings.Join(types, " | ")
+	}
+	for _, key := range []string{"anyOf", "oneOf"} {
+		if alternatives, ok := schema[key].([]interface{}); ok && len(alternatives) > 0 {
+			var types []string
+			for _, a := range alternatives {
+				sub, _ := a.(map[string]interface{})
+				types = append(types, schemaTypeOf(sub))
+			}
+			return strings.Join(types, " | ")
+		}
+	}
+	switch t := schema["type"].(type) {
+	case string:
+		return schemaTypeNamed(t, schema)
+	case []interface{}:
+		var types []string
+		for _, name := range t {
+			if s, ok := name.(string); ok {
+				types = append(types, schemaTypeNamed(s, schema))
+			}
+		}
+		if len(types) > 0 {
+			return strings.Join(types, " | ")
+		}
+	}
+	return "any"
+}
+
+// schemaTypeNamed returns the TypeScript type of schema for its JSON type name.
+func schemaTypeNamed(name string, schema map[string]interface{}) string {
+	switch name {
+	case "string":
+		return "string"
+	case "number", "integer":
+		return "number"
+	case "boolean":
+		return "boolean"
+	case "null":
+		return "null"
+	case "array":
+		items, _ := schema["items"].(map[string]interface{})
+		item := "any"
+		if items != nil {
+			item = schemaTypeOf(items)
+		}
+		if strings.ContainsAny(item, "|&") {
+			item = "(" + item + ")"
+		}
+		return item + "[]"
+	case "object":
+		properties, _ := schema["properties"].(map[string]interface{})
+		if len(properties) == 0 {
+			if additional, ok := schema["additionalProperties"].(map[string]interface{}); ok {
+				return "Record<string, " + schemaTypeOf(additional) + ">"
+			}
+			return "Record<string, any>"
+		}
+		required := make(map[string]bool)
+		if list, ok := schema["required"].([]interface{}); ok {
+			for _, r := range list {
+				if s, ok := r.(string); ok {
+					required[s] = true
+				}
+			}
+		}
+		var names []string
+		for name := range properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var fields []string
+		for _, name := range names {
+			sub, _ := properties[name].(map[string]interface{})
+			key := name
+			if !identifierRe.MatchString(name) {
+				b, _ := json.Marshal(name)
+				key = string(b)
+			}
+			if !required[name] {
+				key += "?"
+			}
+			fields = append(fields, key+": "+schemaTypeOf(sub))
+		}
+		return "{ " + strings.Join(fields, "; ") + " }"
+	}
+	return "any"
+}
+
+// literalType returns the TypeScript literal type of a JSON value.
+func literalType(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "any"
+	}
+	return string(b)
+}
@@ -2,7 +2,10 @@ module github.com/sebastianhindhede/encore-restate-gen
 
 go 1.24.0
 
-require github.com/fsnotify/fsnotify v1.8.0
+require (
+	github.com/evanw/esbuild v0.28.2
+	github.com/fsnotify/fsnotify v1.8.0
+)
 
 require (
 	github.com/goccy/go-json v0.10.2 // indirect